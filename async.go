@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// Future states
+const (
+	futurePending int32 = iota
+	futureRunning
+	futureCanceled
+)
+
+// Future is the pending result of ThenAsync.
+type Future struct {
+	input  any
+	ctx    context.Context
+	cancel context.CancelFunc
+	state  atomic.Int32
+	done   chan struct{}
+
+	resultCtx context.Context
+	output    any
+	err       error
+}
+
+// Done returns a channel that is closed once the execution completes.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the execution completes and returns the context, output
+// and error of the chain.
+func (f *Future) Wait() (context.Context, any, error) {
+	<-f.done
+	return f.resultCtx, f.output, f.err
+}
+
+// Cancel cancels the context of the execution. An execution still waiting
+// for its executor completes at once with context.Canceled; a running one
+// completes when its steps observe the cancellation.
+func (f *Future) Cancel() {
+	f.cancel()
+	if f.state.CompareAndSwap(futurePending, futureCanceled) {
+		f.complete(f.ctx, nil, context.Canceled)
+	}
+}
+
+// result returns the outcome of a completed execution.
+func (f *Future) result(index int) Result {
+	return Result{Index: index, Input: f.input, Context: f.resultCtx, Output: f.output, Err: f.err}
+}
+
+// complete records the outcome of the execution.
+func (f *Future) complete(ctx context.Context, output any, err error) {
+	f.resultCtx, f.output, f.err = ctx, output, err
+	f.cancel()
+	close(f.done)
+}
+
+// run executes the chain, unless the future was canceled while queued. A
+// panic is reported as a *PanicError.
+func (f *Future) run(cc *CompiledChain) {
+	if !f.state.CompareAndSwap(futurePending, futureRunning) {
+		return
+	}
+
+	ctx, output, err := f.ctx, any(nil), error(nil)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			ctx, output, err = f.ctx, nil, &PanicError{Value: recovered, Stack: debug.Stack()}
+		}
+		f.complete(ctx, output, err)
+	}()
+
+	ctx, output, err = cc.Then(f.ctx, f.input)
+}
+
+// Executor bounds the number of goroutines running asynchronous executions.
+// Executions beyond the limit wait in a queue without holding a goroutine.
+// Workers are started on demand and exit when the queue is empty.
+//
+// An Executor is safe for concurrent use by multiple goroutines.
+type Executor struct {
+	mu      sync.Mutex
+	queue   []func()
+	workers int
+	max     int
+}
+
+// NewExecutor creates an Executor running at most maxGoroutines executions at
+// a time. A value below 1 means 1.
+//
+// Example:
+//
+//	executor := middleware.NewExecutor(16)
+//	future := chain.ThenAsync(ctx, input, middleware.WithExecutor(executor))
+func NewExecutor(maxGoroutines int) *Executor {
+	return &Executor{max: max(maxGoroutines, 1)}
+}
+
+// Go queues a task, starting a worker if the limit allows it.
+func (e *Executor) Go(task func()) {
+	e.mu.Lock()
+	e.queue = append(e.queue, task)
+	if e.workers >= e.max {
+		e.mu.Unlock()
+		return
+	}
+	e.workers++
+	e.mu.Unlock()
+
+	go e.work()
+}
+
+// work runs queued tasks until the queue is empty.
+func (e *Executor) work() {
+	for {
+		e.mu.Lock()
+		if len(e.queue) == 0 {
+			e.workers--
+			e.mu.Unlock()
+			return
+		}
+		task := e.queue[0]
+		e.queue[0] = nil
+		e.queue = e.queue[1:]
+		e.mu.Unlock()
+
+		task()
+	}
+}
+
+// AsyncOption configures ThenAsync.
+type AsyncOption func(*asyncConfig)
+
+// asyncConfig holds the options of an asynchronous execution.
+type asyncConfig struct {
+	executor *Executor
+}
+
+// WithExecutor runs the execution on an Executor, bounding the number of
+// concurrent executions. Without it, every execution gets its own goroutine.
+func WithExecutor(executor *Executor) AsyncOption {
+	return func(c *asyncConfig) {
+		c.executor = executor
+	}
+}
+
+// ThenAsync starts the chain in the background, like Chain.ThenAsync.
+func (cc *CompiledChain) ThenAsync(ctx context.Context, input any, opts ...AsyncOption) *Future {
+	var config asyncConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	f := &Future{input: input, done: make(chan struct{})}
+	f.ctx, f.cancel = context.WithCancel(ForkMetadata(ctx))
+
+	// A queued execution whose context is done does not wait for a worker
+	stop := context.AfterFunc(f.ctx, func() {
+		if f.state.CompareAndSwap(futurePending, futureCanceled) {
+			f.complete(f.ctx, nil, context.Cause(f.ctx))
+		}
+	})
+
+	task := func() {
+		stop()
+		f.run(cc)
+	}
+
+	if config.executor != nil {
+		config.executor.Go(task)
+	} else {
+		go task()
+	}
+
+	return f
+}
+
+// ThenAsync starts the chain in the background and returns a Future for its
+// result. The execution runs with its own copy of the metadata, so it does not
+// race with the caller, and its context is canceled by Future.Cancel or when
+// ctx is done. Use context.WithoutCancel for fire-and-forget executions that
+// must outlive the request starting them.
+//
+// A panic in the chain is reported as a *PanicError instead of crashing the
+// process. WithExecutor bounds the number of concurrent executions.
+//
+// Example:
+//
+//	inventory := inventoryChain.ThenAsync(ctx, order)
+//	pricing := pricingChain.ThenAsync(ctx, order)
+//
+//	results, err := middleware.WaitAll(ctx, inventory, pricing)
+//	if err != nil {
+//		return ctx, nil, err
+//	}
+func (c *Chain) ThenAsync(ctx context.Context, input any, opts ...AsyncOption) *Future {
+	return c.Compile().ThenAsync(ctx, input, opts...)
+}
+
+// WaitAll waits for every future and returns their results, in argument
+// order. It returns at once when an execution fails, with the error of that
+// execution, or when ctx is done, with the context's error; the results of
+// futures that have not completed then hold that error. Like WaitAny, it does
+// not cancel the futures still running.
+//
+// Example:
+//
+//	results, err := middleware.WaitAll(ctx, futures...)
+//	if err != nil {
+//		for _, f := range futures {
+//			f.Cancel()
+//		}
+//		return ctx, nil, err
+//	}
+func WaitAll(ctx context.Context, futures ...*Future) ([]Result, error) {
+	results := make([]Result, len(futures))
+	completed := make([]bool, len(futures))
+
+	done, stop := watch(futures)
+	defer stop()
+
+	for range futures {
+		var err error
+		select {
+		case i := <-done:
+			results[i], completed[i] = futures[i].result(i), true
+			err = results[i].Err
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		if err == nil {
+			continue
+		}
+
+		for i, f := range futures {
+			if completed[i] {
+				continue
+			}
+			select {
+			case <-f.Done():
+				results[i] = f.result(i)
+			default:
+				results[i] = Result{Index: i, Input: f.input, Context: ctx, Err: err}
+			}
+		}
+		return results, err
+	}
+
+	return results, nil
+}
+
+// WaitAny waits for the first future to complete and returns its result,
+// whose Index is the position of the future in the arguments. It returns the
+// context's error when ctx is done first, and an error when no future is
+// given. The other futures keep running; cancel them if their results are not
+// needed.
+//
+// Example:
+//
+//	first, err := middleware.WaitAny(ctx, primary, fallback)
+func WaitAny(ctx context.Context, futures ...*Future) (Result, error) {
+	if len(futures) == 0 {
+		return Result{}, errors.New("middleware: WaitAny needs at least one future")
+	}
+
+	done, stop := watch(futures)
+	defer stop()
+
+	select {
+	case i := <-done:
+		return futures[i].result(i), nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+// watch sends the index of every future on done as it completes, until stop
+// is called.
+func watch(futures []*Future) (done <-chan int, stop func()) {
+	indexes := make(chan int, len(futures))
+	stopped := make(chan struct{})
+
+	for i, f := range futures {
+		go func() {
+			select {
+			case <-f.Done():
+				indexes <- i
+			case <-stopped:
+			}
+		}()
+	}
+
+	return indexes, func() { close(stopped) }
+}
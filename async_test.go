@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// blockUntil returns a step that waits until release is closed.
+func blockUntil(release <-chan struct{}) MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		<-release
+		return ctx, input, nil
+	}
+}
+
+// waitGoroutines waits until at most n goroutines are running and returns the
+// number it settled on.
+func waitGoroutines(n int) int {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestThenAsyncReturnsResult(t *testing.T) {
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return AddMetadata(ctx, "priced", true), input.(int) * 2, nil
+	})
+
+	ctx := context.Background()
+	future := chain.ThenAsync(ctx, 21)
+	resultCtx, output, err := future.Wait()
+
+	if err != nil || output != 42 {
+		t.Fatalf("Wait() = %v, %v; want 42", output, err)
+	}
+	if priced, _ := GetMetadata(resultCtx, "priced"); priced != true {
+		t.Error("metadata of the execution missing from its context")
+	}
+	if _, leaked := GetMetadata(ctx, "priced"); leaked {
+		t.Error("metadata of the execution written to the caller's context")
+	}
+}
+
+func TestFutureCancelWhileQueued(t *testing.T) {
+	executor := NewExecutor(1)
+	release := make(chan struct{})
+	defer close(release)
+
+	ran := false
+	running := NewChain(blockUntil(release)).ThenAsync(context.Background(), nil, WithExecutor(executor))
+	queued := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		ran = true
+		return ctx, input, nil
+	}).ThenAsync(context.Background(), nil, WithExecutor(executor))
+
+	queued.Cancel()
+	select {
+	case <-queued.Done():
+	case <-time.After(time.Second):
+		t.Fatal("queued future still waiting for the executor after Cancel")
+	}
+	if _, _, err := queued.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v; want context.Canceled", err)
+	}
+
+	select {
+	case <-running.Done():
+		t.Error("running future completed while blocked")
+	default:
+	}
+
+	release <- struct{}{}
+	running.Wait()
+	if ran {
+		t.Error("canceled future ran once a worker was free")
+	}
+}
+
+func TestThenAsyncCompletesQueuedFutureWhenContextIsDone(t *testing.T) {
+	executor := NewExecutor(1)
+	release := make(chan struct{})
+	defer close(release)
+	NewChain(blockUntil(release)).ThenAsync(context.Background(), nil, WithExecutor(executor))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := NewChain(passThrough).ThenAsync(ctx, nil, WithExecutor(executor))
+	cancel()
+
+	select {
+	case <-queued.Done():
+	case <-time.After(time.Second):
+		t.Fatal("queued future still waiting after its context was canceled")
+	}
+}
+
+func TestExecutorBoundsGoroutines(t *testing.T) {
+	var g gauge
+	executor := NewExecutor(2)
+	chain := NewChain(g.step)
+
+	futures := make([]*Future, 20)
+	for i := range futures {
+		futures[i] = chain.ThenAsync(context.Background(), i, WithExecutor(executor))
+	}
+	if _, err := WaitAll(context.Background(), futures...); err != nil {
+		t.Fatal(err)
+	}
+
+	if peak := g.peak.Load(); peak > 2 {
+		t.Errorf("%d executions ran in parallel; want at most 2", peak)
+	}
+	// Workers exit once the queue is empty
+	executor.mu.Lock()
+	workers := executor.workers
+	executor.mu.Unlock()
+	if workers != 0 {
+		t.Errorf("%d workers left after the queue drained", workers)
+	}
+}
+
+func TestThenAsyncCapturesPanics(t *testing.T) {
+	future := NewChain(panicking).ThenAsync(context.Background(), nil)
+
+	var panicErr *PanicError
+	if _, _, err := future.Wait(); !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("Wait() error = %v; want a *PanicError", err)
+	}
+}
+
+func TestWaitAllReturnsResultsInOrder(t *testing.T) {
+	futures := []*Future{
+		NewChain(delayed).ThenAsync(context.Background(), 10),
+		NewChain(delayed).ThenAsync(context.Background(), 0),
+	}
+
+	results, err := WaitAll(context.Background(), futures...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{10, 0} {
+		if results[i].Index != i || results[i].Output != want {
+			t.Errorf("results[%d] = %+v; want output %d", i, results[i], want)
+		}
+	}
+}
+
+func TestWaitAllShortCircuitsOnError(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	errFailed := errors.New("failed")
+
+	slow := NewChain(blockUntil(release)).ThenAsync(context.Background(), "slow")
+	failing := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, errFailed
+	}).ThenAsync(context.Background(), "failing")
+
+	results, err := WaitAll(context.Background(), slow, failing)
+
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("WaitAll() error = %v; want %v", err, errFailed)
+	}
+	if results[0].Input != "slow" || !errors.Is(results[0].Err, errFailed) {
+		t.Errorf("result of the running future = %+v; want the failure", results[0])
+	}
+	if !errors.Is(results[1].Err, errFailed) {
+		t.Errorf("result of the failed future = %+v", results[1])
+	}
+}
+
+func TestWaitAllStopsWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	future := NewChain(blockUntil(release)).ThenAsync(context.Background(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	results, err := WaitAll(ctx, future)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("WaitAll() = %+v, %v; want context.DeadlineExceeded", results, err)
+	}
+}
+
+func TestWaitAnyReturnsFirstCompletion(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	release := make(chan struct{})
+	slow := NewChain(blockUntil(release)).ThenAsync(context.Background(), "slow")
+	fast := NewChain(passThrough).ThenAsync(context.Background(), "fast")
+
+	result, err := WaitAny(context.Background(), slow, fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Index != 1 || result.Output != "fast" {
+		t.Errorf("WaitAny() = %+v; want the fast future", result)
+	}
+
+	// Neither the slow future nor WaitAny leave goroutines behind
+	close(release)
+	slow.Wait()
+	if n := waitGoroutines(baseline); n > baseline {
+		t.Errorf("%d goroutines running; want at most %d", n, baseline)
+	}
+
+	if _, err := WaitAny(context.Background()); err == nil {
+		t.Error("WaitAny() without futures succeeded")
+	}
+}
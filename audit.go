@@ -0,0 +1,478 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+
+	// AuditUnknown is recorded when Audit runs outside of a chain, where the
+	// outcome of the request cannot be observed
+	AuditUnknown = "unknown"
+)
+
+// AuditRecord is an entry of the audit trail. Every record holds the hash of
+// the previous one, so that altering, removing or reordering records breaks
+// the chain and is detected by VerifyAuditRecords.
+type AuditRecord struct {
+	Sequence  uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor,omitempty"`
+	Action    string    `json:"action"`
+	RequestID string    `json:"request_id,omitempty"`
+	Chain     string    `json:"chain,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+
+	// Details holds the fields returned by AuditOptions.Details as a JSON
+	// object, encoded once in canonical form, with sorted keys and numbers
+	// kept as written, so that the hash is the same after the record is
+	// written and read back
+	Details json.RawMessage `json:"details,omitempty"`
+
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ComputeHash returns the hash of the record, covering every field but Hash.
+func (r AuditRecord) ComputeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SealAuditRecord links a record to the previous record of the trail, or
+// starts the trail when previous is nil: it sets the sequence number, the
+// previous hash and the hash of the record.
+func SealAuditRecord(record AuditRecord, previous *AuditRecord) (AuditRecord, error) {
+	record.Sequence = 1
+	record.PrevHash = ""
+	if previous != nil {
+		record.Sequence = previous.Sequence + 1
+		record.PrevHash = previous.Hash
+	}
+
+	hash, err := record.ComputeHash()
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	record.Hash = hash
+
+	return record, nil
+}
+
+// AuditSink stores audit records. The sink owns the hash chain, so every
+// Audit middleware writing to it continues the same trail.
+type AuditSink interface {
+	// Append seals the record with SealAuditRecord against the last record
+	// of the sink and stores it. Appends must be atomic: a sink shared by
+	// several processes, e.g. a database table, reads the last record and
+	// writes the new one in a single transaction
+	Append(ctx context.Context, record AuditRecord) (AuditRecord, error)
+
+	// Last returns the most recent record, and false if the sink is empty
+	Last(ctx context.Context) (AuditRecord, bool, error)
+}
+
+// AuditOptions configures the Audit middleware.
+type AuditOptions struct {
+	// Action names the audited action. When nil, the chain name is used, or
+	// the input type outside of named chains.
+	Action func(ctx context.Context, input any) string
+
+	// Details adds application-specific fields to the record
+	Details func(ctx context.Context, input any) map[string]any
+
+	// Logger reports records that could not be written. When nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// DefaultAuditOptions returns the default options for the Audit middleware.
+func DefaultAuditOptions() *AuditOptions {
+	return &AuditOptions{
+		Logger: slog.Default(),
+	}
+}
+
+// Audit creates a middleware that appends a record to a tamper-evident audit
+// trail for every request. The record holds the actor (from GetUserID), the
+// action, the request ID, the chain name, the outcome and a timestamp.
+//
+// Inside a chain the record is written when the chain finishes, so the
+// outcome is known and an actor set by later steps, e.g. authentication, is
+// included. A failure to write the record is logged; it does not fail the
+// request.
+//
+// The sink links every record to the last one it stored, so any number of
+// Audit middleware, in any number of chains, may write to the same sink.
+//
+// Example:
+//
+//	sink, err := middleware.OpenJSONLAuditSink("/var/log/app/audit.jsonl")
+//	if err != nil {
+//		return err
+//	}
+//	defer sink.Close()
+//
+//	chain := middleware.NewNamedChain("transfer",
+//		authenticate,
+//		middleware.Audit(sink, nil),
+//		transferFunds,
+//	)
+func Audit(sink AuditSink, opts *AuditOptions) MiddlewareFunc {
+	if opts == nil {
+		opts = DefaultAuditOptions()
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		timestamp := time.Now().UTC()
+		chainName, _ := GetChainName(ctx)
+
+		action := chainName
+		if opts.Action != nil {
+			action = opts.Action(ctx, input)
+		} else if action == "" {
+			action = typeName(input)
+		}
+
+		var details json.RawMessage
+		var detailsErr error
+		if opts.Details != nil {
+			details, detailsErr = canonicalJSON(opts.Details(ctx, input))
+		}
+
+		record := func(ctx context.Context, outcome string, err error) {
+			if detailsErr != nil {
+				logger.ErrorContext(ctx, "Failed to write audit record",
+					slog.String("action", action),
+					slog.String("error", detailsErr.Error()),
+				)
+				return
+			}
+
+			rec := AuditRecord{
+				Timestamp: timestamp,
+				Action:    action,
+				Chain:     chainName,
+				Outcome:   outcome,
+				Details:   details,
+			}
+			rec.Actor, _ = GetUserID(ctx)
+			rec.RequestID, _ = GetRequestID(ctx)
+			if err != nil {
+				rec.Error = err.Error()
+			}
+
+			if _, appendErr := sink.Append(ctx, rec); appendErr != nil {
+				logger.ErrorContext(ctx, "Failed to write audit record",
+					slog.String("action", action),
+					slog.String("error", appendErr.Error()),
+				)
+			}
+		}
+
+		st := stateFrom(ctx)
+		if st == nil {
+			record(ctx, AuditUnknown, nil)
+			return ctx, input, nil
+		}
+
+		st.onFinish(func(ctx context.Context, err error) {
+			if err != nil {
+				record(ctx, AuditFailure, err)
+				return
+			}
+			record(ctx, AuditSuccess, nil)
+		})
+
+		return ctx, input, nil
+	}
+}
+
+// canonicalJSON encodes details as a JSON object with sorted keys. Numbers
+// keep their textual form, so decoding and encoding the result again yields
+// the same bytes.
+func canonicalJSON(details map[string]any) (json.RawMessage, error) {
+	if len(details) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	// Struct values are encoded in field order; decoding them into maps and
+	// encoding again sorts every object's keys
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var canonical any
+	if err := decoder.Decode(&canonical); err != nil {
+		return nil, fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	return json.Marshal(canonical)
+}
+
+// AuditTamperError reports the first record that breaks the hash chain.
+type AuditTamperError struct {
+	Sequence uint64
+	Reason   string
+}
+
+func (e *AuditTamperError) Error() string {
+	return fmt.Sprintf("audit record %d: %s", e.Sequence, e.Reason)
+}
+
+// VerifyAuditRecords checks that records form an unbroken hash chain, in
+// order, starting with the first record of the trail. It returns an
+// *AuditTamperError describing the first record that was altered, removed or
+// reordered, or nil if the trail is intact.
+//
+// Example:
+//
+//	if err := middleware.VerifyAuditRecords(sink.Records()); err != nil {
+//		log.Fatalf("audit trail tampered: %v", err)
+//	}
+func VerifyAuditRecords(records []AuditRecord) error {
+	for i, record := range records {
+		hash, err := record.ComputeHash()
+		if err != nil {
+			return err
+		}
+
+		if hash != record.Hash {
+			return &AuditTamperError{Sequence: record.Sequence, Reason: "hash does not match content"}
+		}
+
+		if i == 0 {
+			// Removing the head of the trail must be detected too
+			if record.Sequence != 1 {
+				return &AuditTamperError{Sequence: record.Sequence, Reason: "expected sequence 1"}
+			}
+			if record.PrevHash != "" {
+				return &AuditTamperError{Sequence: record.Sequence, Reason: "first record has a previous hash"}
+			}
+			continue
+		}
+
+		previous := records[i-1]
+		if record.PrevHash != previous.Hash {
+			return &AuditTamperError{Sequence: record.Sequence, Reason: "previous hash does not match"}
+		}
+		if record.Sequence != previous.Sequence+1 {
+			return &AuditTamperError{Sequence: record.Sequence, Reason: fmt.Sprintf("expected sequence %d", previous.Sequence+1)}
+		}
+	}
+
+	return nil
+}
+
+// VerifyAuditLog reads audit records in the JSON Lines format and verifies
+// their hash chain with VerifyAuditRecords.
+//
+// Example:
+//
+//	file, _ := os.Open("/var/log/app/audit.jsonl")
+//	defer file.Close()
+//	err := middleware.VerifyAuditLog(file)
+func VerifyAuditLog(r io.Reader) error {
+	records, err := ReadAuditLog(r)
+	if err != nil {
+		return err
+	}
+
+	return VerifyAuditRecords(records)
+}
+
+// ReadAuditLog reads audit records in the JSON Lines format.
+func ReadAuditLog(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to decode audit record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return records, nil
+}
+
+// MemoryAuditSink is an in-memory AuditSink, suitable for tests.
+type MemoryAuditSink struct {
+	mu      sync.RWMutex
+	records []AuditRecord
+}
+
+// NewMemoryAuditSink creates an empty MemoryAuditSink.
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+// Append seals a record and stores it.
+func (s *MemoryAuditSink) Append(ctx context.Context, record AuditRecord) (AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var previous *AuditRecord
+	if len(s.records) > 0 {
+		previous = &s.records[len(s.records)-1]
+	}
+
+	record, err := SealAuditRecord(record, previous)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+
+	s.records = append(s.records, record)
+	return record, nil
+}
+
+// Last returns the most recent record.
+func (s *MemoryAuditSink) Last(ctx context.Context) (AuditRecord, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.records) == 0 {
+		return AuditRecord{}, false, nil
+	}
+
+	return s.records[len(s.records)-1], true, nil
+}
+
+// Records returns a copy of the stored records in write order.
+func (s *MemoryAuditSink) Records() []AuditRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]AuditRecord(nil), s.records...)
+}
+
+// JSONLAuditSink is an AuditSink that appends records to a file in the JSON
+// Lines format, one record per line. Every record is synced to disk before
+// Append returns. The sink keeps the last record in memory, so a file must be
+// written by a single sink.
+type JSONLAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	last *AuditRecord
+}
+
+// OpenJSONLAuditSink opens or creates an audit log file. Records already in
+// the file are read so that new records continue their hash chain.
+func OpenJSONLAuditSink(path string) (*JSONLAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	records, err := ReadAuditLog(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	sink := &JSONLAuditSink{file: file}
+	if len(records) > 0 {
+		sink.last = &records[len(records)-1]
+	}
+
+	return sink, nil
+}
+
+// Append seals a record and appends it to the file.
+func (s *JSONLAuditSink) Append(ctx context.Context, record AuditRecord) (AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return AuditRecord{}, errAuditSinkClosed
+	}
+
+	record, err := SealAuditRecord(record, s.last)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return AuditRecord{}, fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return AuditRecord{}, err
+	}
+	if err := s.file.Sync(); err != nil {
+		return AuditRecord{}, err
+	}
+
+	s.last = &record
+	return record, nil
+}
+
+// Last returns the most recent record.
+func (s *JSONLAuditSink) Last(ctx context.Context) (AuditRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil {
+		return AuditRecord{}, false, nil
+	}
+
+	return *s.last, true, nil
+}
+
+// Close closes the file.
+func (s *JSONLAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+var errAuditSinkClosed = errors.New("audit sink is closed")
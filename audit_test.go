@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// auditDetails has fields out of alphabetical order and a large integer, which
+// a plain encoding would not round-trip through a map.
+type auditDetails struct {
+	Zone   string `json:"zone"`
+	Amount int64  `json:"amount"`
+}
+
+func TestAuditTrailSurvivesJSONLRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := OpenJSONLAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit := Audit(sink, &AuditOptions{
+		Details: func(ctx context.Context, input any) map[string]any {
+			return map[string]any{"transfer": auditDetails{Zone: "eu", Amount: 1<<60 + 1}}
+		},
+	})
+	chain := NewNamedChain("transfer", audit, passThrough)
+	other := NewNamedChain("refund", audit, failPermanently)
+
+	for range 3 {
+		chain.Then(context.Background(), nil)
+		other.Then(context.Background(), nil)
+	}
+	sink.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records, err := ReadAuditLog(file)
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error = %v", err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("got %d records; want 6", len(records))
+	}
+	if err := VerifyAuditRecords(records); err != nil {
+		t.Errorf("VerifyAuditRecords() error = %v", err)
+	}
+	if got := string(records[0].Details); got != `{"transfer":{"amount":1152921504606846977,"zone":"eu"}}` {
+		t.Errorf("Details = %s; want canonical JSON", got)
+	}
+	if records[1].Outcome != AuditFailure {
+		t.Errorf("Outcome of the refund = %q; want %q", records[1].Outcome, AuditFailure)
+	}
+}
+
+func TestAuditContinuesTrailAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	for range 2 {
+		sink, err := OpenJSONLAuditSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewNamedChain("transfer", Audit(sink, nil)).Then(context.Background(), nil)
+		sink.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := VerifyAuditLog(file); err != nil {
+		t.Errorf("VerifyAuditLog() error = %v", err)
+	}
+}
+
+func TestAuditMiddlewareShareSinkTrail(t *testing.T) {
+	sink := NewMemoryAuditSink()
+	transfers := NewNamedChain("transfer", Audit(sink, nil), passThrough)
+	refunds := NewNamedChain("refund", Audit(sink, nil), passThrough)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			transfers.Then(context.Background(), nil)
+		}()
+		go func() {
+			defer wg.Done()
+			refunds.Then(context.Background(), nil)
+		}()
+	}
+	wg.Wait()
+
+	records := sink.Records()
+	if len(records) != 40 {
+		t.Fatalf("got %d records; want 40", len(records))
+	}
+	if err := VerifyAuditRecords(records); err != nil {
+		t.Errorf("VerifyAuditRecords() error = %v", err)
+	}
+}
+
+func TestVerifyAuditRecordsDetectsTampering(t *testing.T) {
+	sink := NewMemoryAuditSink()
+	chain := NewNamedChain("transfer", Audit(sink, nil))
+	for range 3 {
+		chain.Then(context.Background(), nil)
+	}
+
+	records := sink.Records()
+	if err := VerifyAuditRecords(records); err != nil {
+		t.Fatalf("VerifyAuditRecords() error = %v", err)
+	}
+
+	altered := append([]AuditRecord(nil), records...)
+	altered[1].Actor = "mallory"
+
+	tests := map[string][]AuditRecord{
+		"altered":       altered,
+		"removed":       {records[0], records[2]},
+		"reordered":     {records[0], records[2], records[1]},
+		"head removed":  records[1:],
+		"only the tail": records[2:],
+	}
+	for name, trail := range tests {
+		var tamperErr *AuditTamperError
+		if err := VerifyAuditRecords(trail); !errors.As(err, &tamperErr) {
+			t.Errorf("%s: VerifyAuditRecords() error = %v; want *AuditTamperError", name, err)
+		}
+	}
+}
+
+func TestAuditAcceptsNonComparableSinks(t *testing.T) {
+	sink := sliceAuditSink{records: new([]AuditRecord)}
+	NewChain(Audit(sink, nil)).Then(context.Background(), nil)
+
+	if len(*sink.records) != 1 {
+		t.Errorf("got %d records; want 1", len(*sink.records))
+	}
+}
+
+// sliceAuditSink is an AuditSink value that is not comparable.
+type sliceAuditSink struct {
+	unused  []int
+	records *[]AuditRecord
+}
+
+func (s sliceAuditSink) Append(ctx context.Context, record AuditRecord) (AuditRecord, error) {
+	var previous *AuditRecord
+	if len(*s.records) > 0 {
+		previous = &(*s.records)[len(*s.records)-1]
+	}
+
+	record, err := SealAuditRecord(record, previous)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	*s.records = append(*s.records, record)
+	return record, nil
+}
+
+func (s sliceAuditSink) Last(ctx context.Context) (AuditRecord, bool, error) {
+	if len(*s.records) == 0 {
+		return AuditRecord{}, false, nil
+	}
+	return (*s.records)[len(*s.records)-1], true, nil
+}
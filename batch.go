@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// Result is the outcome of one input processed by ThenBatch or ThenStream.
+type Result struct {
+	// Index is the position of the input in the batch or stream
+	Index int
+
+	// Input is the input as received
+	Input any
+
+	// Context is the context returned by the chain for this input
+	Context context.Context
+
+	// Output is the output of the chain, nil when Err is set
+	Output any
+
+	// Err is the chain error for this input
+	Err error
+}
+
+// BatchOption configures ThenBatch and ThenStream.
+type BatchOption func(*batchConfig)
+
+// batchConfig holds the options of a batch or stream execution.
+type batchConfig struct {
+	concurrency int
+	unordered   bool
+}
+
+// WithConcurrency bounds the number of inputs processed in parallel. The
+// default is runtime.GOMAXPROCS(0).
+func WithConcurrency(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithUnordered makes ThenStream emit results as soon as they are ready,
+// instead of in input order. ThenBatch always returns results in input order.
+func WithUnordered() BatchOption {
+	return func(c *batchConfig) {
+		c.unordered = true
+	}
+}
+
+// newBatchConfig applies batch options over the defaults.
+func newBatchConfig(opts []BatchOption) batchConfig {
+	config := batchConfig{concurrency: runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	config.concurrency = max(config.concurrency, 1)
+
+	return config
+}
+
+// ThenBatch runs every input through the chain, like Chain.ThenBatch.
+func (cc *CompiledChain) ThenBatch(ctx context.Context, inputs []any, opts ...BatchOption) []Result {
+	config := newBatchConfig(opts)
+	results := make([]Result, len(inputs))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, config.concurrency)
+
+	for i, input := range inputs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		// Inputs not started before cancellation fail without running
+		if ctx.Err() != nil {
+			for j := i; j < len(inputs); j++ {
+				results[j] = Result{Index: j, Input: inputs[j], Context: ctx, Err: ctx.Err()}
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			results[i] = cc.runItem(ctx, i, input)
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// ThenStream runs the inputs received from a channel through the chain, like
+// Chain.ThenStream.
+func (cc *CompiledChain) ThenStream(ctx context.Context, inputs <-chan any, opts ...BatchOption) <-chan Result {
+	config := newBatchConfig(opts)
+	out := make(chan Result)
+
+	emit := func(result Result) {
+		select {
+		case out <- result:
+		case <-ctx.Done():
+		}
+	}
+
+	// In ordered mode, every input gets a slot in pending, in input order,
+	// which the emitter drains one by one. The capacity of pending bounds how
+	// far processing runs ahead of a slow receiver.
+	var pending chan chan Result
+	emitted := make(chan struct{})
+	if config.unordered {
+		close(emitted)
+	} else {
+		pending = make(chan chan Result, config.concurrency)
+		go func() {
+			defer close(emitted)
+			for slot := range pending {
+				emit(<-slot)
+			}
+		}()
+	}
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			if pending != nil {
+				close(pending)
+			}
+			<-emitted
+			close(out)
+		}()
+
+		slots := make(chan struct{}, config.concurrency)
+		for index := 0; ; index++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			var input any
+			var ok bool
+			select {
+			case input, ok = <-inputs:
+			case <-ctx.Done():
+			}
+			if !ok {
+				return
+			}
+
+			var slot chan Result
+			if pending != nil {
+				slot = make(chan Result, 1)
+				select {
+				case pending <- slot:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				result := cc.runItem(ctx, index, input)
+				if slot != nil {
+					slot <- result
+					<-slots
+					return
+				}
+
+				emit(result)
+				<-slots
+			}()
+		}
+	}()
+
+	return out
+}
+
+// runItem runs one input of a batch or stream with its own copy of the
+// metadata and a request ID derived from the caller's. A panic is reported
+// as a *PanicError instead of crashing the process.
+func (cc *CompiledChain) runItem(ctx context.Context, index int, input any) (result Result) {
+	result = Result{Index: index, Input: input, Context: ctx}
+
+	itemCtx := ForkMetadata(ctx)
+	if requestID, ok := GetRequestID(ctx); ok && requestID != "" {
+		itemCtx = SetRequestID(itemCtx, requestID+"-"+strconv.Itoa(index))
+	} else {
+		itemCtx = SetRequestID(itemCtx, generateRequestID())
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.Context = itemCtx
+			result.Output = nil
+			result.Err = &PanicError{Value: recovered, Stack: debug.Stack()}
+		}
+	}()
+
+	result.Context, result.Output, result.Err = cc.Then(itemCtx, input)
+	return result
+}
+
+// ThenBatch runs every input through the chain with bounded concurrency and
+// returns one Result per input, in input order. A failing input does not stop
+// the others.
+//
+// Every input runs with its own copy of the metadata, so values set while
+// processing one input are not seen by the others, and with a request ID
+// derived from the caller's: "<request ID>-<index>", or a new ID when the
+// caller has none. Panics are reported as a *PanicError result.
+//
+// When ctx is done, inputs that have not started fail with the context's
+// error and ThenBatch returns once the running ones complete.
+//
+// Example:
+//
+//	results := chain.ThenBatch(ctx, []any{order1, order2, order3}, middleware.WithConcurrency(8))
+//	for _, result := range results {
+//		if result.Err != nil {
+//			log.Printf("order %d failed: %v", result.Index, result.Err)
+//		}
+//	}
+func (c *Chain) ThenBatch(ctx context.Context, inputs []any, opts ...BatchOption) []Result {
+	return c.Compile().ThenBatch(ctx, inputs, opts...)
+}
+
+// ThenStream runs the inputs received from a channel through the chain with
+// bounded concurrency and sends one Result per input on the returned channel,
+// which is closed once inputs is closed and every input is processed.
+//
+// Results are emitted in input order, unless WithUnordered is given. Inputs
+// are read only as fast as results are received, so a slow receiver slows
+// down the stream instead of buffering results without bound. Inputs run with
+// isolated metadata and derived request IDs, as with ThenBatch.
+//
+// When ctx is done, no more inputs are read, the running ones complete and the
+// channel is closed. Their results are dropped if the receiver stops reading.
+//
+// Example:
+//
+//	for result := range chain.ThenStream(ctx, events, middleware.WithConcurrency(4)) {
+//		if result.Err != nil {
+//			log.Printf("event %d failed: %v", result.Index, result.Err)
+//		}
+//	}
+func (c *Chain) ThenStream(ctx context.Context, inputs <-chan any, opts ...BatchOption) <-chan Result {
+	return c.Compile().ThenStream(ctx, inputs, opts...)
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// delayed is a step that sleeps for the input's number of milliseconds and
+// returns it.
+func delayed(ctx context.Context, input any) (context.Context, any, error) {
+	time.Sleep(time.Duration(input.(int)) * time.Millisecond)
+	return ctx, input, nil
+}
+
+// gauge tracks the number of running steps and the highest number seen.
+type gauge struct {
+	current, peak atomic.Int32
+}
+
+// step returns a step counting itself in the gauge while it runs.
+func (g *gauge) step(ctx context.Context, input any) (context.Context, any, error) {
+	running := g.current.Add(1)
+	defer g.current.Add(-1)
+
+	for {
+		peak := g.peak.Load()
+		if running <= peak || g.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return ctx, input, nil
+}
+
+// inputsOf returns a closed channel carrying the inputs.
+func inputsOf(inputs ...any) <-chan any {
+	ch := make(chan any, len(inputs))
+	for _, input := range inputs {
+		ch <- input
+	}
+	close(ch)
+	return ch
+}
+
+func TestThenBatchKeepsInputOrder(t *testing.T) {
+	inputs := []any{20, 15, 10, 5, 0}
+	results := NewChain(delayed).ThenBatch(context.Background(), inputs, WithConcurrency(len(inputs)))
+
+	if len(results) != len(inputs) {
+		t.Fatalf("got %d results; want %d", len(results), len(inputs))
+	}
+	for i, result := range results {
+		if result.Index != i || result.Input != inputs[i] || result.Output != inputs[i] || result.Err != nil {
+			t.Errorf("results[%d] = %+v; want the output of input %d", i, result, i)
+		}
+	}
+}
+
+func TestThenBatchBoundsConcurrency(t *testing.T) {
+	var g gauge
+	inputs := make([]any, 20)
+	NewChain(g.step).ThenBatch(context.Background(), inputs, WithConcurrency(3))
+
+	if peak := g.peak.Load(); peak > 3 {
+		t.Errorf("%d inputs ran in parallel; want at most 3", peak)
+	}
+}
+
+func TestThenBatchStopsStartingInputsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		cancel()
+		return ctx, input, nil
+	})
+
+	results := chain.ThenBatch(ctx, []any{1, 2, 3}, WithConcurrency(1))
+
+	if results[0].Err != nil {
+		t.Errorf("running input failed: %v", results[0].Err)
+	}
+	for _, result := range results[1:] {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("result %d error = %v; want context.Canceled without running", result.Index, result.Err)
+		}
+	}
+}
+
+func TestThenBatchIsolatesItems(t *testing.T) {
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		if _, leaked := GetMetadata(ctx, "claimed"); leaked {
+			return ctx, nil, errors.New("metadata of another input is visible")
+		}
+		ctx = AddMetadata(ctx, "claimed", input)
+
+		requestID, _ := GetRequestID(ctx)
+		return ctx, requestID, nil
+	})
+
+	ctx := SetRequestID(context.Background(), "req")
+	results := chain.ThenBatch(ctx, []any{"a", "b", "c"}, WithConcurrency(1))
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("result %d: %v", i, result.Err)
+		}
+		if want := "req-" + strconv.Itoa(i); result.Output != want {
+			t.Errorf("result %d: request ID = %v; want %s", i, result.Output, want)
+		}
+		if claimed, _ := GetMetadata(result.Context, "claimed"); claimed != result.Input {
+			t.Errorf("result %d: claimed = %v; want its own input %v", i, claimed, result.Input)
+		}
+	}
+	if _, leaked := GetMetadata(ctx, "claimed"); leaked {
+		t.Error("input metadata written to the caller's context")
+	}
+	if id, _ := GetRequestID(ctx); id != "req" {
+		t.Errorf("caller's request ID = %q; want req", id)
+	}
+
+	// Without a request ID of the caller, every input gets a new one
+	results = chain.ThenBatch(context.Background(), []any{"a", "b"})
+	if results[0].Output == "" || results[0].Output == results[1].Output {
+		t.Errorf("request IDs = %v and %v; want distinct IDs", results[0].Output, results[1].Output)
+	}
+}
+
+func TestThenBatchCapturesPanics(t *testing.T) {
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		if input == "explode" {
+			panic("boom")
+		}
+		return ctx, input, nil
+	})
+
+	results := chain.ThenBatch(context.Background(), []any{"ok", "explode", "ok"})
+
+	var panicErr *PanicError
+	if !errors.As(results[1].Err, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Errorf("panicking input error = %v; want a *PanicError with its stack", results[1].Err)
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("other inputs failed: %v, %v", results[0].Err, results[2].Err)
+	}
+}
+
+func TestThenStreamKeepsInputOrder(t *testing.T) {
+	stream := NewChain(delayed).ThenStream(context.Background(), inputsOf(20, 15, 10, 5, 0), WithConcurrency(5))
+
+	index := 0
+	for result := range stream {
+		if result.Index != index || result.Err != nil {
+			t.Errorf("result %d = %+v; want input %d", index, result, index)
+		}
+		index++
+	}
+	if index != 5 {
+		t.Errorf("got %d results; want 5", index)
+	}
+}
+
+func TestThenStreamUnordered(t *testing.T) {
+	var g gauge
+	inputs := make([]any, 20)
+	stream := NewChain(g.step).ThenStream(context.Background(), inputsOf(inputs...), WithConcurrency(4), WithUnordered())
+
+	seen := make(map[int]bool)
+	for result := range stream {
+		seen[result.Index] = true
+	}
+
+	if len(seen) != len(inputs) {
+		t.Errorf("got results for %d inputs; want %d", len(seen), len(inputs))
+	}
+	if peak := g.peak.Load(); peak > 4 {
+		t.Errorf("%d inputs ran in parallel; want at most 4", peak)
+	}
+}
+
+func TestThenStreamAppliesBackpressure(t *testing.T) {
+	for _, opts := range [][]BatchOption{
+		{WithConcurrency(2)},
+		{WithConcurrency(2), WithUnordered()},
+	} {
+		var read atomic.Int32
+		inputs := make(chan any)
+		go func() {
+			defer close(inputs)
+			for i := range 100 {
+				inputs <- i
+				read.Add(1)
+			}
+		}()
+
+		stream := NewChain(passThrough).ThenStream(context.Background(), inputs, opts...)
+
+		// Nobody receives results, so only a bounded number of inputs is read
+		time.Sleep(50 * time.Millisecond)
+		if n := read.Load(); n > 6 {
+			t.Errorf("%d inputs read without a receiver; want at most 6", n)
+		}
+
+		count := 0
+		for range stream {
+			count++
+		}
+		if count != 100 {
+			t.Errorf("got %d results; want 100", count)
+		}
+	}
+}
+
+func TestThenStreamStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inputs := make(chan any)
+	var producer sync.WaitGroup
+	producer.Add(1)
+	go func() {
+		defer producer.Done()
+		for i := 0; ; i++ {
+			select {
+			case inputs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	stream := NewChain(passThrough).ThenStream(ctx, inputs, WithConcurrency(2))
+	for range 3 {
+		<-stream
+	}
+	cancel()
+
+	closed := make(chan struct{})
+	go func() {
+		for range stream {
+		}
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after cancellation")
+	}
+	producer.Wait()
+}
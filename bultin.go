@@ -12,7 +12,8 @@ import (
 
 // RequestID generates and adds a unique request ID to the context.
 // The request ID is useful for tracing requests across multiple services
-// and correlating log entries.
+// and correlating log entries. A request ID already in the context, e.g. one
+// received from the caller, is kept.
 //
 // Example:
 //
@@ -36,8 +37,8 @@ func RequestID() MiddlewareFunc {
 //	middleware := middleware.RequestIDWithGenerator(customGenerator)
 func RequestIDWithGenerator(generator func() string) MiddlewareFunc {
 	return func(ctx context.Context, input any) (context.Context, any, error) {
-		// Check if request ID already exists
-		if _, ok := GetRequestID(ctx); !ok {
+		// Keep a request ID that already exists, e.g. one received from a caller
+		if requestID, ok := GetRequestID(ctx); ok && requestID != "" {
 			return ctx, input, nil
 		}
 
@@ -78,7 +79,23 @@ func Timeout(duration time.Duration) MiddlewareFunc {
 //		middleware.Observability(logger),
 //		riskyBusinessLogicMiddleware,
 //	)
+//
+// The panic value is logged through DefaultRedactor; use RecoveryWithRedactor
+// to customize it.
 func Recovery(logger *slog.Logger) MiddlewareFunc {
+	return RecoveryWithRedactor(logger, DefaultRedactor())
+}
+
+// RecoveryWithRedactor is like Recovery, but removes sensitive data from the
+// logged panic value with the given Redactor. A nil Redactor logs the value
+// as it is.
+//
+// Example:
+//
+//	redactor := middleware.DefaultRedactor()
+//	redactor.Strategy = middleware.RedactHash
+//	chain := middleware.NewChain(middleware.RecoveryWithRedactor(logger, redactor), next)
+func RecoveryWithRedactor(logger *slog.Logger, redactor *Redactor) MiddlewareFunc {
 	if logger == nil {
 		logger = slog.Default()
 	}
@@ -91,7 +108,7 @@ func Recovery(logger *slog.Logger) MiddlewareFunc {
 				stack := string(debug.Stack())
 
 				logAttrs := []slog.Attr{
-					slog.Any("panic", r),
+					slog.Any("panic", redactor.Redact(r)),
 					slog.String("stack", stack),
 				}
 
@@ -113,7 +130,7 @@ func Recovery(logger *slog.Logger) MiddlewareFunc {
 
 // Validation creates a middleware that validates input data using a provided
 // validation function. This is useful for ensuring data integrity before
-// processing requests.
+// processing requests. Validation errors wrap ErrValidation.
 //
 // Example:
 //
@@ -127,7 +144,7 @@ func Recovery(logger *slog.Logger) MiddlewareFunc {
 func Validation(validator func(any) error) MiddlewareFunc {
 	return func(ctx context.Context, input any) (context.Context, any, error) {
 		if err := validator(input); err != nil {
-			return ctx, nil, fmt.Errorf("validation failed: %w", err)
+			return ctx, nil, fmt.Errorf("%w: %w", ErrValidation, err)
 		}
 
 		// Add validation success to metadata
@@ -171,7 +188,7 @@ func RateLimit(requestsPerDuration int, duration time.Duration) MiddlewareFunc {
 
 		// Check if we have tokens available
 		if bucket.tokens <= 0 {
-			return ctx, nil, fmt.Errorf("rate limit exceeded")
+			return ctx, nil, ErrRateLimited
 		}
 
 		// Consume a token
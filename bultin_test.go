@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+)
+
+func panicking(ctx context.Context, input any) (context.Context, any, error) {
+	panic("boom")
+}
+
+func TestPanicWithoutRecoveryPropagates(t *testing.T) {
+	finished := false
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		stateFrom(ctx).onFinish(func(ctx context.Context, err error) {
+			finished = true
+		})
+		return ctx, input, nil
+	}, panicking)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("panic did not propagate")
+		}
+		if !finished {
+			t.Error("finishers did not run before the panic propagated")
+		}
+	}()
+	chain.Then(context.Background(), nil)
+}
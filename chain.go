@@ -2,7 +2,9 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 )
 
 // Define context keys to avoid collisions
@@ -41,10 +43,21 @@ type MiddlewareFunc func(ctx context.Context, input any) (context.Context, any,
 //		businessLogicMiddleware,
 //	)
 type Chain struct {
-	middlewares []MiddlewareFunc
-	name        string // Optional name for debugging/logging
+	steps    []step
+	name     string // Optional name for debugging/logging
+	hooks    []Hooks
+	compiled atomic.Pointer[CompiledChain]
 }
 
+// step is a middleware function together with its optional explicit name.
+type step struct {
+	name string
+	fn   MiddlewareFunc
+}
+
+// ChainOption configures optional behavior of a Chain, such as hooks.
+type ChainOption func(*Chain)
+
 // NewChain creates a new middleware Chain with the given middleware functions.
 // The middleware functions will be executed in the order they are provided.
 //
@@ -57,7 +70,7 @@ type Chain struct {
 //	)
 func NewChain(middlewares ...MiddlewareFunc) *Chain {
 	return &Chain{
-		middlewares: append([]MiddlewareFunc{}, middlewares...),
+		steps: toSteps(middlewares),
 	}
 }
 
@@ -72,8 +85,8 @@ func NewChain(middlewares ...MiddlewareFunc) *Chain {
 //	)
 func NewNamedChain(name string, middlewares ...MiddlewareFunc) *Chain {
 	return &Chain{
-		middlewares: append([]MiddlewareFunc{}, middlewares...),
-		name:        name,
+		steps: toSteps(middlewares),
+		name:  name,
 	}
 }
 
@@ -86,14 +99,28 @@ func NewNamedChain(name string, middlewares ...MiddlewareFunc) *Chain {
 //	baseChain := NewChain(authMiddleware)
 //	extendedChain := baseChain.Append(validationMiddleware, businessLogicMiddleware)
 func (c *Chain) Append(middlewares ...MiddlewareFunc) *Chain {
-	newMiddlewares := make([]MiddlewareFunc, len(c.middlewares)+len(middlewares))
-	copy(newMiddlewares, c.middlewares)
-	copy(newMiddlewares[len(c.middlewares):], middlewares)
+	newSteps := make([]step, len(c.steps), len(c.steps)+len(middlewares))
+	copy(newSteps, c.steps)
+	newSteps = append(newSteps, toSteps(middlewares)...)
 
-	return &Chain{
-		middlewares: newMiddlewares,
-		name:        c.name,
-	}
+	return c.derive(newSteps)
+}
+
+// AppendNamed adds a single middleware function with an explicit step name to
+// the end of the chain. Step names are exposed to middleware through
+// GetStepName and to hooks through StepInfo. Like Append, it returns a new chain.
+//
+// Example:
+//
+//	chain := NewNamedChain("orders").
+//		AppendNamed("auth", authMiddleware).
+//		AppendNamed("create-order", createOrderMiddleware)
+func (c *Chain) AppendNamed(name string, mw MiddlewareFunc) *Chain {
+	newSteps := make([]step, len(c.steps), len(c.steps)+1)
+	copy(newSteps, c.steps)
+	newSteps = append(newSteps, step{name: name, fn: mw})
+
+	return c.derive(newSteps)
 }
 
 // Prepend adds one or more middleware functions to the beginning of the chain.
@@ -104,14 +131,30 @@ func (c *Chain) Append(middlewares ...MiddlewareFunc) *Chain {
 //	baseChain := NewChain(businessLogicMiddleware)
 //	extendedChain := baseChain.Prepend(authMiddleware, validationMiddleware)
 func (c *Chain) Prepend(middlewares ...MiddlewareFunc) *Chain {
-	newMiddlewares := make([]MiddlewareFunc, len(middlewares)+len(c.middlewares))
-	copy(newMiddlewares, middlewares)
-	copy(newMiddlewares[len(middlewares):], c.middlewares)
+	newSteps := make([]step, 0, len(middlewares)+len(c.steps))
+	newSteps = append(newSteps, toSteps(middlewares)...)
+	newSteps = append(newSteps, c.steps...)
 
-	return &Chain{
-		middlewares: newMiddlewares,
-		name:        c.name,
+	return c.derive(newSteps)
+}
+
+// With returns a new chain with the given options applied. The original chain
+// is not modified.
+//
+// Example:
+//
+//	traced := chain.With(middleware.WithHooks(middleware.Hooks{
+//		AfterStep: func(ctx context.Context, info middleware.StepInfo, err error, elapsed time.Duration) {
+//			log.Printf("%s took %s", info.Name, elapsed)
+//		},
+//	}))
+func (c *Chain) With(opts ...ChainOption) *Chain {
+	newChain := c.Clone()
+	for _, opt := range opts {
+		opt(newChain)
 	}
+
+	return newChain
 }
 
 // Then executes the middleware chain sequentially, passing the context and data
@@ -121,6 +164,9 @@ func (c *Chain) Prepend(middlewares ...MiddlewareFunc) *Chain {
 // The input data flows through each middleware and can be transformed at each step.
 // The final output is the result of the last middleware in the chain.
 //
+// The chain is compiled on first use and the compiled form is reused by every
+// subsequent call, see Compile.
+//
 // Example:
 //
 //	ctx := context.Background()
@@ -130,31 +176,7 @@ func (c *Chain) Prepend(middlewares ...MiddlewareFunc) *Chain {
 //		return
 //	}
 func (c *Chain) Then(ctx context.Context, input any) (context.Context, any, error) {
-	if len(c.middlewares) == 0 {
-		return ctx, input, nil
-	}
-
-	var err error
-	var output any = input
-	currentCtx := ctx
-
-	// Add chain metadata to context if chain has a name
-	if c.name != "" {
-		currentCtx = context.WithValue(currentCtx, ChainNameKey, c.name)
-	}
-
-	for i, mw := range c.middlewares {
-		// Add current middleware index to context for debugging
-		currentCtx = context.WithValue(currentCtx, MiddlewareIndexKey, i)
-
-		currentCtx, output, err = mw(currentCtx, output)
-		if err != nil {
-			// Wrap error with additional context information
-			return currentCtx, nil, fmt.Errorf("middleware %d failed: %w", i, err)
-		}
-	}
-
-	return currentCtx, output, nil
+	return c.Compile().Then(ctx, input)
 }
 
 // GetChainName retrieves the chain name from the context.
@@ -171,9 +193,105 @@ func GetChainName(ctx context.Context) (string, bool) {
 	return name, ok
 }
 
+// GetMiddlewareIndex retrieves the index of the currently executing middleware
+// from the context. It returns the index and a boolean indicating whether the
+// context belongs to a running step. A context kept by a step keeps reporting
+// that step, and the context returned by Then reports no index.
+//
+// Example:
+//
+//	index, ok := GetMiddlewareIndex(ctx)
+//	if ok {
+//	    log.Printf("Running step %d", index)
+//	}
+func GetMiddlewareIndex(ctx context.Context) (int, bool) {
+	index, ok := ctx.Value(MiddlewareIndexKey).(int)
+	return index, ok
+}
+
+// GetStepName retrieves the name of the currently executing step from the context.
+// Steps added with AppendNamed use their explicit name; other steps are named
+// after their middleware function.
+//
+// Example:
+//
+//	stepName, ok := GetStepName(ctx)
+//	if ok {
+//	    log.Printf("Running step: %s", stepName)
+//	}
+func GetStepName(ctx context.Context) (string, bool) {
+	f := frameFrom(ctx)
+	if f == nil {
+		return "", false
+	}
+
+	return f.step.name, true
+}
+
+// ChainError is returned by Then when a middleware fails. It records which
+// step failed and wraps the error returned by that step, so callers can use
+// errors.Is and errors.As to inspect the cause.
+type ChainError struct {
+	// Chain is the name of the chain, if set
+	Chain string
+
+	// Step is the name of the failing step
+	Step string
+
+	// Index is the position of the failing step in the chain
+	Index int
+
+	// Err is the error returned by the failing step
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("middleware %d failed: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error returned by the failing step.
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorMessage returns the message of the error returned by the failing step,
+// without the position prefix added by every *ChainError wrapping it. Errors
+// that do not wrap a *ChainError are returned as they are. It is meant for
+// messages shown to callers, e.g. in HTTP or gRPC error responses.
+func ErrorMessage(err error) string {
+	var chainErr *ChainError
+	for errors.As(err, &chainErr) {
+		err = chainErr.Err
+	}
+
+	return err.Error()
+}
+
+// PanicError is the error returned for a step that panicked while a Recovery
+// middleware was active.
+type PanicError struct {
+	// Value is the value passed to panic
+	Value any
+
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 // Len returns the number of middleware functions in the chain.
 func (c *Chain) Len() int {
-	return len(c.middlewares)
+	return len(c.steps)
 }
 
 // Name returns the name of the chain, if set.
@@ -184,11 +302,27 @@ func (c *Chain) Name() string {
 // Clone creates a deep copy of the chain, allowing safe modification
 // without affecting the original chain.
 func (c *Chain) Clone() *Chain {
-	middlewares := make([]MiddlewareFunc, len(c.middlewares))
-	copy(middlewares, c.middlewares)
+	steps := make([]step, len(c.steps))
+	copy(steps, c.steps)
+
+	return c.derive(steps)
+}
 
+// derive creates a new chain sharing the name and hooks of c with the given steps.
+func (c *Chain) derive(steps []step) *Chain {
 	return &Chain{
-		middlewares: middlewares,
-		name:        c.name,
+		steps: steps,
+		name:  c.name,
+		hooks: append([]Hooks(nil), c.hooks...),
 	}
 }
+
+// toSteps converts middleware functions into unnamed steps.
+func toSteps(middlewares []MiddlewareFunc) []step {
+	steps := make([]step, len(middlewares))
+	for i, mw := range middlewares {
+		steps[i] = step{fn: mw}
+	}
+
+	return steps
+}
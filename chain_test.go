@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorMessageStripsChainPositions(t *testing.T) {
+	cause := fmt.Errorf("%w: order 7", ErrNotFound)
+	inner := NewChain(passThrough, func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, cause
+	})
+	outer := NewChain(passThrough, passThrough, func(ctx context.Context, input any) (context.Context, any, error) {
+		return inner.Then(ctx, input)
+	})
+
+	_, _, err := outer.Then(context.Background(), nil)
+	if got := ErrorMessage(err); got != cause.Error() {
+		t.Errorf("ErrorMessage = %q, want %q (from %q)", got, cause.Error(), err)
+	}
+
+	plain := errors.New("plain")
+	if got := ErrorMessage(plain); got != "plain" {
+		t.Errorf("ErrorMessage of a plain error = %q, want %q", got, "plain")
+	}
+}
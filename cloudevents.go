@@ -0,0 +1,749 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudEvents constants
+const (
+	// CloudEventsSpecVersion is the supported CloudEvents specification version
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsContentType is the content type of structured mode events
+	CloudEventsContentType = "application/cloudevents+json"
+
+	// cloudEventsBatchContentType is the content type of batched events
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+
+	// cloudEventHeaderPrefix prefixes the attribute headers of binary mode
+	cloudEventHeaderPrefix = "Ce-"
+
+	// DefaultCloudEventMaxBytes is the largest request body read by
+	// ParseCloudEventHTTP and DecodeCloudEvent
+	DefaultCloudEventMaxBytes = 1 << 20
+)
+
+// Typed keys set by DecodeCloudEvent and read by EncodeCloudEvent
+var (
+	// CloudEventIDKey holds the ID of the event being processed. The ID is
+	// also set as the request ID.
+	CloudEventIDKey = NewKey[string]("cloudevents.id")
+
+	// CloudEventSourceKey holds the source of the event being processed
+	CloudEventSourceKey = NewKey[string]("cloudevents.source")
+
+	// CloudEventTypeKey holds the type of the event being processed
+	CloudEventTypeKey = NewKey[string]("cloudevents.type")
+
+	// CloudEventKey holds the envelope of the event being processed, without
+	// its data
+	CloudEventKey = NewKey[CloudEvent]("cloudevents.event")
+
+	// TraceParentKey holds the W3C traceparent of the caller, taken from the
+	// traceparent extension of incoming events. Tracing backends can use it
+	// to continue the caller's trace.
+	TraceParentKey = NewKey[string]("traceparent")
+
+	// TraceStateKey holds the W3C tracestate of the caller
+	TraceStateKey = NewKey[string]("tracestate")
+)
+
+// CloudEventMode is the HTTP binding mode of an event.
+type CloudEventMode int
+
+const (
+	// CloudEventBinary sends the attributes as ce- headers and the data as
+	// the body
+	CloudEventBinary CloudEventMode = iota
+
+	// CloudEventStructured sends the whole event as an
+	// application/cloudevents+json body
+	CloudEventStructured
+)
+
+// CloudEvent is a CloudEvents 1.0 event. Data holds the payload bytes: JSON
+// for JSON content types, the raw payload otherwise.
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Data            []byte
+
+	// Extensions holds the extension attributes, such as traceparent
+	Extensions map[string]any
+}
+
+// Validate checks the required attributes and the extension names. It returns
+// an error wrapping ErrValidation.
+func (e CloudEvent) Validate() error {
+	switch {
+	case e.SpecVersion != CloudEventsSpecVersion:
+		return fmt.Errorf("%w: unsupported cloudevents specversion %q", ErrValidation, e.SpecVersion)
+	case e.ID == "":
+		return fmt.Errorf("%w: cloudevent id is required", ErrValidation)
+	case e.Source == "":
+		return fmt.Errorf("%w: cloudevent source is required", ErrValidation)
+	case e.Type == "":
+		return fmt.Errorf("%w: cloudevent type is required", ErrValidation)
+	}
+
+	if _, err := url.Parse(e.Source); err != nil {
+		return fmt.Errorf("%w: cloudevent source is not a URI reference: %w", ErrValidation, err)
+	}
+
+	for name := range e.Extensions {
+		if !validExtensionName(name) {
+			return fmt.Errorf("%w: invalid cloudevent extension name %q", ErrValidation, name)
+		}
+		if _, reserved := cloudEventAttributes[name]; reserved {
+			return fmt.Errorf("%w: cloudevent extension %q shadows a context attribute", ErrValidation, name)
+		}
+	}
+
+	return nil
+}
+
+// cloudEventAttributes lists the context attributes defined by the
+// specification, which extensions may not use.
+var cloudEventAttributes = map[string]struct{}{
+	"specversion": {}, "id": {}, "source": {}, "type": {}, "subject": {}, "time": {},
+	"datacontenttype": {}, "dataschema": {}, "data": {}, "data_base64": {},
+}
+
+// validExtensionName reports whether an attribute name uses only lowercase
+// ASCII letters and digits.
+func validExtensionName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isJSONContentType reports whether data of the given content type is JSON.
+// Events without a content type carry JSON.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// MarshalJSON encodes the event in the structured JSON format.
+func (e CloudEvent) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]any, len(e.Extensions)+9)
+	for name, value := range e.Extensions {
+		fields[name] = value
+	}
+
+	fields["specversion"] = e.SpecVersion
+	fields["id"] = e.ID
+	fields["source"] = e.Source
+	fields["type"] = e.Type
+	if e.Subject != "" {
+		fields["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		fields["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.DataContentType != "" {
+		fields["datacontenttype"] = e.DataContentType
+	}
+	if e.DataSchema != "" {
+		fields["dataschema"] = e.DataSchema
+	}
+
+	if e.Data != nil {
+		switch {
+		case isJSONContentType(e.DataContentType) && json.Valid(e.Data):
+			fields["data"] = json.RawMessage(e.Data)
+		case strings.HasPrefix(e.DataContentType, "text/"):
+			fields["data"] = string(e.Data)
+		default:
+			fields["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes an event in the structured JSON format. It does not
+// validate the event; use Validate.
+func (e *CloudEvent) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*e = CloudEvent{}
+	attributes := map[string]*string{
+		"specversion":     &e.SpecVersion,
+		"id":              &e.ID,
+		"source":          &e.Source,
+		"type":            &e.Type,
+		"subject":         &e.Subject,
+		"datacontenttype": &e.DataContentType,
+		"dataschema":      &e.DataSchema,
+	}
+
+	for name, raw := range fields {
+		if target, ok := attributes[name]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return fmt.Errorf("cloudevent attribute %s: %w", name, err)
+			}
+			continue
+		}
+
+		switch name {
+		case "time":
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("cloudevent attribute time: %w", err)
+			}
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return fmt.Errorf("cloudevent attribute time: %w", err)
+			}
+			e.Time = parsed
+		case "data", "data_base64":
+			// Decoded below, once the content type is known
+		default:
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("cloudevent extension %s: %w", name, err)
+			}
+			if e.Extensions == nil {
+				e.Extensions = make(map[string]any)
+			}
+			e.Extensions[name] = value
+		}
+	}
+
+	if raw, ok := fields["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return fmt.Errorf("cloudevent data_base64: %w", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("cloudevent data_base64: %w", err)
+		}
+		e.Data = decoded
+	} else if raw, ok := fields["data"]; ok {
+		var text string
+		if !isJSONContentType(e.DataContentType) && json.Unmarshal(raw, &text) == nil {
+			e.Data = []byte(text)
+		} else {
+			e.Data = append([]byte(nil), raw...)
+		}
+	}
+
+	return nil
+}
+
+// ParseCloudEventHTTP reads an event from an HTTP request in binary or
+// structured mode. The event is validated; errors wrap ErrValidation. Bodies
+// larger than DefaultCloudEventMaxBytes fail with an *http.MaxBytesError.
+func ParseCloudEventHTTP(r *http.Request) (CloudEvent, error) {
+	return ParseCloudEventHTTPWithLimit(r, DefaultCloudEventMaxBytes)
+}
+
+// ParseCloudEventHTTPWithLimit is like ParseCloudEventHTTP, but fails with an
+// *http.MaxBytesError for bodies larger than maxBytes. When maxBytes is zero
+// or negative, the body is not limited.
+func ParseCloudEventHTTPWithLimit(r *http.Request, maxBytes int64) (CloudEvent, error) {
+	var body []byte
+	if r.Body != nil {
+		reader := r.Body
+		if maxBytes > 0 {
+			reader = http.MaxBytesReader(nil, r.Body, maxBytes)
+		}
+
+		var err error
+		if body, err = io.ReadAll(reader); err != nil {
+			return CloudEvent{}, fmt.Errorf("failed to read cloudevent body: %w", err)
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var event CloudEvent
+	switch {
+	case mediaType == CloudEventsContentType:
+		if err := json.Unmarshal(body, &event); err != nil {
+			return CloudEvent{}, fmt.Errorf("%w: invalid structured cloudevent: %w", ErrValidation, err)
+		}
+	case mediaType == cloudEventsBatchContentType:
+		return CloudEvent{}, fmt.Errorf("%w: batched cloudevents are not supported", ErrValidation)
+	default:
+		var err error
+		if event, err = parseBinaryCloudEvent(r.Header, contentType, body); err != nil {
+			return CloudEvent{}, err
+		}
+	}
+
+	if err := event.Validate(); err != nil {
+		return CloudEvent{}, err
+	}
+
+	return event, nil
+}
+
+// parseBinaryCloudEvent reads the attributes of a binary mode event from the
+// ce- headers.
+func parseBinaryCloudEvent(header http.Header, contentType string, body []byte) (CloudEvent, error) {
+	event := CloudEvent{DataContentType: contentType}
+	if len(body) > 0 {
+		event.Data = body
+	}
+
+	for key, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(key, cloudEventHeaderPrefix) {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(key, cloudEventHeaderPrefix))
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+
+		switch name {
+		case "specversion":
+			event.SpecVersion = value
+		case "id":
+			event.ID = value
+		case "source":
+			event.Source = value
+		case "type":
+			event.Type = value
+		case "subject":
+			event.Subject = value
+		case "dataschema":
+			event.DataSchema = value
+		case "time":
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return CloudEvent{}, fmt.Errorf("%w: invalid cloudevent time: %w", ErrValidation, err)
+			}
+			event.Time = parsed
+		default:
+			if event.Extensions == nil {
+				event.Extensions = make(map[string]any)
+			}
+			event.Extensions[name] = value
+		}
+	}
+
+	if event.SpecVersion == "" {
+		return CloudEvent{}, fmt.Errorf("%w: request is not a cloudevent", ErrValidation)
+	}
+
+	return event, nil
+}
+
+// WriteHTTP writes the event as an HTTP response with the given status, in
+// binary or structured mode.
+func (e CloudEvent) WriteHTTP(w http.ResponseWriter, status int, mode CloudEventMode) error {
+	header, body, err := e.httpMessage(mode)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return err
+}
+
+// NewHTTPRequest creates a POST request delivering the event to url, in
+// binary or structured mode.
+//
+// Example:
+//
+//	req, err := event.NewHTTPRequest(ctx, "https://broker.example.com/", middleware.CloudEventBinary)
+//	if err != nil {
+//		return err
+//	}
+//	resp, err := http.DefaultClient.Do(req)
+func (e CloudEvent) NewHTTPRequest(ctx context.Context, url string, mode CloudEventMode) (*http.Request, error) {
+	header, body, err := e.httpMessage(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	return req, nil
+}
+
+// httpMessage returns the headers and body of the event in an HTTP mode.
+func (e CloudEvent) httpMessage(mode CloudEventMode) (http.Header, []byte, error) {
+	header := make(http.Header)
+
+	if mode == CloudEventStructured {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode cloudevent: %w", err)
+		}
+		header.Set("Content-Type", CloudEventsContentType)
+		return header, body, nil
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			header.Set(cloudEventHeaderPrefix+name, encodeHeaderValue(value))
+		}
+	}
+
+	set("specversion", e.SpecVersion)
+	set("id", e.ID)
+	set("source", e.Source)
+	set("type", e.Type)
+	set("subject", e.Subject)
+	set("dataschema", e.DataSchema)
+	if !e.Time.IsZero() {
+		set("time", e.Time.Format(time.RFC3339Nano))
+	}
+	for name, value := range e.Extensions {
+		set(name, fmt.Sprint(value))
+	}
+
+	if e.DataContentType != "" {
+		header.Set("Content-Type", e.DataContentType)
+	} else if e.Data != nil {
+		header.Set("Content-Type", "application/json")
+	}
+
+	return header, e.Data, nil
+}
+
+// encodeHeaderValue percent-encodes the characters the HTTP binding requires
+// to be escaped in header values.
+func encodeHeaderValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// DecodeCloudEvent creates a middleware that unwraps a CloudEvent and passes
+// its data downstream as a value of type T. It accepts *http.Request and
+// HTTPRequestCarrier inputs in binary or structured mode, with bodies limited
+// to DefaultCloudEventMaxBytes, CloudEvent values and structured JSON as
+// []byte or json.RawMessage.
+//
+// The event ID is set as the request ID and under CloudEventIDKey; its source
+// and type are set under CloudEventSourceKey and CloudEventTypeKey, and the
+// envelope under CloudEventKey. The traceparent and tracestate extensions are
+// set under TraceParentKey and TraceStateKey, so that replies and downstream
+// events stay in the caller's trace.
+//
+// JSON data is unmarshaled into T; with T being []byte, json.RawMessage or
+// string, the data is passed as it is. Invalid events fail with an error
+// wrapping ErrValidation.
+//
+// Example:
+//
+//	type OrderPlaced struct {
+//		OrderID string `json:"order_id"`
+//	}
+//
+//	chain := middleware.NewNamedChain("order-placed",
+//		middleware.DecodeCloudEvent[OrderPlaced](),
+//		handleOrderPlaced,
+//	)
+//	handler := httpmw.Handler(chain)
+func DecodeCloudEvent[T any]() MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		event, err := cloudEventFromInput(input)
+		if err != nil {
+			return ctx, nil, err
+		}
+
+		ctx = SetRequestID(ctx, event.ID)
+		ctx = CloudEventIDKey.Set(ctx, event.ID)
+		ctx = CloudEventSourceKey.Set(ctx, event.Source)
+		ctx = CloudEventTypeKey.Set(ctx, event.Type)
+		if traceparent, ok := event.Extensions["traceparent"].(string); ok {
+			ctx = TraceParentKey.Set(ctx, traceparent)
+		}
+		if tracestate, ok := event.Extensions["tracestate"].(string); ok {
+			ctx = TraceStateKey.Set(ctx, tracestate)
+		}
+
+		envelope := event
+		envelope.Data = nil
+		ctx = CloudEventKey.Set(ctx, envelope)
+
+		data, err := cloudEventData[T](event)
+		if err != nil {
+			return ctx, nil, err
+		}
+
+		return ctx, data, nil
+	}
+}
+
+// cloudEventFromInput extracts a validated event from a chain input.
+func cloudEventFromInput(input any) (CloudEvent, error) {
+	var event CloudEvent
+
+	switch v := input.(type) {
+	case *http.Request:
+		return ParseCloudEventHTTP(v)
+	case HTTPRequestCarrier:
+		return ParseCloudEventHTTP(v.HTTPRequest())
+	case CloudEvent:
+		event = v
+	case *CloudEvent:
+		if v == nil {
+			return CloudEvent{}, fmt.Errorf("%w: cloudevent is nil", ErrValidation)
+		}
+		event = *v
+	case []byte:
+		if err := json.Unmarshal(v, &event); err != nil {
+			return CloudEvent{}, fmt.Errorf("%w: invalid structured cloudevent: %w", ErrValidation, err)
+		}
+	case json.RawMessage:
+		if err := json.Unmarshal(v, &event); err != nil {
+			return CloudEvent{}, fmt.Errorf("%w: invalid structured cloudevent: %w", ErrValidation, err)
+		}
+	default:
+		return CloudEvent{}, fmt.Errorf("%w: cannot decode a cloudevent from %T", ErrValidation, input)
+	}
+
+	if err := event.Validate(); err != nil {
+		return CloudEvent{}, err
+	}
+
+	return event, nil
+}
+
+// cloudEventData converts the data of an event into a value of type T.
+func cloudEventData[T any](event CloudEvent) (any, error) {
+	var value T
+	switch target := any(&value).(type) {
+	case *[]byte:
+		*target = event.Data
+	case *json.RawMessage:
+		*target = event.Data
+	case *string:
+		*target = string(event.Data)
+	default:
+		if len(event.Data) == 0 {
+			break
+		}
+		if !isJSONContentType(event.DataContentType) {
+			return nil, fmt.Errorf("%w: cannot decode %s cloudevent data into %T", ErrValidation, event.DataContentType, value)
+		}
+		if err := json.Unmarshal(event.Data, &value); err != nil {
+			return nil, fmt.Errorf("%w: invalid cloudevent data: %w", ErrValidation, err)
+		}
+	}
+
+	return value, nil
+}
+
+// CloudEventConfig configures EncodeCloudEventWithConfig.
+type CloudEventConfig struct {
+	// Source is the source of the produced events
+	Source string
+
+	// Type is the type of the produced events
+	Type string
+
+	// Subject returns the subject of an event. Optional.
+	Subject func(ctx context.Context, output any) string
+
+	// DataSchema is the schema URI of the data. Optional.
+	DataSchema string
+
+	// DataContentType is the content type of the data. When empty,
+	// application/json is used, and []byte and string outputs are sent as
+	// they are.
+	DataContentType string
+
+	// Extensions returns extension attributes to add to an event. Optional.
+	Extensions func(ctx context.Context, output any) map[string]any
+}
+
+// EncodeCloudEvent creates a middleware that wraps the input in a CloudEvent
+// of the given source and type.
+//
+// Example:
+//
+//	chain := middleware.NewChain(
+//		placeOrder,
+//		middleware.EncodeCloudEvent("/orders", "com.example.order.placed"),
+//	)
+func EncodeCloudEvent(source, eventType string) MiddlewareFunc {
+	return EncodeCloudEventWithConfig(&CloudEventConfig{Source: source, Type: eventType})
+}
+
+// EncodeCloudEventWithConfig creates a middleware that wraps the input in a
+// CloudEvent and passes it downstream. Non-byte inputs are encoded as JSON.
+// The event gets a new ID and the current time.
+//
+// The traceparent extension is set from the active span, or from
+// TraceParentKey when there is no span, so that consumers continue the trace;
+// TraceStateKey is forwarded as tracestate. Write the event with
+// CloudEvent.WriteHTTP or CloudEvent.NewHTTPRequest, or with
+// httpmw.CloudEventEncoder.
+//
+// Example:
+//
+//	chain := middleware.NewChain(
+//		placeOrder,
+//		middleware.EncodeCloudEventWithConfig(&middleware.CloudEventConfig{
+//			Source: "/orders",
+//			Type:   "com.example.order.placed",
+//			Subject: func(ctx context.Context, output any) string {
+//				return output.(Order).ID
+//			},
+//		}),
+//	)
+func EncodeCloudEventWithConfig(config *CloudEventConfig) MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		event := CloudEvent{
+			SpecVersion:     CloudEventsSpecVersion,
+			ID:              generateRequestID(),
+			Source:          config.Source,
+			Type:            config.Type,
+			Time:            time.Now().UTC(),
+			DataContentType: config.DataContentType,
+			DataSchema:      config.DataSchema,
+		}
+
+		if config.Subject != nil {
+			event.Subject = config.Subject(ctx, input)
+		}
+		if config.Extensions != nil {
+			event.Extensions = config.Extensions(ctx, input)
+		}
+
+		if input != nil {
+			switch v := input.(type) {
+			case []byte:
+				event.Data = v
+			case json.RawMessage:
+				event.Data = v
+			case string:
+				event.Data = []byte(v)
+			default:
+				data, err := json.Marshal(input)
+				if err != nil {
+					return ctx, nil, fmt.Errorf("failed to encode cloudevent data: %w", err)
+				}
+				event.Data = data
+			}
+			if event.DataContentType == "" {
+				event.DataContentType = "application/json"
+			}
+		}
+
+		if traceparent, ok := currentTraceParent(ctx); ok {
+			event.setExtension("traceparent", traceparent)
+		}
+		if tracestate, ok := TraceStateKey.Get(ctx); ok && tracestate != "" {
+			event.setExtension("tracestate", tracestate)
+		}
+
+		if err := event.Validate(); err != nil {
+			return ctx, nil, err
+		}
+
+		return ctx, event, nil
+	}
+}
+
+// setExtension sets an extension attribute, copying the extension map so that
+// maps returned by configuration callbacks are not modified.
+func (e *CloudEvent) setExtension(name string, value any) {
+	extensions := make(map[string]any, len(e.Extensions)+1)
+	for key, existing := range e.Extensions {
+		extensions[key] = existing
+	}
+	extensions[name] = value
+	e.Extensions = extensions
+}
+
+// currentTraceParent returns the W3C traceparent of the active span, or the
+// caller's traceparent when there is no span.
+func currentTraceParent(ctx context.Context) (string, bool) {
+	if span, ok := SpanFromContext(ctx); ok {
+		traceID, traceOK := w3cID(span.TraceID(), 32)
+		spanID, spanOK := w3cID(span.SpanID(), 16)
+		if traceOK && spanOK && strings.Trim(traceID, "0") != "" {
+			return "00-" + traceID + "-" + spanID + "-01", true
+		}
+	}
+
+	traceparent, ok := TraceParentKey.Get(ctx)
+	return traceparent, ok && traceparent != ""
+}
+
+// w3cID converts a span or trace ID into a lowercase hex ID of the given
+// length. Hex IDs of that length are used as they are, and decimal IDs, as
+// reported by DataDog, are converted.
+func w3cID(id string, length int) (string, bool) {
+	if len(id) == length {
+		if _, err := strconv.ParseUint(id[:length/2], 16, 64); err == nil {
+			if _, err := strconv.ParseUint(id[length/2:], 16, 64); err == nil {
+				return strings.ToLower(id), true
+			}
+		}
+	}
+
+	value, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || value == 0 {
+		return "", false
+	}
+
+	return fmt.Sprintf("%0*x", length, value), true
+}
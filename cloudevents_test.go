@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type orderPlaced struct {
+	OrderID string `json:"order_id"`
+}
+
+// decodeOrder runs DecodeCloudEvent on a request and returns the context and
+// data it produced.
+func decodeOrder(t *testing.T, r *http.Request) (context.Context, orderPlaced, error) {
+	t.Helper()
+
+	ctx, output, err := DecodeCloudEvent[orderPlaced]()(context.Background(), r)
+	if err != nil {
+		return ctx, orderPlaced{}, err
+	}
+	return ctx, output.(orderPlaced), nil
+}
+
+func TestDecodeStructuredCloudEvent(t *testing.T) {
+	body := `{
+		"specversion": "1.0",
+		"id": "evt-1",
+		"source": "/orders",
+		"type": "com.example.order.placed",
+		"datacontenttype": "application/json",
+		"data": {"order_id": "42"}
+	}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", CloudEventsContentType)
+
+	ctx, order, err := decodeOrder(t, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.OrderID != "42" {
+		t.Errorf("order = %+v, want ID 42", order)
+	}
+	if id, _ := GetRequestID(ctx); id != "evt-1" {
+		t.Errorf("request ID = %q, want the event ID", id)
+	}
+	if eventType, _ := CloudEventTypeKey.Get(ctx); eventType != "com.example.order.placed" {
+		t.Errorf("type = %q", eventType)
+	}
+}
+
+func TestDecodeBinaryCloudEvent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_id":"7"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Ce-Specversion", "1.0")
+	r.Header.Set("Ce-Id", "evt-2")
+	r.Header.Set("Ce-Source", "/orders")
+	r.Header.Set("Ce-Type", "com.example.order.placed")
+	r.Header.Set("Ce-Subject", "order%207")
+	r.Header.Set("Ce-Tenant", "acme")
+
+	ctx, order, err := decodeOrder(t, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, _ := CloudEventKey.Get(ctx)
+	if order.OrderID != "7" || envelope.Subject != "order 7" || envelope.Extensions["tenant"] != "acme" {
+		t.Errorf("decoded %+v with envelope %+v", order, envelope)
+	}
+}
+
+func TestDecodeInvalidCloudEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		header      map[string]string
+		body        string
+	}{
+		{"missing id", CloudEventsContentType, nil, `{"specversion":"1.0","source":"/orders","type":"placed"}`},
+		{"wrong specversion", CloudEventsContentType, nil, `{"specversion":"0.3","id":"1","source":"/orders","type":"placed"}`},
+		{"malformed JSON", CloudEventsContentType, nil, `{"specversion":`},
+		{"batch", "application/cloudevents-batch+json", nil, `[]`},
+		{"binary without headers", "application/json", nil, `{}`},
+		{"reserved extension", "application/json", map[string]string{
+			"Ce-Specversion": "1.0", "Ce-Id": "1", "Ce-Source": "/orders", "Ce-Type": "placed", "Ce-Data": "x",
+		}, `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+
+			if _, _, err := decodeOrder(t, r); !errors.Is(err, ErrValidation) {
+				t.Errorf("err = %v, want ErrValidation", err)
+			}
+		})
+	}
+}
+
+func TestParseCloudEventHTTPLimitsBody(t *testing.T) {
+	body := `{"specversion":"1.0","id":"1","source":"/orders","type":"placed","data":"` + strings.Repeat("x", 64) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", CloudEventsContentType)
+
+	_, err := ParseCloudEventHTTPWithLimit(r, 32)
+
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		t.Fatalf("err = %v, want *http.MaxBytesError", err)
+	}
+}
+
+func TestCloudEventTraceParentRoundTrip(t *testing.T) {
+	for _, mode := range []CloudEventMode{CloudEventBinary, CloudEventStructured} {
+		rec := NewRecordingTracer()
+		ctx, span := StartSpan(context.Background(), rec, "publish")
+		ctx = TraceStateKey.Set(ctx, "vendor=value")
+
+		_, output, err := EncodeCloudEvent("/orders", "com.example.order.placed")(ctx, orderPlaced{OrderID: "9"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := output.(CloudEvent).NewHTTPRequest(ctx, "http://broker.example.com/", mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received, order, err := decodeOrder(t, r)
+		if err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+
+		want := "00-" + strings.Repeat("0", 31) + span.TraceID() + "-" + strings.Repeat("0", 15) + span.SpanID() + "-01"
+		if traceparent, _ := TraceParentKey.Get(received); traceparent != want {
+			t.Errorf("mode %d: traceparent = %q, want %q", mode, traceparent, want)
+		}
+		if tracestate, _ := TraceStateKey.Get(received); tracestate != "vendor=value" {
+			t.Errorf("mode %d: tracestate = %q, want vendor=value", mode, tracestate)
+		}
+		if order.OrderID != "9" {
+			t.Errorf("mode %d: order = %+v, want ID 9", mode, order)
+		}
+
+		// Without a span, the caller's traceparent is forwarded as it is
+		_, output, err = EncodeCloudEvent("/orders", "com.example.order.shipped")(received, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := output.(CloudEvent).Extensions["traceparent"]; got != want {
+			t.Errorf("mode %d: forwarded traceparent = %v, want %q", mode, got, want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/raywall/go-middleware"
+	"github.com/raywall/go-middleware/tracing/ddtracer"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -20,8 +21,10 @@ func main() {
 	// Inicia o tracer do Datadog
 	tracer.Start()
 	defer tracer.Stop()
+	middleware.SetDefaultTracer(ddtracer.New())
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// O handler de contexto adiciona request_id, chain_name e trace IDs aos logs
+	logger := slog.New(middleware.NewContextHandler(slog.NewTextHandler(os.Stdout, nil)))
 
 	payload := Payload{
 		UserID: "abc123",
@@ -29,7 +32,7 @@ func main() {
 	}
 
 	// Criação da cadeia de middlewares
-	chain := middleware.NewChain(
+	chain := middleware.NewNamedChain("login",
 		middleware.Observability(logger),
 		// Aqui você poderia adicionar middleware.Validation(), Auth(), etc.
 		businessLogic(logger),
@@ -52,7 +55,7 @@ func businessLogic(logger *slog.Logger) middleware.MiddlewareFunc {
 			return ctx, nil, fmt.Errorf("payload inválido")
 		}
 
-		logger.Info("Handler executando lógica de negócio",
+		logger.InfoContext(ctx, "Handler executando lógica de negócio",
 			slog.String("user_id", payload.UserID),
 			slog.String("action", payload.Action),
 		)
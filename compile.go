@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// CompiledChain is the immutable, pre-resolved form of a Chain. Step names
+// and hooks are resolved once, so executing a CompiledChain does no work
+// beyond running the steps and their hooks.
+//
+// A CompiledChain is safe for concurrent use by multiple goroutines.
+type CompiledChain struct {
+	name  string
+	steps []step
+	hooks compiledHooks
+}
+
+// Compile resolves step names and hooks of the chain and returns the compiled
+// form. The result is cached on the chain, so calling Compile repeatedly, or
+// calling Then, which compiles on first use, is cheap.
+//
+// Compiling explicitly is useful to move the one-time cost out of the first
+// request, or to hold on to a chain that can no longer be extended.
+//
+// Example:
+//
+//	compiled := middleware.NewNamedChain("orders", authMiddleware, createOrder).Compile()
+//	ctx, result, err := compiled.Then(ctx, order)
+func (c *Chain) Compile() *CompiledChain {
+	if cc := c.compiled.Load(); cc != nil {
+		return cc
+	}
+
+	steps := make([]step, len(c.steps))
+	for i, s := range c.steps {
+		steps[i] = step{name: resolveStepName(s), fn: s.fn}
+	}
+
+	cc := &CompiledChain{
+		name:  c.name,
+		steps: steps,
+		hooks: compileHooks(c.hooks),
+	}
+
+	// Another goroutine may have compiled concurrently; both results are
+	// equivalent, so keep whichever was stored first.
+	c.compiled.CompareAndSwap(nil, cc)
+	return c.compiled.Load()
+}
+
+// Name returns the name of the compiled chain, if set.
+func (cc *CompiledChain) Name() string {
+	return cc.name
+}
+
+// Len returns the number of steps in the compiled chain.
+func (cc *CompiledChain) Len() int {
+	return len(cc.steps)
+}
+
+// StepNames returns the resolved names of the steps, in execution order.
+func (cc *CompiledChain) StepNames() []string {
+	names := make([]string, len(cc.steps))
+	for i, s := range cc.steps {
+		names[i] = s.name
+	}
+
+	return names
+}
+
+// resolveStepName returns the explicit name of a step, or derives one from the
+// name of its middleware function, e.g. "Observability" or "businessLogic".
+func resolveStepName(s step) string {
+	if s.name != "" {
+		return s.name
+	}
+
+	if s.fn == nil {
+		return "nil"
+	}
+
+	fn := runtime.FuncForPC(reflect.ValueOf(s.fn).Pointer())
+	if fn == nil {
+		return "anonymous"
+	}
+
+	name := fn.Name()
+
+	// Drop the import path and the package name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// Drop closure and method value suffixes such as ".func1" or "-fm"
+	name = strings.TrimSuffix(name, "-fm")
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 || !isGeneratedSuffix(name[i+1:]) {
+			break
+		}
+		name = name[:i]
+	}
+
+	return name
+}
+
+// isGeneratedSuffix reports whether a name segment was generated by the
+// compiler for closures, such as "func1" or "gowrap2", or is a plain number.
+func isGeneratedSuffix(segment string) bool {
+	for _, prefix := range []string{"func", "gowrap"} {
+		if strings.HasPrefix(segment, prefix) {
+			segment = segment[len(prefix):]
+			break
+		}
+	}
+
+	if segment == "" {
+		return false
+	}
+
+	for _, r := range segment {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,561 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetterIDKey holds the ID of the dead letter being replayed by
+// ReplayDeadLetter.
+var DeadLetterIDKey = NewKey[string]("dead_letter.id")
+
+// DeadLetterRecord is an input that failed permanently, with what is needed to
+// investigate and replay it.
+type DeadLetterRecord struct {
+	ID            string    `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	Chain         string    `json:"chain,omitempty"`
+	Step          string    `json:"step,omitempty"`
+	StepIndex     int       `json:"step_index"`
+	RequestID     string    `json:"request_id,omitempty"`
+	DeliveryCount int       `json:"delivery_count,omitempty"`
+	InputType     string    `json:"input_type"`
+
+	// Input is the input of the DeadLetter step, in the form returned by the
+	// policy's CaptureInput: a DeadLetterMessage for queue messages and a
+	// DeadLetterHTTPRequest for HTTP requests by default. Records read back
+	// from JSON hold it as a json.RawMessage; use DeadLetterInput to decode it.
+	Input any `json:"input"`
+
+	// Error is the message of the error returned by the chain
+	Error string `json:"error"`
+
+	// Errors holds the messages of the wrapped errors, outermost first
+	Errors []string `json:"errors,omitempty"`
+
+	// Metadata is a snapshot of the metadata when the chain failed
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// DeadLetterSink stores dead letters.
+type DeadLetterSink interface {
+	Write(ctx context.Context, record DeadLetterRecord) error
+}
+
+// DeadLetterPolicy decides which failures are dead-lettered.
+type DeadLetterPolicy struct {
+	// MaxDeliveries dead-letters inputs that failed on their last allowed
+	// delivery, according to DeliveryCountKey. Use the same value as the
+	// consumer redelivering them. When zero, only permanent failures are
+	// dead-lettered.
+	MaxDeliveries int
+
+	// Permanent reports failures that are dead-lettered on any delivery. When
+	// nil, IsPermanent is used.
+	Permanent func(err error) bool
+
+	// CaptureInput returns the form of the input stored in the record. It
+	// runs in the DeadLetter step, before later steps consume the input, and
+	// should return a value that can be encoded as JSON. When nil,
+	// CaptureDeadLetterInput is used.
+	CaptureInput func(input any) any
+
+	// Logger reports dead letters that could not be written. When nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// DeadLetterMessage is the recorded form of a queue message input, such as a
+// queuemw.Message, whose interface value cannot be encoded as JSON.
+type DeadLetterMessage struct {
+	ID           string            `json:"id,omitempty"`
+	Body         []byte            `json:"body"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	PartitionKey string            `json:"partition_key,omitempty"`
+}
+
+// DeadLetterHTTPRequest is the recorded form of an *http.Request input.
+type DeadLetterHTTPRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+
+	// Truncated reports that the body was larger than 1 MiB and only its
+	// beginning was recorded
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// NewRequest rebuilds the recorded request, e.g. to replay it.
+func (r DeadLetterHTTPRequest) NewRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+
+	return req, nil
+}
+
+// deadLetterBodyLimit is the largest request body recorded in full.
+const deadLetterBodyLimit = 1 << 20
+
+// CaptureDeadLetterInput is the default CaptureInput of DeadLetterPolicy. It
+// records queue messages, i.e. values with the ID, Body, Attributes and
+// PartitionKey methods of queuemw.Message, as a DeadLetterMessage, and an
+// *http.Request as a DeadLetterHTTPRequest. The request body is read and put
+// back, so later steps still see all of it. Other inputs are recorded as they
+// are.
+func CaptureDeadLetterInput(input any) any {
+	switch carrier := input.(type) {
+	case *http.Request:
+		return captureHTTPRequest(carrier)
+	case deadLetterMessageCarrier:
+		return DeadLetterMessage{
+			ID:           carrier.ID(),
+			Body:         carrier.Body(),
+			Attributes:   carrier.Attributes(),
+			PartitionKey: carrier.PartitionKey(),
+		}
+	}
+
+	return input
+}
+
+// deadLetterMessageCarrier matches the queuemw.Message methods describing
+// the message, which this package cannot import.
+type deadLetterMessageCarrier interface {
+	ID() string
+	Body() []byte
+	Attributes() map[string]string
+	PartitionKey() string
+}
+
+// captureHTTPRequest records a request, putting back the part of the body it
+// read.
+func captureHTTPRequest(r *http.Request) DeadLetterHTTPRequest {
+	captured := DeadLetterHTTPRequest{
+		Method: r.Method,
+		URL:    r.URL.String(),
+		Header: r.Header.Clone(),
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return captured
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(r.Body, deadLetterBodyLimit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+
+	if len(data) > deadLetterBodyLimit {
+		captured.Body = data[:deadLetterBodyLimit]
+		captured.Truncated = true
+	} else {
+		captured.Body = data
+	}
+
+	return captured
+}
+
+// DefaultDeadLetterPolicy returns the default dead letter policy, which
+// dead-letters permanent failures and failures on the fifth delivery.
+func DefaultDeadLetterPolicy() *DeadLetterPolicy {
+	return &DeadLetterPolicy{
+		MaxDeliveries: 5,
+		Permanent:     IsPermanent,
+		Logger:        slog.Default(),
+	}
+}
+
+// DeadLetter creates a middleware that writes the input of failing requests to
+// a DeadLetterSink once they will not be retried: when the failure is
+// permanent, or when it happens on the last delivery allowed by the policy.
+// Place it first in the chain, so that the record holds the original input.
+// Inputs such as queue messages and HTTP requests are recorded in a form that
+// can be encoded as JSON; see DeadLetterPolicy.CaptureInput.
+//
+// The record is written when the chain finishes, with the failing step, the
+// error chain and a snapshot of the metadata. The chain still fails as usual;
+// a failure to write the record is logged.
+//
+// Example:
+//
+//	sink, err := middleware.OpenJSONLDeadLetterSink("/var/lib/app/dead-letters.jsonl")
+//	if err != nil {
+//		return err
+//	}
+//	defer sink.Close()
+//
+//	chain := middleware.NewNamedChain("order-events",
+//		middleware.DeadLetter(sink, nil),
+//		queuemw.DecodeJSON[OrderEvent](),
+//		applyOrderEvent,
+//	)
+func DeadLetter(sink DeadLetterSink, policy *DeadLetterPolicy) MiddlewareFunc {
+	if policy == nil {
+		policy = DefaultDeadLetterPolicy()
+	}
+
+	permanent := policy.Permanent
+	if permanent == nil {
+		permanent = IsPermanent
+	}
+
+	capture := policy.CaptureInput
+	if capture == nil {
+		capture = CaptureDeadLetterInput
+	}
+
+	logger := policy.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		st := stateFrom(ctx)
+		if st == nil {
+			return ctx, input, nil
+		}
+
+		captured := capture(input)
+
+		st.onFinish(func(ctx context.Context, err error) {
+			if err == nil {
+				return
+			}
+
+			deliveries, _ := DeliveryCountKey.Get(ctx)
+			exhausted := policy.MaxDeliveries > 0 && deliveries >= policy.MaxDeliveries
+			if !exhausted && !permanent(err) {
+				return
+			}
+
+			record := newDeadLetterRecord(ctx, input, captured, err)
+			record.DeliveryCount = deliveries
+
+			if writeErr := sink.Write(ctx, record); writeErr != nil {
+				logger.ErrorContext(ctx, "Failed to write dead letter",
+					slog.String("dead_letter_id", record.ID),
+					slog.String("error", writeErr.Error()),
+				)
+			}
+		})
+
+		return ctx, input, nil
+	}
+}
+
+// newDeadLetterRecord describes a failed input, stored in its captured form.
+func newDeadLetterRecord(ctx context.Context, input, captured any, err error) DeadLetterRecord {
+	record := DeadLetterRecord{
+		ID:        newDeadLetterID(),
+		Timestamp: time.Now().UTC(),
+		InputType: typeName(input),
+		Input:     captured,
+		Error:     err.Error(),
+		Errors:    errorChain(err),
+		Metadata:  AllMetadata(ctx),
+	}
+	record.Chain, _ = GetChainName(ctx)
+	record.RequestID, _ = GetRequestID(ctx)
+
+	// The innermost ChainError names the step that failed, also when it
+	// belongs to a nested chain
+	var chainErr *ChainError
+	for errors.As(err, &chainErr) {
+		record.Step = chainErr.Step
+		record.StepIndex = chainErr.Index
+		if chainErr.Chain != "" {
+			record.Chain = chainErr.Chain
+		}
+		err = chainErr.Err
+	}
+
+	return record
+}
+
+// errorChain returns the messages of err and the errors it wraps.
+func errorChain(err error) []string {
+	var messages []string
+	for err != nil {
+		// Wrappers such as Permanent repeat the message of the error they wrap
+		if message := err.Error(); len(messages) == 0 || messages[len(messages)-1] != message {
+			messages = append(messages, message)
+		}
+
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() error }:
+			err = wrapped.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, inner := range wrapped.Unwrap() {
+				messages = append(messages, errorChain(inner)...)
+			}
+			return messages
+		default:
+			return messages
+		}
+	}
+
+	return messages
+}
+
+// newDeadLetterID returns a random dead letter ID.
+func newDeadLetterID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// DeadLetterInput returns the input of a dead letter as a value of type T,
+// decoding it when the record was read back from JSON.
+//
+// Example:
+//
+//	msg, err := middleware.DeadLetterInput[middleware.DeadLetterMessage](record)
+func DeadLetterInput[T any](record DeadLetterRecord) (T, error) {
+	if value, ok := record.Input.(T); ok {
+		return value, nil
+	}
+
+	var value T
+	data, err := json.Marshal(record.Input)
+	if err != nil {
+		return value, fmt.Errorf("failed to encode dead letter input: %w", err)
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, fmt.Errorf("failed to decode dead letter input as %T: %w", value, err)
+	}
+
+	return value, nil
+}
+
+// ReplayDeadLetter runs the input of a dead letter through a chain again, with
+// its metadata and request ID restored, DeadLetterIDKey set and the delivery
+// count reset. When decode is nil, the recorded input is passed as it is.
+//
+// Example:
+//
+//	file, _ := os.Open("/var/lib/app/dead-letters.jsonl")
+//	defer file.Close()
+//	records, err := middleware.ReadDeadLetters(file)
+//	if err != nil {
+//		return err
+//	}
+//
+//	for _, record := range records {
+//		if _, _, err := middleware.ReplayDeadLetter(ctx, chain, record, queuemw.DecodeDeadLetter); err != nil {
+//			log.Printf("dead letter %s failed again: %v", record.ID, err)
+//		}
+//	}
+func ReplayDeadLetter(ctx context.Context, chain *Chain, record DeadLetterRecord, decode func(DeadLetterRecord) (any, error)) (context.Context, any, error) {
+	input := record.Input
+	if decode != nil {
+		var err error
+		if input, err = decode(record); err != nil {
+			return ctx, nil, fmt.Errorf("failed to decode dead letter %s: %w", record.ID, err)
+		}
+	}
+
+	md := NewMetadata()
+	for key, value := range record.Metadata {
+		md.Set(key, value)
+	}
+	md.Delete(DeliveryCountKey.Name())
+
+	ctx = WithMetadata(ctx, md)
+	if record.RequestID != "" {
+		ctx = SetRequestID(ctx, record.RequestID)
+	}
+	ctx = DeadLetterIDKey.Set(ctx, record.ID)
+
+	return chain.Then(ctx, input)
+}
+
+// ReplayDeadLetters replays dead letters in order with ReplayDeadLetter and
+// returns the error of every record, nil for those that succeeded.
+func ReplayDeadLetters(ctx context.Context, chain *Chain, records []DeadLetterRecord, decode func(DeadLetterRecord) (any, error)) []error {
+	errs := make([]error, len(records))
+	for i, record := range records {
+		if ctx.Err() != nil {
+			errs[i] = ctx.Err()
+			continue
+		}
+		_, _, errs[i] = ReplayDeadLetter(ctx, chain, record, decode)
+	}
+
+	return errs
+}
+
+// MemoryDeadLetterSink is an in-memory DeadLetterSink, suitable for tests.
+type MemoryDeadLetterSink struct {
+	mu      sync.RWMutex
+	records []DeadLetterRecord
+}
+
+// NewMemoryDeadLetterSink creates an empty MemoryDeadLetterSink.
+func NewMemoryDeadLetterSink() *MemoryDeadLetterSink {
+	return &MemoryDeadLetterSink{}
+}
+
+// Write stores a record.
+func (s *MemoryDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	return nil
+}
+
+// Records returns a copy of the stored records in write order.
+func (s *MemoryDeadLetterSink) Records() []DeadLetterRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]DeadLetterRecord(nil), s.records...)
+}
+
+// Remove deletes a record, e.g. once it was replayed, and reports whether it
+// was found.
+func (s *MemoryDeadLetterSink) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, record := range s.records {
+		if record.ID == id {
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// JSONLDeadLetterSink is a DeadLetterSink that appends records to a file in
+// the JSON Lines format, one record per line. Every record is synced to disk
+// before Write returns.
+type JSONLDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenJSONLDeadLetterSink opens or creates a dead letter file, appending to
+// it. Read it back with ReadDeadLetters.
+func OpenJSONLDeadLetterSink(path string) (*JSONLDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+
+	return &JSONLDeadLetterSink{file: file}, nil
+}
+
+// Write appends a record to the file. An input or metadata values that cannot
+// be encoded as JSON are written as strings, so the record is never dropped.
+func (s *JSONLDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		record.Metadata = jsonSafeMetadata(record.Metadata)
+		if _, inputErr := json.Marshal(record.Input); inputErr != nil {
+			record.Input = fmt.Sprint(record.Input)
+		}
+		if data, err = json.Marshal(record); err != nil {
+			return fmt.Errorf("failed to encode dead letter: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errDeadLetterSinkClosed
+	}
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// Close closes the file.
+func (s *JSONLDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+var errDeadLetterSinkClosed = errors.New("dead letter sink is closed")
+
+// jsonSafeMetadata replaces metadata values that cannot be encoded as JSON
+// with their string form.
+func jsonSafeMetadata(metadata map[string]any) map[string]any {
+	safe := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprint(value)
+		}
+		safe[key] = value
+	}
+
+	return safe
+}
+
+// ReadDeadLetters reads dead letters in the JSON Lines format. Their Input
+// holds the raw JSON of the original input.
+//
+// Example:
+//
+//	file, _ := os.Open("/var/lib/app/dead-letters.jsonl")
+//	defer file.Close()
+//	records, err := middleware.ReadDeadLetters(file)
+func ReadDeadLetters(r io.Reader) ([]DeadLetterRecord, error) {
+	var records []DeadLetterRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line struct {
+			DeadLetterRecord
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("failed to decode dead letter %d: %w", len(records)+1, err)
+		}
+
+		record := line.DeadLetterRecord
+		record.Input = line.Input
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMessage has the methods of queuemw.Message describing a message.
+type testMessage struct {
+	id   string
+	body []byte
+}
+
+func (m testMessage) ID() string                    { return m.id }
+func (m testMessage) Body() []byte                  { return m.body }
+func (m testMessage) Attributes() map[string]string { return map[string]string{"type": "order"} }
+func (m testMessage) PartitionKey() string          { return "p-1" }
+
+func failPermanently(ctx context.Context, input any) (context.Context, any, error) {
+	return ctx, nil, Permanent(errors.New("bad input"))
+}
+
+// writeDeadLetter runs input through a failing chain and reads back the
+// record written to a JSONL sink.
+func writeDeadLetter(t *testing.T, input any, steps ...MiddlewareFunc) DeadLetterRecord {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	sink, err := OpenJSONLDeadLetterSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	steps = append([]MiddlewareFunc{DeadLetter(sink, nil)}, steps...)
+	steps = append(steps, failPermanently)
+	if _, _, err := NewNamedChain("orders", steps...).Then(context.Background(), input); err == nil {
+		t.Fatal("Then() succeeded; want an error")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records, err := ReadDeadLetters(file)
+	if err != nil {
+		t.Fatalf("ReadDeadLetters() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records; want 1", len(records))
+	}
+
+	return records[0]
+}
+
+func TestDeadLetterRecordsMessage(t *testing.T) {
+	record := writeDeadLetter(t, testMessage{id: "m-1", body: []byte(`{"id":7}`)})
+
+	msg, err := DeadLetterInput[DeadLetterMessage](record)
+	if err != nil {
+		t.Fatalf("DeadLetterInput() error = %v", err)
+	}
+	if msg.ID != "m-1" || string(msg.Body) != `{"id":7}` || msg.PartitionKey != "p-1" || msg.Attributes["type"] != "order" {
+		t.Errorf("recorded message = %+v", msg)
+	}
+	if record.Step != "failPermanently" {
+		t.Errorf("record.Step = %q; want %q", record.Step, "failPermanently")
+	}
+}
+
+func TestDeadLetterRecordsHTTPRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/orders", strings.NewReader(`{"id":7}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var downstream string
+	record := writeDeadLetter(t, req, func(ctx context.Context, input any) (context.Context, any, error) {
+		body, _ := io.ReadAll(input.(*http.Request).Body)
+		downstream = string(body)
+		return ctx, input, nil
+	})
+
+	if downstream != `{"id":7}` {
+		t.Errorf("body read by a later step = %q; want the full body", downstream)
+	}
+
+	recorded, err := DeadLetterInput[DeadLetterHTTPRequest](record)
+	if err != nil {
+		t.Fatalf("DeadLetterInput() error = %v", err)
+	}
+	if recorded.Method != http.MethodPost || string(recorded.Body) != `{"id":7}` || recorded.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("recorded request = %+v", recorded)
+	}
+
+	replayed, err := recorded.NewRequest(context.Background())
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if replayed.URL.String() != "http://example.com/orders" {
+		t.Errorf("replayed URL = %q", replayed.URL)
+	}
+}
+
+func TestDeadLetterFallsBackToStringInput(t *testing.T) {
+	record := writeDeadLetter(t, make(chan int))
+
+	input, err := DeadLetterInput[string](record)
+	if err != nil {
+		t.Fatalf("DeadLetterInput() error = %v", err)
+	}
+	if !strings.HasPrefix(input, "0x") {
+		t.Errorf("recorded input = %q; want the string form of the channel", input)
+	}
+	if record.InputType != "chan int" {
+		t.Errorf("record.InputType = %q; want %q", record.InputType, "chan int")
+	}
+}
@@ -59,6 +59,45 @@
 //		}
 //	}
 //
+// The returned error is a *ChainError that records the failing step and wraps the
+// original error, so errors.Is and errors.As keep working:
+//
+//	var chainErr *middleware.ChainError
+//	if errors.As(err, &chainErr) {
+//		log.Printf("step %s failed: %v", chainErr.Step, chainErr.Err)
+//	}
+//
+// # Named Steps, Hooks and Compilation
+//
+// Steps can be given explicit names with AppendNamed; other steps are named after
+// their middleware function. Hooks registered with WithHooks observe the start and
+// end of every chain and step. A chain is compiled on first use, which resolves
+// step names and hooks once; Compile does this ahead of time:
+//
+//	compiled := middleware.NewNamedChain("orders").
+//		AppendNamed("auth", authMiddleware).
+//		AppendNamed("create", createOrder).
+//		With(middleware.WithHooks(hooks)).
+//		Compile()
+//
+// # Batches, Streams and Futures
+//
+// ThenBatch and ThenStream run many inputs through a chain with bounded
+// concurrency, each with its own copy of the metadata and a derived request ID:
+//
+//	results := chain.ThenBatch(ctx, inputs, middleware.WithConcurrency(8))
+//
+//	for result := range chain.ThenStream(ctx, events, middleware.WithUnordered()) {
+//		// ...
+//	}
+//
+// ThenAsync starts a chain in the background and returns a Future; WaitAll and
+// WaitAny combine futures, and an Executor bounds the number of goroutines:
+//
+//	inventory := inventoryChain.ThenAsync(ctx, order)
+//	pricing := pricingChain.ThenAsync(ctx, order)
+//	results, err := middleware.WaitAll(ctx, inventory, pricing)
+//
 // # Built-in Middleware
 //
 // The package includes several pre-built middleware:
@@ -66,16 +105,112 @@
 //   - RequestID: Generates and tracks unique request identifiers
 //   - Timeout: Adds timeout control to request processing
 //   - Recovery: Panic recovery with graceful error handling
+//   - Redaction: Masks sensitive data in a chain's output
+//   - Audit: Tamper-evident audit trail of who did what
+//   - DeadLetter: Records inputs that failed permanently, for inspection and replay
+//
+// # Tracing Backends
+//
+// Spans are created through the Tracer interface, so the package does not depend
+// on a tracing SDK. Adapters are provided in tracing/ddtracer (DataDog) and
+// tracing/oteltracer (OpenTelemetry); RecordingTracer keeps spans in memory for
+// tests:
+//
+//	middleware.SetDefaultTracer(ddtracer.New())
+//
+// The default tracer is NoopTracer. Earlier versions reported spans to DataDog
+// APM without any setup; applications relying on that must now call
+// SetDefaultTracer(ddtracer.New()) once at startup, or set
+// ObservabilityConfig.Tracer, to keep receiving spans.
+//
+// # Metrics
+//
+// WithMetrics records executions, errors, panics and latency histograms per chain
+// and per step through the Metrics interface. MemoryMetrics keeps them in memory and
+// renders the Prometheus text format; DogStatsD sends them to a DogStatsD agent:
+//
+//	metrics := middleware.NewMemoryMetrics()
+//	chain = chain.With(middleware.WithMetrics(metrics))
+//
+// MetricsHandler serves a MemoryMetrics for scraping in the Prometheus text and
+// OpenMetrics formats:
+//
+//	http.Handle("/metrics", middleware.MetricsHandler(metrics))
+//
+// WithSLO measures a chain against a latency or availability objective, tracking
+// the error budget and multi-window burn rates, and calling OnAlert when the budget
+// burns too fast. SLOTracker.Status reports the current numbers, which are also
+// published as metrics:
+//
+//	tracker := middleware.NewSLOTracker(metrics)
+//	chain = chain.With(middleware.WithSLO(tracker, middleware.SLO{
+//		Name:             "orders-latency",
+//		Objective:        0.999,
+//		LatencyThreshold: 200 * time.Millisecond,
+//	}))
+//
+// # Transports
+//
+// The httpmw subpackage serves a chain as an http.Handler, decoding requests into
+// the chain input and mapping failures to status codes. Steps report failures
+// independently of the transport by wrapping the sentinel errors of this package,
+// such as ErrValidation or ErrNotFound:
+//
+//	http.Handle("POST /orders", httpmw.Handler(chain))
+//
+// httpmw.Router dispatches method and path patterns to chains, with route groups
+// sharing leading steps, and path parameters available through httpmw.PathParam.
+// httpmw.FromHTTP runs standard func(http.Handler) http.Handler middleware as chain
+// steps, and httpmw.ToHTTP exposes steps and chains as such middleware.
+//
+// The grpcmw subpackage builds unary and stream interceptors from chains, for
+// servers and clients, mapping the same sentinel errors to gRPC status codes.
+// The lambdamw subpackage runs chains as AWS Lambda handlers, with decoders for
+// common event types and partial batch failures for SQS.
+//
+// The queuemw subpackage runs chains as queue workers: a Consumer pulls messages
+// from a Source, acks them on success and nacks them with a delay when they fail
+// with a retryable error. Errors wrapped with Permanent are not retried.
+//
+// DecodeCloudEvent and EncodeCloudEvent unwrap and produce CloudEvents in the
+// binary and structured HTTP modes, carrying the event ID as the request ID and
+// the traceparent extension from caller to consumer.
+//
+// # Redaction
+//
+// Observability, ObservabilityComplete and Recovery remove sensitive data from the
+// values they log with a Redactor. Struct fields tagged `mw:"redact"`, map keys and
+// field names such as "password" or "api_key", and e-mail addresses, card numbers
+// and JWTs found in strings are replaced, masked or hashed:
+//
+//	type Payment struct {
+//		Card string `mw:"redact,mask"`
+//	}
+//
+// The Redaction middleware applies the same rules to a chain's output.
 //
 // # Context Values
 //
-// Middleware can store and retrieve values from the context using well-defined keys:
+// Middleware can store and retrieve values through the execution's metadata
+// container, either by name or through typed keys:
 //
 //	// Storing values
-//	ctx = context.WithValue(ctx, middleware.RequestIDKey, "req-123")
+//	ctx = middleware.AddMetadata(ctx, "validated", true)
+//	ctx = middleware.RequestIDKey.Set(ctx, "req-123")
 //
 //	// Retrieving values
-//	requestID := middleware.GetRequestID(ctx)
+//	validated, _ := middleware.GetMetadataBool(ctx, "validated")
+//	requestID, _ := middleware.GetRequestID(ctx)
+//
+// AddMetadata and Key.Set modify the container in place rather than deriving a
+// new context, so earlier contexts of the same execution see the new values.
+// Each execution starts from its own copy of the caller's metadata: values set
+// by a chain are returned through the context returned by Then and never leak
+// into the caller's context or into other executions.
+//
+// Custom typed keys are created with NewKey:
+//
+//	var TenantKey = middleware.NewKey[string]("tenant_id")
 //
 // # Performance Considerations
 //
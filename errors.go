@@ -0,0 +1,64 @@
+package middleware
+
+import "errors"
+
+// Sentinel errors classify failures independently of the transport. Steps wrap
+// them, e.g. fmt.Errorf("%w: unknown order %s", middleware.ErrNotFound, id), and
+// adapters such as httpmw map them to status codes with errors.Is.
+var (
+	// ErrValidation reports invalid input. Validation wraps it.
+	ErrValidation = errors.New("validation failed")
+
+	// ErrUnauthenticated reports missing or invalid credentials
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrPermissionDenied reports an authenticated caller that may not perform
+	// the request
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrNotFound reports a missing resource
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict reports a request that conflicts with the current state
+	ErrConflict = errors.New("conflict")
+
+	// ErrRateLimited reports a request rejected by rate limiting. RateLimit
+	// returns it.
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// Permanent marks an error as not retryable, e.g. a message that can never be
+// processed. Message consumers stop redelivering inputs that fail with it.
+//
+// Example:
+//
+//	if order.Total < 0 {
+//		return ctx, nil, middleware.Permanent(fmt.Errorf("negative total %d", order.Total))
+//	}
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether retrying err is pointless: errors marked with
+// Permanent and validation errors are permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent) || errors.Is(err, ErrValidation)
+}
+
+// permanentError marks an error as not retryable.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
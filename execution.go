@@ -0,0 +1,451 @@
+package middleware
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// execStateKey is the context key under which the execution state is stored.
+type execStateKey struct{}
+
+// execState is the state of a single chain execution. It is stored in the
+// context exactly once per Then call and doubles as the context node itself,
+// so attaching it costs a single allocation. Lookups such as GetRequestID stay
+// fast because the context does not grow with the number of steps.
+//
+// The index and name of the running step live in a stepFrame per step, carved
+// out of a buffer allocated with the state, so a context retained by a step
+// keeps reporting that step after the execution moves on. The state and its
+// frames are not recycled after Then returns, because the returned context and
+// any context retained by a step keep referencing them; only the finishers
+// slice, which nothing references once the execution ends, is pooled.
+type execState struct {
+	context.Context
+
+	chain    *CompiledChain
+	parent   *execState // state of the enclosing chain, for nested chains
+	metadata *Metadata
+
+	// frameBuf backs the step frames of short chains, to avoid a separate
+	// allocation
+	frameBuf [4]stepFrame
+
+	// ownMetadata is the execution's copy of the inherited metadata, embedded
+	// to avoid a separate allocation
+	ownMetadata Metadata
+
+	// tracer and rootSpan are set once a span covering the execution is
+	// started; every following step then runs in a child span of rootSpan
+	tracer   Tracer
+	rootSpan Span
+
+	// finishers run when the execution ends, in reverse registration order
+	finishers *[]func(ctx context.Context, err error)
+}
+
+// Value implements context.Context. Chain metadata is answered from the state;
+// every other key is looked up in the parent context.
+func (s *execState) Value(key any) any {
+	if value, ok := s.value(key); ok {
+		return value
+	}
+
+	return s.Context.Value(key)
+}
+
+// value answers the context keys owned by the execution state.
+func (s *execState) value(key any) (any, bool) {
+	switch key.(type) {
+	case execStateKey:
+		return s, true
+	case chainNameKey:
+		if s.chain.name != "" {
+			return s.chain.name, true
+		}
+	case metadataCtxKey:
+		return s.metadata, true
+	}
+
+	return nil, false
+}
+
+// stepFrameKey is the context key under which the frame of the running step
+// is stored.
+type stepFrameKey struct{}
+
+// stepFrame is the context node of a running step. It is written before the
+// step starts and never modified afterwards, so it can be read from any
+// goroutine holding the step's context. In traced executions it also carries
+// the step's span as the active span.
+type stepFrame struct {
+	context.Context
+	state *execState
+	index int
+	step  *step
+	span  Span
+}
+
+// Value implements context.Context.
+func (f *stepFrame) Value(key any) any {
+	if value, ok := f.value(key); ok {
+		return value
+	}
+
+	return f.Context.Value(key)
+}
+
+// value answers the context keys owned by the step frame. It accepts a nil
+// frame, which hides the step of a finished execution.
+func (f *stepFrame) value(key any) (any, bool) {
+	switch key.(type) {
+	case middlewareIndexKey:
+		if f == nil {
+			return nil, true
+		}
+		return f.index, true
+	case stepFrameKey:
+		if f == nil {
+			return nil, true
+		}
+		return f, true
+	case spanKey:
+		if f != nil && f.span != nil {
+			return f.span, true
+		}
+	}
+
+	return nil, false
+}
+
+// frameFrom returns the frame of the running step, or nil if the context does
+// not belong to a running step.
+func frameFrom(ctx context.Context) *stepFrame {
+	f, _ := ctx.Value(stepFrameKey{}).(*stepFrame)
+	return f
+}
+
+// stateRef is a context node placed on the context returned by Then. It hides
+// the steps of the finished execution and, for nested chains, makes the
+// enclosing execution and step current again.
+type stateRef struct {
+	context.Context
+	state    *execState // nil for a top-level execution
+	frame    *stepFrame // step of the enclosing execution, if any
+	metadata *Metadata
+}
+
+// Value implements context.Context.
+func (r *stateRef) Value(key any) any {
+	if value, ok := r.frame.value(key); ok {
+		return value
+	}
+
+	if r.state != nil {
+		if _, ok := key.(metadataCtxKey); ok {
+			return r.metadata
+		}
+
+		if value, ok := r.state.value(key); ok {
+			return value
+		}
+	}
+
+	return r.Context.Value(key)
+}
+
+// stateFrom returns the execution state stored in the context, or nil if the
+// context does not belong to a chain execution.
+func stateFrom(ctx context.Context) *execState {
+	st, _ := ctx.Value(execStateKey{}).(*execState)
+	return st
+}
+
+// traceSteps makes every following step of the execution run in a child span
+// of root.
+func (s *execState) traceSteps(tracer Tracer, root Span) {
+	s.tracer = tracer
+	s.rootSpan = root
+}
+
+// onFinish registers a function that runs when the execution ends, receiving
+// the final context and the chain error, if any.
+func (s *execState) onFinish(fn func(ctx context.Context, err error)) {
+	if s.finishers == nil {
+		s.finishers = finisherPool.Get().(*[]func(ctx context.Context, err error))
+	}
+	*s.finishers = append(*s.finishers, fn)
+}
+
+// finish runs the registered finishers, most recently registered first, and
+// returns their slice to the pool.
+func (s *execState) finish(ctx context.Context, err error) {
+	if s.finishers == nil {
+		return
+	}
+
+	finishers := *s.finishers
+	for i := len(finishers) - 1; i >= 0; i-- {
+		finishers[i](ctx, err)
+	}
+
+	clear(finishers)
+	*s.finishers = finishers[:0]
+	finisherPool.Put(s.finishers)
+	s.finishers = nil
+}
+
+// finisherPool recycles the finishers slices of ended executions.
+var finisherPool = sync.Pool{
+	New: func() any {
+		finishers := make([]func(ctx context.Context, err error), 0, 4)
+		return &finishers
+	},
+}
+
+// frames returns the step frames of the execution, one per step.
+func (s *execState) frames() []stepFrame {
+	if n := len(s.chain.steps); n > len(s.frameBuf) {
+		return make([]stepFrame, n)
+	}
+
+	return s.frameBuf[:]
+}
+
+// startStepSpan starts the child span of a step and makes it the active span
+// of the step's frame, without adding context layers. The frame reports the
+// root span while the tracer starts the child, so that the spans of
+// consecutive steps are siblings rather than children of the previous step.
+func (s *execState) startStepSpan(f *stepFrame) (context.Context, Span) {
+	f.span = s.rootSpan
+
+	ctx, span := s.tracer.StartSpan(f, f.step.name)
+	span.SetTag("step.name", f.step.name)
+	span.SetTag("step.index", f.index)
+
+	f.span = span
+	return ctx, span
+}
+
+// Then executes the compiled chain. It behaves exactly like Chain.Then.
+func (cc *CompiledChain) Then(ctx context.Context, input any) (context.Context, any, error) {
+	if len(cc.steps) == 0 && cc.hooks.empty() {
+		return ctx, input, nil
+	}
+
+	st := &execState{
+		Context: ctx,
+		chain:   cc,
+		parent:  stateFrom(ctx),
+	}
+
+	// Every execution works on its own copy-on-write copy of the inherited
+	// metadata, so its writes never leak into the caller's context or into
+	// other executions started from the same context
+	if inherited := MetadataFrom(ctx); inherited != nil {
+		inherited.snapshotInto(&st.ownMetadata)
+	}
+	st.metadata = &st.ownMetadata
+
+	// Steps of a nested chain are traced as children of the enclosing step
+	if st.parent != nil && st.parent.tracer != nil {
+		st.tracer = st.parent.tracer
+		st.rootSpan, _ = SpanFromContext(ctx)
+	}
+
+	// Only read the clock when a hook will consume the measurement
+	timed := cc.hooks.timed()
+	var startTime time.Time
+	if timed {
+		startTime = time.Now()
+	}
+
+	info := ChainInfo{Name: cc.name, Steps: len(cc.steps)}
+
+	var currentCtx context.Context = st
+	for _, hook := range cc.hooks.beforeChain {
+		currentCtx = hook(currentCtx, info)
+	}
+
+	// A panic that is not recovered still ends the execution, so finishers
+	// and AfterChain hooks get to release their resources before it propagates
+	var current *stepFrame
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr := &PanicError{Value: recovered, Stack: debug.Stack()}
+			err := &ChainError{Chain: cc.name, Err: panicErr}
+			if current != nil {
+				err.Step, err.Index = current.step.name, current.index
+			}
+			cc.afterChain(st, currentCtx, info, err, startTime, timed)
+			panic(recovered)
+		}
+	}()
+
+	var err error
+	var output any = input
+
+	// buried is set once a step returns a context other than its own frame,
+	// which may keep a frame of this execution below the returned context
+	frames := st.frames()
+	buried := false
+
+	for i := range cc.steps {
+		s := &cc.steps[i]
+
+		// Each step runs on its own frame, placed directly over the context
+		// of the previous step so that the context does not grow
+		base := currentCtx
+		if current != nil && currentCtx == current {
+			base = current.Context
+		}
+		frames[i] = stepFrame{Context: base, state: st, index: i, step: s}
+		current = &frames[i]
+
+		currentCtx, output, err = cc.runStep(st, current, s, i, output, timed)
+		if currentCtx != current {
+			buried = true
+		}
+
+		if err != nil {
+			// Wrap error with additional context information
+			err = &ChainError{Chain: cc.name, Step: s.name, Index: i, Err: err}
+			cc.afterChain(st, currentCtx, info, err, startTime, timed)
+			return st.returnContext(currentCtx, current, buried), nil, err
+		}
+	}
+
+	cc.afterChain(st, currentCtx, info, nil, startTime, timed)
+	return st.returnContext(currentCtx, current, buried), output, nil
+}
+
+// returnContext prepares the context returned by Then, so that it no longer
+// reports a step of the finished execution. For nested chains, the enclosing
+// execution and step are made current again, so that the following steps of
+// the enclosing chain do not observe the nested chain's name, step or spans.
+// The metadata of the nested execution stays attached, so its writes are
+// visible to the enclosing chain like they are to the caller of a top-level
+// chain.
+func (s *execState) returnContext(ctx context.Context, last *stepFrame, buried bool) context.Context {
+	if last != nil && ctx == last {
+		ctx = last.Context
+	}
+
+	if s.parent == nil {
+		if !buried {
+			return ctx
+		}
+		if f := frameFrom(ctx); f == nil || f.state != s {
+			return ctx
+		}
+	}
+
+	return &stateRef{Context: ctx, state: s.parent, frame: frameFrom(s.Context), metadata: s.metadata}
+}
+
+// runStep executes a single step surrounded by its step hooks and, when the
+// execution is traced, inside its own child span.
+func (cc *CompiledChain) runStep(st *execState, f *stepFrame, s *step, index int, input any, timed bool) (context.Context, any, error) {
+	if st.tracer != nil {
+		ctx, span := st.startStepSpan(f)
+		defer span.Finish()
+
+		ctx, output, err := cc.runStepHooks(st, ctx, s, index, input, timed)
+		if err != nil {
+			span.SetError(err)
+		}
+		return ctx, output, err
+	}
+
+	return cc.runStepHooks(st, f, s, index, input, timed)
+}
+
+// runStepHooks executes a single step surrounded by its step hooks, watching
+// for panics when needed.
+func (cc *CompiledChain) runStepHooks(st *execState, ctx context.Context, s *step, index int, input any, timed bool) (context.Context, any, error) {
+	if len(cc.hooks.onPanic) > 0 || len(cc.hooks.afterStep) > 0 {
+		return cc.runStepRecover(st, ctx, s, index, input, timed)
+	}
+
+	return cc.callStep(ctx, s, index, input, timed)
+}
+
+// runStepRecover executes a step while watching for panics. Panics are
+// reported to the OnPanic hooks, then to the AfterStep hooks as a *PanicError,
+// and then propagated to the caller.
+func (cc *CompiledChain) runStepRecover(st *execState, ctx context.Context, s *step, index int, input any, timed bool) (resultCtx context.Context, output any, err error) {
+	var startTime time.Time
+	if timed {
+		startTime = time.Now()
+	}
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		info := StepInfo{Chain: cc.name, Name: s.name, Index: index}
+		for _, hook := range cc.hooks.onPanic {
+			hook(ctx, info, recovered)
+		}
+
+		panicErr := &PanicError{Value: recovered, Stack: debug.Stack()}
+
+		// The step did not return, so callStep did not run the AfterStep hooks
+		if len(cc.hooks.afterStep) > 0 {
+			elapsed := time.Since(startTime)
+			for _, hook := range cc.hooks.afterStep {
+				hook(ctx, info, panicErr, elapsed)
+			}
+		}
+
+		panic(recovered)
+	}()
+
+	return cc.callStep(ctx, s, index, input, timed)
+}
+
+// callStep executes a single step surrounded by its step hooks.
+func (cc *CompiledChain) callStep(ctx context.Context, s *step, index int, input any, timed bool) (context.Context, any, error) {
+	if len(cc.hooks.beforeStep) == 0 && len(cc.hooks.afterStep) == 0 {
+		return s.fn(ctx, input)
+	}
+
+	info := StepInfo{Chain: cc.name, Name: s.name, Index: index}
+	for _, hook := range cc.hooks.beforeStep {
+		ctx = hook(ctx, info)
+	}
+
+	var startTime time.Time
+	if timed {
+		startTime = time.Now()
+	}
+
+	ctx, output, err := s.fn(ctx, input)
+
+	if len(cc.hooks.afterStep) > 0 {
+		elapsed := time.Since(startTime)
+		for _, hook := range cc.hooks.afterStep {
+			hook(ctx, info, err, elapsed)
+		}
+	}
+
+	return ctx, output, err
+}
+
+// afterChain ends the execution, running its finishers and the AfterChain
+// hooks, if any.
+func (cc *CompiledChain) afterChain(st *execState, ctx context.Context, info ChainInfo, err error, startTime time.Time, timed bool) {
+	st.finish(ctx, err)
+
+	if !timed || len(cc.hooks.afterChain) == 0 {
+		return
+	}
+
+	elapsed := time.Since(startTime)
+	for _, hook := range cc.hooks.afterChain {
+		hook(ctx, info, err, elapsed)
+	}
+}
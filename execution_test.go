@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// passThrough is a step that forwards its input unchanged.
+func passThrough(ctx context.Context, input any) (context.Context, any, error) {
+	return ctx, input, nil
+}
+
+// readRequestID is a step that looks up the request ID, as most real steps
+// do through logging.
+func readRequestID(ctx context.Context, input any) (context.Context, any, error) {
+	GetRequestID(ctx)
+	return ctx, input, nil
+}
+
+// traceNoop is a step tracing the following steps with the no-op tracer, to
+// measure the cost of step spans themselves.
+func traceNoop(ctx context.Context, input any) (context.Context, any, error) {
+	stateFrom(ctx).traceSteps(NoopTracer(), noopSpan{})
+	return ctx, input, nil
+}
+
+// baselineThen is the execution path before compiled chains: one
+// context.WithValue layer per step for the middleware index.
+func baselineThen(ctx context.Context, name string, steps []MiddlewareFunc, input any) (context.Context, any, error) {
+	ctx = context.WithValue(ctx, ChainNameKey, name)
+
+	var err error
+	output := input
+	for i, step := range steps {
+		ctx = context.WithValue(ctx, MiddlewareIndexKey, i)
+		ctx, output, err = step(ctx, output)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("middleware %d failed: %w", i, err)
+		}
+	}
+
+	return ctx, output, nil
+}
+
+func benchmarkSteps(n int) []MiddlewareFunc {
+	steps := make([]MiddlewareFunc, n)
+	for i := range steps {
+		steps[i] = readRequestID
+	}
+	steps[0] = passThrough
+	return steps
+}
+
+func BenchmarkThen(b *testing.B) {
+	for _, n := range []int{1, 5, 20} {
+		steps := benchmarkSteps(n)
+		ctx := SetRequestID(context.Background(), "req-1")
+
+		b.Run(fmt.Sprintf("baseline/steps=%d", n), func(b *testing.B) {
+			baseCtx := context.WithValue(context.Background(), RequestIDKey, "req-1")
+			b.ReportAllocs()
+			for b.Loop() {
+				baselineThen(baseCtx, "bench", steps, 1)
+			}
+		})
+
+		b.Run(fmt.Sprintf("compiled/steps=%d", n), func(b *testing.B) {
+			compiled := NewNamedChain("bench", steps...).Compile()
+			b.ReportAllocs()
+			for b.Loop() {
+				compiled.Then(ctx, 1)
+			}
+		})
+
+		b.Run(fmt.Sprintf("traced/steps=%d", n), func(b *testing.B) {
+			compiled := NewNamedChain("bench", steps...).Prepend(traceNoop).Compile()
+			b.ReportAllocs()
+			for b.Loop() {
+				compiled.Then(ctx, 1)
+			}
+		})
+	}
+}
+
+func TestStepContextKeepsItsStep(t *testing.T) {
+	var kept context.Context
+	chain := NewChain().
+		AppendNamed("keep", func(ctx context.Context, input any) (context.Context, any, error) {
+			kept = ctx
+			return ctx, input, nil
+		}).
+		AppendNamed("second", passThrough).
+		AppendNamed("third", passThrough)
+
+	ctx, _, err := chain.Then(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Then() error = %v", err)
+	}
+
+	if index, ok := GetMiddlewareIndex(kept); !ok || index != 0 {
+		t.Errorf("GetMiddlewareIndex(kept) = %d, %v; want 0, true", index, ok)
+	}
+	if name, ok := GetStepName(kept); !ok || name != "keep" {
+		t.Errorf("GetStepName(kept) = %q, %v; want %q, true", name, ok, "keep")
+	}
+	if index, ok := GetMiddlewareIndex(ctx); ok {
+		t.Errorf("GetMiddlewareIndex(returned) = %d; want no index after Then", index)
+	}
+	if name, ok := GetStepName(ctx); ok {
+		t.Errorf("GetStepName(returned) = %q; want no step after Then", name)
+	}
+}
+
+func TestReturnedContextHidesWrappedStep(t *testing.T) {
+	type wrapKey struct{}
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return context.WithValue(ctx, wrapKey{}, true), input, nil
+	})
+
+	ctx, _, _ := chain.Then(context.Background(), nil)
+	if index, ok := GetMiddlewareIndex(ctx); ok {
+		t.Errorf("GetMiddlewareIndex(returned) = %d; want no index after Then", index)
+	}
+	if ctx.Value(wrapKey{}) != true {
+		t.Error("value set by the step is missing from the returned context")
+	}
+}
+
+func TestNestedChainRestoresEnclosingStep(t *testing.T) {
+	var innerIndex, outerIndex int
+	var outerStep string
+	inner := NewChain(passThrough, func(ctx context.Context, input any) (context.Context, any, error) {
+		innerIndex, _ = GetMiddlewareIndex(ctx)
+		return ctx, input, nil
+	})
+
+	outer := NewChain(passThrough).
+		AppendNamed("nested", func(ctx context.Context, input any) (context.Context, any, error) {
+			ctx, output, err := inner.Then(ctx, input)
+			outerIndex, _ = GetMiddlewareIndex(ctx)
+			outerStep, _ = GetStepName(ctx)
+			return ctx, output, err
+		})
+
+	if _, _, err := outer.Then(context.Background(), nil); err != nil {
+		t.Fatalf("Then() error = %v", err)
+	}
+	if innerIndex != 1 {
+		t.Errorf("index in nested chain = %d; want 1", innerIndex)
+	}
+	if outerIndex != 1 || outerStep != "nested" {
+		t.Errorf("step after nested chain = %d %q; want 1 %q", outerIndex, outerStep, "nested")
+	}
+}
+
+func TestStepContextConcurrentReads(t *testing.T) {
+	var wg sync.WaitGroup
+	chain := NewChain(
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					if index, _ := GetMiddlewareIndex(ctx); index != 0 {
+						t.Errorf("GetMiddlewareIndex() = %d; want 0", index)
+						return
+					}
+				}
+			}()
+			return ctx, input, nil
+		},
+		passThrough,
+		passThrough,
+	)
+
+	for range 10 {
+		chain.Then(context.Background(), nil)
+	}
+	wg.Wait()
+}
+
+func TestExecutionMetadataIsIsolated(t *testing.T) {
+	ctx := SetRequestID(context.Background(), "outer")
+	chain := NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return AddMetadata(ctx, "step", input), input, nil
+	})
+
+	first, _, _ := chain.Then(ctx, 1)
+	second, _, _ := chain.Then(ctx, 2)
+
+	if _, ok := GetMetadata(ctx, "step"); ok {
+		t.Error("metadata written by the chain leaked into the caller's context")
+	}
+	if value, _ := GetMetadata(first, "step"); value != 1 {
+		t.Errorf("first execution metadata = %v; want 1", value)
+	}
+	if value, _ := GetMetadata(second, "step"); value != 2 {
+		t.Errorf("second execution metadata = %v; want 2", value)
+	}
+	if requestID, _ := GetRequestID(first); requestID != "outer" {
+		t.Errorf("GetRequestID() = %q; want inherited %q", requestID, "outer")
+	}
+}
+
+func TestNestedChainMetadataIsVisible(t *testing.T) {
+	inner := NewNamedChain("inner", func(ctx context.Context, input any) (context.Context, any, error) {
+		return SetUserID(ctx, "u-1"), input, nil
+	})
+
+	var userID, chainName string
+	outer := NewNamedChain("outer",
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			return inner.Then(ctx, input)
+		},
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			userID, _ = GetUserID(ctx)
+			chainName, _ = GetChainName(ctx)
+			return ctx, input, nil
+		},
+	)
+
+	if _, _, err := outer.Then(context.Background(), nil); err != nil {
+		t.Fatalf("Then() error = %v", err)
+	}
+	if userID != "u-1" {
+		t.Errorf("user ID after nested chain = %q; want %q", userID, "u-1")
+	}
+	if chainName != "outer" {
+		t.Errorf("chain name after nested chain = %q; want %q", chainName, "outer")
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// MetadataExportPolicy selects which context metadata the observability
+// middleware exports as log attributes and span tags, and how values are
+// converted.
+//
+// A key is exported when it is not denied and, if any allow rule is set, it
+// matches at least one of them. Deny rules always win.
+//
+// Example:
+//
+//	config.MetadataExport = &middleware.MetadataExportPolicy{
+//		AllowPrefixes:   []string{"http.", "tenant"},
+//		Deny:            []string{"http.authorization"},
+//		AttributePrefix: "meta.",
+//	}
+type MetadataExportPolicy struct {
+	// Allow lists metadata keys to export
+	Allow []string
+
+	// AllowPrefixes lists key prefixes to export
+	AllowPrefixes []string
+
+	// Deny lists metadata keys that are never exported
+	Deny []string
+
+	// DenyPrefixes lists key prefixes that are never exported
+	DenyPrefixes []string
+
+	// AttributePrefix is prepended to the key of every exported attribute and tag
+	AttributePrefix string
+
+	// Convert converts a metadata value into a log attribute value. Returning
+	// false skips the key. When nil, slog.AnyValue is used.
+	Convert func(key string, value any) (slog.Value, bool)
+}
+
+// DefaultMetadataExportPolicy returns a policy that exports all metadata except
+// the keys the observability middleware already logs on its own.
+func DefaultMetadataExportPolicy() *MetadataExportPolicy {
+	return &MetadataExportPolicy{
+		Deny: []string{RequestIDKey.Name(), "observed", "start_time"},
+	}
+}
+
+// Allows reports whether the policy exports the given metadata key.
+func (p *MetadataExportPolicy) Allows(key string) bool {
+	for _, denied := range p.Deny {
+		if key == denied {
+			return false
+		}
+	}
+
+	for _, prefix := range p.DenyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+
+	if len(p.Allow) == 0 && len(p.AllowPrefixes) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.Allow {
+		if key == allowed {
+			return true
+		}
+	}
+
+	for _, prefix := range p.AllowPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Attrs returns the exported metadata of the context as log attributes, in
+// sorted key order.
+func (p *MetadataExportPolicy) Attrs(ctx context.Context) []slog.Attr {
+	md := MetadataFrom(ctx)
+	if md == nil {
+		return nil
+	}
+
+	var attrs []slog.Attr
+	md.Range(func(key string, value any) bool {
+		if !p.Allows(key) {
+			return true
+		}
+
+		var attrValue slog.Value
+		if p.Convert != nil {
+			converted, ok := p.Convert(key, value)
+			if !ok {
+				return true
+			}
+			attrValue = converted
+		} else {
+			attrValue = slog.AnyValue(value)
+		}
+
+		attrs = append(attrs, slog.Attr{Key: p.AttributePrefix + key, Value: attrValue})
+		return true
+	})
+
+	return attrs
+}
+
+// exportMetadata appends the exported metadata to the log attributes and sets
+// it as tags on the span, if any. A nil policy exports nothing.
+func exportMetadata(ctx context.Context, policy *MetadataExportPolicy, span Span, logAttrs []slog.Attr) []slog.Attr {
+	if policy == nil {
+		return logAttrs
+	}
+
+	attrs := policy.Attrs(ctx)
+	if span != nil {
+		for _, attr := range attrs {
+			span.SetTag(attr.Key, attr.Value.Resolve().Any())
+		}
+	}
+
+	return append(logAttrs, attrs...)
+}
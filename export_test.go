@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestMetadataExportPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy MetadataExportPolicy
+		key    string
+		want   bool
+	}{
+		{"no rules", MetadataExportPolicy{}, "tenant", true},
+		{"allowed key", MetadataExportPolicy{Allow: []string{"tenant"}}, "tenant", true},
+		{"other key", MetadataExportPolicy{Allow: []string{"tenant"}}, "tenant_id", false},
+		{"allowed prefix", MetadataExportPolicy{AllowPrefixes: []string{"http."}}, "http.path", true},
+		{"prefix is not a substring", MetadataExportPolicy{AllowPrefixes: []string{"http."}}, "x.http.path", false},
+		{"denied key", MetadataExportPolicy{Deny: []string{"http.authorization"}}, "http.authorization", false},
+		{"deny beats allow", MetadataExportPolicy{AllowPrefixes: []string{"http."}, Deny: []string{"http.authorization"}}, "http.authorization", false},
+		{"denied prefix", MetadataExportPolicy{Allow: []string{"secret.key"}, DenyPrefixes: []string{"secret."}}, "secret.key", false},
+		{"default denies request ID", *DefaultMetadataExportPolicy(), RequestIDKey.Name(), false},
+		{"default allows the rest", *DefaultMetadataExportPolicy(), "tenant", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allows(tt.key); got != tt.want {
+				t.Errorf("Allows(%q) = %v; want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataExportPolicyAttrs(t *testing.T) {
+	attemptsKey := NewKey[int]("attempts")
+	deadlineKey := NewKey[time.Duration]("deadline")
+
+	ctx := WithMetadata(context.Background(), NewMetadata())
+	ctx = SetRequestID(ctx, "req-1")
+	ctx = SetUserID(ctx, "ada")
+	ctx = attemptsKey.Set(ctx, 3)
+	ctx = deadlineKey.Set(ctx, 2*time.Second)
+	ctx = AddMetadata(ctx, "http.authorization", "Bearer secret")
+
+	tests := []struct {
+		name   string
+		policy *MetadataExportPolicy
+		want   map[string]slog.Value
+	}{
+		{
+			name:   "typed keys keep their kind",
+			policy: &MetadataExportPolicy{Allow: []string{"attempts", "deadline", UserIDKey.Name()}},
+			want: map[string]slog.Value{
+				"attempts": slog.Int64Value(3),
+				"deadline": slog.DurationValue(2 * time.Second),
+				"user":     slog.StringValue("ada"),
+			},
+		},
+		{
+			name:   "attribute prefix",
+			policy: &MetadataExportPolicy{Allow: []string{"attempts"}, AttributePrefix: "meta."},
+			want:   map[string]slog.Value{"meta.attempts": slog.Int64Value(3)},
+		},
+		{
+			name:   "default denials and a denied key",
+			policy: &MetadataExportPolicy{Deny: append(DefaultMetadataExportPolicy().Deny, "http.authorization")},
+			want: map[string]slog.Value{
+				"attempts": slog.Int64Value(3),
+				"deadline": slog.DurationValue(2 * time.Second),
+				"user":     slog.StringValue("ada"),
+			},
+		},
+		{
+			name: "converter",
+			policy: &MetadataExportPolicy{
+				AllowPrefixes: []string{"http.", "attempts"},
+				Convert: func(key string, value any) (slog.Value, bool) {
+					if key == "http.authorization" {
+						return slog.Value{}, false
+					}
+					return slog.StringValue("n=" + slog.AnyValue(value).String()), true
+				},
+			},
+			want: map[string]slog.Value{"attempts": slog.StringValue("n=3")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := tt.policy.Attrs(ctx)
+
+			if len(attrs) != len(tt.want) {
+				t.Fatalf("Attrs() = %v; want %v", attrs, tt.want)
+			}
+			for i, attr := range attrs {
+				if i > 0 && attrs[i-1].Key >= attr.Key {
+					t.Errorf("attributes not sorted: %q before %q", attrs[i-1].Key, attr.Key)
+				}
+				if want, ok := tt.want[attr.Key]; !ok || !attr.Value.Equal(want) {
+					t.Errorf("attribute %s = %v (%s); want %v", attr.Key, attr.Value, attr.Value.Kind(), tt.want[attr.Key])
+				}
+			}
+		})
+	}
+
+	if attrs := DefaultMetadataExportPolicy().Attrs(context.Background()); attrs != nil {
+		t.Errorf("Attrs() without metadata = %v; want none", attrs)
+	}
+}
+
+func TestObservabilityExportsMetadata(t *testing.T) {
+	var logs bytes.Buffer
+	rec := NewRecordingTracer()
+	config := tracedConfig(rec)
+	config.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	config.MetadataExport = &MetadataExportPolicy{Allow: []string{"tenant"}, AttributePrefix: "meta."}
+
+	tenant := NewKey[string]("tenant")
+	chain := NewChain(
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			return tenant.Set(ctx, "acme"), input, nil
+		},
+		ObservabilityWithConfig(config),
+	)
+	if _, _, err := chain.Then(context.Background(), "input"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(bytes.SplitN(logs.Bytes(), []byte("\n"), 2)[0], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["meta.tenant"] != "acme" {
+		t.Errorf("log entry = %v; want meta.tenant=acme", entry)
+	}
+
+	if root := spansByName(rec.Spans())["middleware.request"]; root.Tags["meta.tenant"] != "acme" {
+		t.Errorf("span tags = %v; want meta.tenant=acme", root.Tags)
+	}
+}
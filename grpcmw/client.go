@@ -0,0 +1,129 @@
+package grpcmw
+
+import (
+	"context"
+
+	"github.com/raywall/go-middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClientInterceptor returns a client interceptor running the chain before
+// every unary call, with the default configuration.
+func UnaryClientInterceptor(chain *middleware.Chain) grpc.UnaryClientInterceptor {
+	return UnaryClientInterceptorWithConfig(chain, DefaultConfig())
+}
+
+// UnaryClientInterceptorWithConfig returns a client interceptor running the
+// chain before every unary call. The chain receives the request message; its
+// output is sent as the request, unless it is nil. The call runs with its own
+// copy of the caller's metadata, so values set by the chain do not leak into
+// the caller's context. The request and user IDs of the context returned by
+// the chain are sent as outgoing metadata. If the
+// chain fails, the call is not made and the failure is returned through
+// ErrorMapper.
+//
+// Example:
+//
+//	chain := middleware.NewNamedChain("orders-client", middleware.RequestID())
+//	conn, err := grpc.NewClient(target,
+//		grpc.WithUnaryInterceptor(grpcmw.UnaryClientInterceptor(chain)),
+//	)
+func UnaryClientInterceptorWithConfig(chain *middleware.Chain, config *Config) grpc.UnaryClientInterceptor {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+	mapErr := errorMapper(config)
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		// The call writes to its own copy of the caller's metadata
+		ctx = MethodKey.Set(middleware.ForkMetadata(ctx), method)
+
+		ctx, output, err := compiled.Then(ctx, req)
+		if err != nil {
+			return mapErr(err)
+		}
+
+		if output == nil {
+			output = req
+		}
+
+		return invoker(outgoingContext(ctx, config), method, output, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor running the chain on
+// every message sent by streaming calls, with the default configuration.
+func StreamClientInterceptor(chain *middleware.Chain) grpc.StreamClientInterceptor {
+	return StreamClientInterceptorWithConfig(chain, DefaultConfig())
+}
+
+// StreamClientInterceptorWithConfig returns a client interceptor running the
+// chain on every message sent by streaming calls, before SendMsg. The chain
+// output is discarded, so steps inspect messages or modify them in place. The
+// request and user IDs of the call context are sent as outgoing metadata when
+// the stream is opened. If the chain fails, SendMsg returns the failure
+// through ErrorMapper without sending the message.
+func StreamClientInterceptorWithConfig(chain *middleware.Chain, config *Config) grpc.StreamClientInterceptor {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+	mapErr := errorMapper(config)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = MethodKey.Set(middleware.ForkMetadata(ctx), method)
+
+		stream, err := streamer(outgoingContext(ctx, config), desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: stream, ctx: ctx, chain: compiled, mapErr: mapErr}, nil
+	}
+}
+
+// clientStream runs the chain on every sent message.
+type clientStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	chain  *middleware.CompiledChain
+	mapErr func(error) error
+}
+
+// SendMsg runs the chain on a message and sends it.
+func (s *clientStream) SendMsg(m any) error {
+	if _, _, err := s.chain.Then(middleware.ForkMetadata(s.ctx), m); err != nil {
+		return s.mapErr(err)
+	}
+
+	return s.ClientStream.SendMsg(m)
+}
+
+// outgoingContext adds the request and user IDs of the context to the
+// outgoing metadata.
+func outgoingContext(ctx context.Context, config *Config) context.Context {
+	var pairs []string
+
+	if config.RequestIDKey != "" {
+		if requestID, ok := middleware.GetRequestID(ctx); ok && requestID != "" {
+			pairs = append(pairs, config.RequestIDKey, requestID)
+		}
+	}
+
+	if config.UserIDKey != "" {
+		if userID, ok := middleware.GetUserID(ctx); ok && userID != "" {
+			pairs = append(pairs, config.UserIDKey, userID)
+		}
+	}
+
+	if len(pairs) == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
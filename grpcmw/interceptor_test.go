@@ -0,0 +1,174 @@
+package grpcmw
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer records the context of the calls it serves.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	calls chan context.Context
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.calls <- ctx
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	s.calls <- stream.Context()
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+// startServer serves the health service over bufconn with the given server
+// options and returns a client connection using the given dial options.
+func startServer(t *testing.T, serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) (healthpb.HealthClient, *healthServer) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(serverOpts...)
+	health := &healthServer{calls: make(chan context.Context, 1)}
+	healthpb.RegisterHealthServer(server, health)
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn), health
+}
+
+func TestUnaryServerInterceptorMapsMetadata(t *testing.T) {
+	var method, userID string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		method, _ = MethodKey.Get(ctx)
+		userID, _ = middleware.GetUserID(ctx)
+		return ctx, input, nil
+	})
+
+	client, health := startServer(t, []grpc.ServerOption{grpc.UnaryInterceptor(UnaryServerInterceptor(chain))})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), DefaultRequestIDKey, "req-1", DefaultUserIDKey, "spoofed")
+	var header metadata.MD
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	handlerCtx := <-health.calls
+	if requestID, _ := middleware.GetRequestID(handlerCtx); requestID != "req-1" {
+		t.Errorf("handler request ID = %q; want %q", requestID, "req-1")
+	}
+	if got := header.Get(DefaultRequestIDKey); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("response header %s = %v; want [req-1]", DefaultRequestIDKey, got)
+	}
+	if method != healthpb.Health_Check_FullMethodName {
+		t.Errorf("MethodKey = %q; want %q", method, healthpb.Health_Check_FullMethodName)
+	}
+	if userID != "" {
+		t.Errorf("user ID = %q; want none, as user IDs are opt-in", userID)
+	}
+}
+
+func TestUnaryServerInterceptorMapsUserIDWhenEnabled(t *testing.T) {
+	var userID string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		userID, _ = middleware.GetUserID(ctx)
+		return ctx, input, nil
+	})
+
+	config := DefaultConfig()
+	config.UserIDKey = DefaultUserIDKey
+	client, health := startServer(t, []grpc.ServerOption{grpc.UnaryInterceptor(UnaryServerInterceptorWithConfig(chain, config))})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), DefaultUserIDKey, "u-1")
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	<-health.calls
+
+	if userID != "u-1" {
+		t.Errorf("user ID = %q; want %q", userID, "u-1")
+	}
+}
+
+func TestUnaryServerInterceptorMapsErrors(t *testing.T) {
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, middleware.ErrNotFound
+	})
+
+	client, _ := startServer(t, []grpc.ServerOption{grpc.UnaryInterceptor(UnaryServerInterceptor(chain))})
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("status code = %v; want %v", code, codes.NotFound)
+	}
+}
+
+func TestStreamServerInterceptorRunsChainOnMessages(t *testing.T) {
+	received := make(chan string, 1)
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		received <- input.(*healthpb.HealthCheckRequest).GetService()
+		return ctx, input, nil
+	})
+
+	client, health := startServer(t, []grpc.ServerOption{grpc.StreamInterceptor(StreamServerInterceptor(chain))})
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: "orders"})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	<-health.calls
+
+	if service := <-received; service != "orders" {
+		t.Errorf("chain input service = %q; want %q", service, "orders")
+	}
+}
+
+func TestUnaryClientInterceptorDoesNotModifyCallerMetadata(t *testing.T) {
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return middleware.SetRequestID(ctx, "client-req"), input, nil
+	})
+
+	client, health := startServer(t,
+		[]grpc.ServerOption{grpc.UnaryInterceptor(UnaryServerInterceptor(middleware.NewChain()))},
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(chain)),
+	)
+
+	md := middleware.NewMetadata()
+	ctx := middleware.WithMetadata(context.Background(), md)
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	if requestID, _ := middleware.GetRequestID(<-health.calls); requestID != "client-req" {
+		t.Errorf("server request ID = %q; want the one set by the client chain", requestID)
+	}
+	if md.Len() != 0 {
+		t.Errorf("caller metadata = %v; want it untouched", md.All())
+	}
+}
@@ -0,0 +1,234 @@
+// Package grpcmw builds gRPC interceptors from middleware chains.
+//
+// Server interceptors run a chain before the service handler, with the request
+// message as input. Request and user IDs are read from the incoming metadata,
+// and chain failures are converted to gRPC status errors with ToStatus, so
+// steps can return the sentinel errors of the middleware package, e.g.
+// middleware.ErrNotFound:
+//
+//	chain := middleware.NewNamedChain("orders",
+//		middleware.RequestID(),
+//		middleware.Observability(logger),
+//		authorize,
+//	)
+//
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(grpcmw.UnaryServerInterceptor(chain)),
+//		grpc.StreamInterceptor(grpcmw.StreamServerInterceptor(chain)),
+//	)
+//
+// Interceptors work with any transport, including in-process servers over
+// google.golang.org/grpc/test/bufconn.
+package grpcmw
+
+import (
+	"context"
+
+	"github.com/raywall/go-middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Default metadata keys. gRPC metadata keys are lower case.
+const (
+	DefaultRequestIDKey = "x-request-id"
+	DefaultUserIDKey    = "x-user-id"
+)
+
+// MethodKey holds the full gRPC method name of the call being processed, e.g.
+// "/orders.v1.Orders/Get". The interceptors set it, as well as
+// middleware.HTTPPathKey, which gRPC uses as the HTTP/2 path, so that
+// middleware.HealthCheckPath can match gRPC health checks.
+var MethodKey = middleware.NewKey[string]("grpc.method")
+
+// Config configures the interceptors.
+type Config struct {
+	// RequestIDKey is the metadata key carrying the request ID. Server
+	// interceptors copy it into middleware.SetRequestID and echo the request
+	// ID in the response header; client interceptors send the request ID of
+	// the context. When empty, request IDs are not mapped.
+	RequestIDKey string
+
+	// UserIDKey is the metadata key carrying the user ID, e.g.
+	// DefaultUserIDKey. Server interceptors copy it into
+	// middleware.SetUserID; client interceptors send the user ID of the
+	// context. Any caller can set metadata, so only enable it behind a proxy
+	// that authenticates callers and sets the key. When empty, the default,
+	// user IDs are not mapped.
+	UserIDKey string
+
+	// ErrorMapper converts chain failures into the errors returned to gRPC.
+	// When nil, ToStatus is used.
+	ErrorMapper func(err error) error
+}
+
+// DefaultConfig returns the default interceptor configuration, mapping the
+// x-request-id metadata key. User IDs are not mapped by default; set
+// UserIDKey to opt in.
+func DefaultConfig() *Config {
+	return &Config{
+		RequestIDKey: DefaultRequestIDKey,
+		ErrorMapper:  ToStatus,
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor running the chain before
+// every unary handler, with the default configuration.
+func UnaryServerInterceptor(chain *middleware.Chain) grpc.UnaryServerInterceptor {
+	return UnaryServerInterceptorWithConfig(chain, DefaultConfig())
+}
+
+// UnaryServerInterceptorWithConfig returns a server interceptor running the
+// chain before every unary handler. The chain receives the request message;
+// its output is passed to the handler as the request, unless it is nil, and
+// the context it returns is the handler's context. If the chain fails, the
+// handler is not called and the failure is returned through ErrorMapper.
+//
+// Example:
+//
+//	config := grpcmw.DefaultConfig()
+//	config.UserIDKey = grpcmw.DefaultUserIDKey // set by the authenticating proxy
+//	server := grpc.NewServer(grpc.UnaryInterceptor(grpcmw.UnaryServerInterceptorWithConfig(chain, config)))
+func UnaryServerInterceptorWithConfig(chain *middleware.Chain, config *Config) grpc.UnaryServerInterceptor {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+	mapErr := errorMapper(config)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = incomingContext(ctx, config, info.FullMethod)
+
+		ctx, output, err := compiled.Then(ctx, req)
+		sendRequestID(ctx, config)
+		if err != nil {
+			return nil, mapErr(err)
+		}
+
+		if output == nil {
+			output = req
+		}
+
+		return handler(ctx, output)
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor running the chain on
+// every message received by streaming handlers, with the default
+// configuration.
+func StreamServerInterceptor(chain *middleware.Chain) grpc.StreamServerInterceptor {
+	return StreamServerInterceptorWithConfig(chain, DefaultConfig())
+}
+
+// StreamServerInterceptorWithConfig returns a server interceptor running the
+// chain on every message received by streaming handlers. The chain receives
+// the decoded message after RecvMsg; its output is discarded, so steps
+// inspect messages or modify them in place. Every message runs with its own
+// copy of the stream's metadata, so values set for one message do not leak
+// into the next. If the chain fails, RecvMsg returns the failure through
+// ErrorMapper.
+//
+// Example:
+//
+//	perMessage := middleware.NewNamedChain("chat-message", validateMessage, rateLimit)
+//	server := grpc.NewServer(grpc.StreamInterceptor(grpcmw.StreamServerInterceptor(perMessage)))
+func StreamServerInterceptorWithConfig(chain *middleware.Chain, config *Config) grpc.StreamServerInterceptor {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+	mapErr := errorMapper(config)
+
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := incomingContext(stream.Context(), config, info.FullMethod)
+		sendRequestID(ctx, config)
+
+		return handler(srv, &serverStream{
+			ServerStream: stream,
+			ctx:          ctx,
+			chain:        compiled,
+			mapErr:       mapErr,
+		})
+	}
+}
+
+// serverStream runs the chain on every received message.
+type serverStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chain  *middleware.CompiledChain
+	mapErr func(error) error
+}
+
+// Context returns the stream context, carrying the request and user IDs.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// RecvMsg receives a message and runs the chain on it.
+func (s *serverStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if _, _, err := s.chain.Then(middleware.ForkMetadata(s.ctx), m); err != nil {
+		return s.mapErr(err)
+	}
+
+	return nil
+}
+
+// incomingContext copies the request and user IDs of the incoming metadata
+// into a copy of the context's metadata, and records the called method.
+func incomingContext(ctx context.Context, config *Config, fullMethod string) context.Context {
+	ctx = middleware.ForkMetadata(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if value := firstValue(md, config.RequestIDKey); value != "" {
+			ctx = middleware.SetRequestID(ctx, value)
+		}
+		if value := firstValue(md, config.UserIDKey); value != "" {
+			ctx = middleware.SetUserID(ctx, value)
+		}
+	}
+
+	ctx = MethodKey.Set(ctx, fullMethod)
+	return middleware.HTTPPathKey.Set(ctx, fullMethod)
+}
+
+// sendRequestID echoes the request ID of the context in the response header.
+func sendRequestID(ctx context.Context, config *Config) {
+	if config.RequestIDKey == "" {
+		return
+	}
+
+	if requestID, ok := middleware.GetRequestID(ctx); ok && requestID != "" {
+		// Fails only if the header was already sent, e.g. by the handler
+		_ = grpc.SetHeader(ctx, metadata.Pairs(config.RequestIDKey, requestID))
+	}
+}
+
+// firstValue returns the first value of a metadata key, or "" if the key is
+// empty or missing.
+func firstValue(md metadata.MD, key string) string {
+	if key == "" {
+		return ""
+	}
+
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// errorMapper returns the configured error mapper, or ToStatus.
+func errorMapper(config *Config) func(error) error {
+	if config.ErrorMapper != nil {
+		return config.ErrorMapper
+	}
+
+	return ToStatus
+}
@@ -0,0 +1,94 @@
+package grpcmw
+
+import (
+	"context"
+	"errors"
+
+	"github.com/raywall/go-middleware"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code maps the cause of a failure to a gRPC status code. The chain of
+// wrapped errors, including *middleware.ChainError, is inspected for:
+//   - gRPC status errors, which keep their code
+//   - middleware.ErrValidation: InvalidArgument
+//   - middleware.ErrUnauthenticated: Unauthenticated
+//   - middleware.ErrPermissionDenied: PermissionDenied
+//   - middleware.ErrNotFound: NotFound
+//   - middleware.ErrConflict: AlreadyExists
+//   - middleware.ErrRateLimited: ResourceExhausted
+//   - context.Canceled: Canceled
+//   - context.DeadlineExceeded: DeadlineExceeded
+//   - *middleware.PanicError: Internal
+//
+// Any other error is Unknown.
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+
+	if s, ok := statusOf(err); ok {
+		return s.Code()
+	}
+
+	var panicErr *middleware.PanicError
+
+	switch {
+	case errors.Is(err, middleware.ErrValidation):
+		return codes.InvalidArgument
+	case errors.Is(err, middleware.ErrUnauthenticated):
+		return codes.Unauthenticated
+	case errors.Is(err, middleware.ErrPermissionDenied):
+		return codes.PermissionDenied
+	case errors.Is(err, middleware.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, middleware.ErrConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, middleware.ErrRateLimited):
+		return codes.ResourceExhausted
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.As(err, &panicErr):
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
+
+// ToStatus converts a chain failure into a gRPC status error with the code
+// chosen by Code. Status errors returned by steps are passed through as they
+// are. Other errors carry the message of the failing step, except Internal
+// and Unknown failures, which only carry their code so that internal details
+// do not leak to callers.
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if s, ok := statusOf(err); ok {
+		return s.Err()
+	}
+
+	code := Code(err)
+	if code == codes.Internal || code == codes.Unknown {
+		return status.Error(code, code.String())
+	}
+
+	return status.Error(code, middleware.ErrorMessage(err))
+}
+
+// statusOf returns the gRPC status wrapped by err, if any, without the
+// messages of the errors wrapping it.
+func statusOf(err error) (*status.Status, bool) {
+	var carrier interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &carrier) {
+		return nil, false
+	}
+
+	s := carrier.GRPCStatus()
+	return s, s != nil
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// Typed keys used to detect health checks
+var (
+	// HTTPPathKey holds the URL path of the HTTP request being processed.
+	// HTTP adapters set it so that HealthCheckPath can match decoded inputs.
+	HTTPPathKey = NewKey[string]("http.path")
+
+	// HealthCheckKey marks a request as a health check. The observability
+	// middleware sets it for matched requests, and HealthCheckMetadata matches it.
+	HealthCheckKey = NewKey[bool]("health_check")
+)
+
+// HealthCheckMatcher reports whether a request is a health check. Matched
+// requests are not logged or traced by the observability middleware when
+// ObservabilityConfig.SkipHealthChecks is set.
+type HealthCheckMatcher func(ctx context.Context, input any) bool
+
+// HTTPRequestCarrier is implemented by inputs that wrap an HTTP request, such
+// as the request/response pairs passed by HTTP adapters.
+type HTTPRequestCarrier interface {
+	HTTPRequest() *http.Request
+}
+
+// DefaultHealthCheckMatcher returns the matcher used when SkipHealthChecks is
+// set without a HealthCheck matcher. It matches the common probe paths
+// /health, /healthz, /livez, /readyz and /ping, and requests flagged with
+// HealthCheckKey.
+func DefaultHealthCheckMatcher() HealthCheckMatcher {
+	return AnyHealthCheck(
+		HealthCheckPath("/health", "/healthz", "/livez", "/readyz", "/ping"),
+		HealthCheckMetadata(HealthCheckKey.Name()),
+	)
+}
+
+// HealthCheckPath matches requests whose HTTP path is one of the given paths.
+// The path is taken from an *http.Request input, from an input implementing
+// HTTPRequestCarrier, or from HTTPPathKey in the metadata.
+//
+// Example:
+//
+//	config.HealthCheck = middleware.HealthCheckPath("/status")
+func HealthCheckPath(paths ...string) HealthCheckMatcher {
+	set := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		set[path] = struct{}{}
+	}
+
+	return func(ctx context.Context, input any) bool {
+		path, ok := requestPath(ctx, input)
+		if !ok {
+			return false
+		}
+
+		_, matched := set[path]
+		return matched
+	}
+}
+
+// HealthCheckMetadata matches requests whose metadata holds true under the
+// given key.
+//
+// Example:
+//
+//	config.HealthCheck = middleware.HealthCheckMetadata("synthetic_probe")
+func HealthCheckMetadata(key string) HealthCheckMatcher {
+	return func(ctx context.Context, input any) bool {
+		flag, ok := GetMetadataBool(ctx, key)
+		return ok && flag
+	}
+}
+
+// HealthCheckInputType matches requests whose input is of type T or *T.
+//
+// Example:
+//
+//	type PingRequest struct{}
+//
+//	config.HealthCheck = middleware.HealthCheckInputType[PingRequest]()
+func HealthCheckInputType[T any]() HealthCheckMatcher {
+	return func(ctx context.Context, input any) bool {
+		switch input.(type) {
+		case T, *T:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// AnyHealthCheck matches requests matched by any of the given matchers.
+func AnyHealthCheck(matchers ...HealthCheckMatcher) HealthCheckMatcher {
+	return func(ctx context.Context, input any) bool {
+		for _, matcher := range matchers {
+			if matcher(ctx, input) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// requestPath extracts the HTTP path of the request being processed.
+func requestPath(ctx context.Context, input any) (string, bool) {
+	switch v := input.(type) {
+	case *http.Request:
+		if v != nil && v.URL != nil {
+			return v.URL.Path, true
+		}
+	case HTTPRequestCarrier:
+		if r := v.HTTPRequest(); r != nil && r.URL != nil {
+			return r.URL.Path, true
+		}
+	}
+
+	return HTTPPathKey.Get(ctx)
+}
+
+// isHealthCheck applies the health check configuration of the observability
+// middleware to a request. The default matcher is set when the middleware is
+// created, so that it is not rebuilt for every request.
+func (config *ObservabilityConfig) isHealthCheck(ctx context.Context, input any) bool {
+	if !config.SkipHealthChecks {
+		return false
+	}
+
+	if flagged, _ := HealthCheckKey.Get(ctx); flagged {
+		return true
+	}
+
+	return config.HealthCheck != nil && config.HealthCheck(ctx, input)
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestObservabilitySkipsDefaultHealthChecks(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecordingTracer()
+	config := DefaultObservabilityConfig()
+	config.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	config.Tracer = rec
+	observe := ObservabilityWithConfig(config)
+
+	if config.HealthCheck == nil {
+		t.Fatal("default health check matcher not set at construction")
+	}
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		ctx, _, err := observe(context.Background(), httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flagged, _ := HealthCheckKey.Get(ctx); !flagged {
+			t.Errorf("%s: request not flagged as health check", path)
+		}
+	}
+	if buf.Len() != 0 || len(rec.Spans()) != 0 {
+		t.Errorf("health checks were observed: %d spans, logs %q", len(rec.Spans()), buf.String())
+	}
+
+	if _, _, err := observe(context.Background(), httptest.NewRequest("GET", "/orders", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 || len(rec.Spans()) != 1 {
+		t.Errorf("regular request was not observed: %d spans, logs %q", len(rec.Spans()), buf.String())
+	}
+}
+
+func BenchmarkHealthCheckMatching(b *testing.B) {
+	config := DefaultObservabilityConfig()
+	ObservabilityWithConfig(config)
+	ctx := context.Background()
+	req := httptest.NewRequest("GET", "/orders", nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		config.isHealthCheck(ctx, req)
+	}
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"time"
+)
+
+// ChainInfo describes a chain execution to hooks.
+type ChainInfo struct {
+	// Name is the name of the chain, if set
+	Name string
+
+	// Steps is the number of steps in the chain
+	Steps int
+}
+
+// StepInfo describes a single step execution to hooks.
+type StepInfo struct {
+	// Chain is the name of the chain the step belongs to, if set
+	Chain string
+
+	// Name is the resolved step name
+	Name string
+
+	// Index is the position of the step in the chain
+	Index int
+}
+
+// Hooks observe the execution of a chain. Every field is optional.
+//
+// BeforeChain and BeforeStep may return a derived context, which is used for
+// the rest of the execution; they must return the received context when they
+// have nothing to add. Hooks run synchronously, so they should be lightweight.
+//
+// Example:
+//
+//	hooks := middleware.Hooks{
+//		AfterStep: func(ctx context.Context, info middleware.StepInfo, err error, elapsed time.Duration) {
+//			logger.Debug("step finished", slog.String("step", info.Name), slog.Duration("elapsed", elapsed))
+//		},
+//	}
+//	chain = chain.With(middleware.WithHooks(hooks))
+type Hooks struct {
+	// BeforeChain is called once before the first step runs
+	BeforeChain func(ctx context.Context, info ChainInfo) context.Context
+
+	// AfterChain is called once after the last step ran or a step failed
+	AfterChain func(ctx context.Context, info ChainInfo, err error, elapsed time.Duration)
+
+	// BeforeStep is called before each step runs
+	BeforeStep func(ctx context.Context, info StepInfo) context.Context
+
+	// AfterStep is called after each step returns, or panics, in which case
+	// err is a *PanicError
+	AfterStep func(ctx context.Context, info StepInfo, err error, elapsed time.Duration)
+
+	// OnPanic is called when a step panics, before the panic is recovered by
+	// a Recovery middleware or propagated to the caller
+	OnPanic func(ctx context.Context, info StepInfo, recovered any)
+}
+
+// WithHooks returns a ChainOption that registers hooks on a chain. Hooks
+// registered first run first.
+//
+// Example:
+//
+//	chain = chain.With(middleware.WithHooks(auditHooks, debugHooks))
+func WithHooks(hooks ...Hooks) ChainOption {
+	return func(c *Chain) {
+		c.hooks = append(c.hooks, hooks...)
+	}
+}
+
+// compiledHooks holds the non-nil hook functions of a chain, resolved once by
+// Compile so that execution does not need to inspect every Hooks value.
+type compiledHooks struct {
+	beforeChain []func(context.Context, ChainInfo) context.Context
+	afterChain  []func(context.Context, ChainInfo, error, time.Duration)
+	beforeStep  []func(context.Context, StepInfo) context.Context
+	afterStep   []func(context.Context, StepInfo, error, time.Duration)
+	onPanic     []func(context.Context, StepInfo, any)
+}
+
+// compileHooks flattens a list of Hooks into per-event function slices.
+func compileHooks(hooks []Hooks) compiledHooks {
+	var ch compiledHooks
+	for _, h := range hooks {
+		if h.BeforeChain != nil {
+			ch.beforeChain = append(ch.beforeChain, h.BeforeChain)
+		}
+		if h.AfterChain != nil {
+			ch.afterChain = append(ch.afterChain, h.AfterChain)
+		}
+		if h.BeforeStep != nil {
+			ch.beforeStep = append(ch.beforeStep, h.BeforeStep)
+		}
+		if h.AfterStep != nil {
+			ch.afterStep = append(ch.afterStep, h.AfterStep)
+		}
+		if h.OnPanic != nil {
+			ch.onPanic = append(ch.onPanic, h.OnPanic)
+		}
+	}
+
+	return ch
+}
+
+// empty reports whether no hook functions are registered.
+func (ch *compiledHooks) empty() bool {
+	return len(ch.beforeChain) == 0 && len(ch.afterChain) == 0 &&
+		len(ch.beforeStep) == 0 && len(ch.afterStep) == 0 && len(ch.onPanic) == 0
+}
+
+// timed reports whether any hook needs elapsed time measurements.
+func (ch *compiledHooks) timed() bool {
+	return len(ch.afterChain) > 0 || len(ch.afterStep) > 0
+}
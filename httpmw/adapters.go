@@ -0,0 +1,258 @@
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/raywall/go-middleware"
+)
+
+// ErrResponseWritten is returned by FromHTTP steps when the wrapped net/http
+// middleware answered the request itself, e.g. an auth middleware rejecting it
+// or a CORS middleware answering a preflight request. It stops the chain;
+// Handler and ToHTTP do not write anything else for it.
+var ErrResponseWritten = errors.New("httpmw: response already written")
+
+// Exchange is the request/response pair of an HTTP request, used as chain
+// input by steps adapted from net/http middleware. It implements
+// middleware.HTTPRequestCarrier, so health check matchers see the request.
+type Exchange struct {
+	W http.ResponseWriter
+	R *http.Request
+
+	state *exchangeState
+}
+
+// exchangeState is shared by the exchanges derived from the same request.
+type exchangeState struct {
+	mu      sync.Mutex
+	current *Exchange
+	done    []func()
+}
+
+// NewExchange creates the Exchange of a request.
+func NewExchange(w http.ResponseWriter, r *http.Request) *Exchange {
+	exchange := &Exchange{W: w, R: r, state: &exchangeState{}}
+	exchange.state.current = exchange
+	return exchange
+}
+
+// HTTPRequest implements middleware.HTTPRequestCarrier.
+func (e *Exchange) HTTPRequest() *http.Request {
+	return e.R
+}
+
+// Current returns the latest exchange derived from e. Its ResponseWriter
+// includes the wrappers installed by net/http middleware, e.g. compression,
+// so responses must be written through it.
+func (e *Exchange) Current() *Exchange {
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+
+	return e.state.current
+}
+
+// Done completes the exchange once the response was written: net/http
+// middleware run by FromHTTP return from their handlers, most recent first,
+// e.g. flushing compressed output. Handler and ToHTTP call it; code running
+// chains with Exchange inputs by other means must call it too. Calling Done
+// more than once has no effect.
+func (e *Exchange) Done() {
+	e.state.mu.Lock()
+	done := e.state.done
+	e.state.done = nil
+	e.state.mu.Unlock()
+
+	for i := len(done) - 1; i >= 0; i-- {
+		done[i]()
+	}
+}
+
+// derive records the exchange seen by the next handler of a net/http
+// middleware and a function completing that middleware.
+func (e *Exchange) derive(w http.ResponseWriter, r *http.Request, done func()) *Exchange {
+	next := &Exchange{W: w, R: r, state: e.state}
+
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+
+	e.state.current = next
+	e.state.done = append(e.state.done, done)
+	return next
+}
+
+// responseWriterKey carries the ResponseWriter of a Handler to the Exchanges
+// decoder.
+type responseWriterKey struct{}
+
+// Exchanges returns a Decoder that passes the request/response pair as an
+// *Exchange, for chains using FromHTTP or HandlerStep. Handler does not encode
+// *Exchange outputs, since those chains write the response themselves.
+//
+// Example:
+//
+//	chain := middleware.NewChain(
+//		httpmw.FromHTTP(cors.Default().Handler),
+//		httpmw.FromHTTP(gziphandler.GzipHandler),
+//		httpmw.HandlerStep(fileServer),
+//	)
+//	http.Handle("/", httpmw.HandlerWithConfig(chain, &httpmw.Config{Decoder: httpmw.Exchanges()}))
+func Exchanges() Decoder {
+	return func(r *http.Request) (any, error) {
+		w, ok := r.Context().Value(responseWriterKey{}).(http.ResponseWriter)
+		if !ok {
+			return nil, errors.New("httpmw: Exchanges decoder requires an httpmw Handler")
+		}
+
+		return NewExchange(w, r), nil
+	}
+}
+
+// FromHTTP adapts standard net/http middleware, such as CORS, compression or
+// authentication, into a chain step. The step input must be an *Exchange.
+//
+// The middleware runs until it calls its next handler. The step then returns
+// the exchange next received as output, with its request context as the
+// step's context, so values added by the middleware are visible to the
+// following steps. The middleware's next handler returns once the exchange is
+// Done, which lets response wrappers finish their work after the response was
+// written, or once the request context is canceled. If the middleware answers
+// without calling next, the step fails with ErrResponseWritten; if the context
+// is canceled before the middleware calls next, the step fails with the
+// context's error.
+//
+// Example:
+//
+//	chain := middleware.NewChain(
+//		httpmw.FromHTTP(corsMiddleware),
+//		httpmw.FromHTTP(authMiddleware),
+//		handleRequest,
+//	)
+func FromHTTP(mw func(http.Handler) http.Handler) middleware.MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		exchange, ok := input.(*Exchange)
+		if !ok {
+			return ctx, nil, fmt.Errorf("httpmw: FromHTTP requires an *httpmw.Exchange input, got %T", input)
+		}
+
+		entered := make(chan *Exchange, 1)
+		release := make(chan struct{})
+		finished := make(chan struct{})
+		var panicked any
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var once sync.Once
+			entered <- exchange.derive(w, r, func() {
+				once.Do(func() { close(release) })
+				<-finished
+				if panicked != nil {
+					panic(panicked)
+				}
+			})
+
+			// The server cancels the request context once its handler
+			// returned, which also releases exchanges nobody completed
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+
+		go func() {
+			defer close(finished)
+			defer func() {
+				panicked = recover()
+			}()
+
+			mw(next).ServeHTTP(exchange.W, exchange.R.WithContext(ctx))
+		}()
+
+		select {
+		case derived := <-entered:
+			return derived.R.Context(), derived, nil
+		case <-finished:
+			if panicked != nil {
+				panic(panicked)
+			}
+			return ctx, nil, ErrResponseWritten
+		case <-ctx.Done():
+			return ctx, nil, ctx.Err()
+		}
+	}
+}
+
+// HandlerStep adapts an http.Handler into a chain step that serves the
+// request with the current *Exchange input and passes the exchange on.
+//
+// Example:
+//
+//	chain := middleware.NewChain(httpmw.FromHTTP(gzipMiddleware), httpmw.HandlerStep(fileServer))
+func HandlerStep(h http.Handler) middleware.MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		exchange, ok := input.(*Exchange)
+		if !ok {
+			return ctx, nil, fmt.Errorf("httpmw: HandlerStep requires an *httpmw.Exchange input, got %T", input)
+		}
+
+		current := exchange.Current()
+		h.ServeHTTP(current.W, current.R.WithContext(ctx))
+		return ctx, current, nil
+	}
+}
+
+// ToHTTP exposes a step, or a whole chain through its Then method, as
+// standard net/http middleware. The step runs with the request context and an
+// *Exchange input; the next handler runs with the context returned by the step,
+// so values it adds reach the handler. If the step fails, the failure is
+// written with JSONErrorEncoder and next is not called.
+//
+// Example:
+//
+//	auth := middleware.NewNamedChain("auth", validateToken, loadUser)
+//	http.Handle("/orders", httpmw.ToHTTP(auth.Then)(ordersHandler))
+func ToHTTP(mw middleware.MiddlewareFunc) func(http.Handler) http.Handler {
+	return ToHTTPWithConfig(mw, DefaultConfig())
+}
+
+// ToHTTPWithConfig is like ToHTTP, using the ErrorEncoder and RequestIDHeader
+// of the given configuration.
+func ToHTTPWithConfig(mw middleware.MiddlewareFunc, config *Config) func(http.Handler) http.Handler {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	errorEncoder := config.ErrorEncoder
+	if errorEncoder == nil {
+		errorEncoder = JSONErrorEncoder
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := middleware.ForkMetadata(r.Context())
+			if config.RequestIDHeader != "" {
+				if requestID := r.Header.Get(config.RequestIDHeader); requestID != "" {
+					ctx = middleware.SetRequestID(ctx, requestID)
+				}
+			}
+
+			exchange := NewExchange(w, r.WithContext(ctx))
+			defer exchange.Done()
+
+			ctx, _, err := mw(ctx, exchange)
+			current := exchange.Current()
+			writeRequestID(current.W, ctx, config.RequestIDHeader)
+
+			if err != nil {
+				if !errors.Is(err, ErrResponseWritten) {
+					errorEncoder(current.W, r.WithContext(ctx), err)
+				}
+				return
+			}
+
+			next.ServeHTTP(current.W, current.R.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raywall/go-middleware"
+)
+
+type tenantKey struct{}
+
+// tagging returns net/http middleware setting a response header and a request
+// context value, and recording in log when its next handler returned.
+func tagging(name string, log *[]string) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, name)))
+
+			mu.Lock()
+			*log = append(*log, name)
+			mu.Unlock()
+		})
+	}
+}
+
+// exchangeConfig returns a Handler configuration passing *Exchange inputs.
+func exchangeConfig() *Config {
+	config := DefaultConfig()
+	config.Decoder = Exchanges()
+	return config
+}
+
+func TestFromHTTPPassesThrough(t *testing.T) {
+	var completed []string
+	var tenant any
+	chain := middleware.NewChain(
+		FromHTTP(tagging("outer", &completed)),
+		FromHTTP(tagging("inner", &completed)),
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			tenant = ctx.Value(tenantKey{})
+			return ctx, input, nil
+		},
+		HandlerStep(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("queued"))
+		})),
+	)
+
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(chain, exchangeConfig()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", nil))
+
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "queued" {
+		t.Errorf("response = %d %q, want 202 queued", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Values("X-Middleware"); !slices.Equal(got, []string{"outer", "inner"}) {
+		t.Errorf("X-Middleware = %v, want [outer inner]", got)
+	}
+	// Context values added by the middleware reach the following steps
+	if tenant != "inner" {
+		t.Errorf("context value in step = %v, want inner", tenant)
+	}
+	// The middleware finish once the response was written, innermost first
+	if !slices.Equal(completed, []string{"inner", "outer"}) {
+		t.Errorf("completed = %v, want [inner outer]", completed)
+	}
+}
+
+func TestFromHTTPShortCircuits(t *testing.T) {
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+		})
+	}
+	chain := middleware.NewChain(
+		FromHTTP(reject),
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			t.Error("step ran after the middleware answered")
+			return ctx, input, nil
+		},
+	)
+
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(chain, exchangeConfig()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusUnauthorized || rec.Body.String() != "missing token\n" {
+		t.Errorf("response = %d %q, want only the middleware's answer", rec.Code, rec.Body)
+	}
+
+	_, _, err := FromHTTP(reject)(context.Background(), NewExchange(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)))
+	if !errors.Is(err, ErrResponseWritten) {
+		t.Errorf("err = %v, want ErrResponseWritten", err)
+	}
+}
+
+func TestFromHTTPRejectsOtherInputs(t *testing.T) {
+	if _, _, err := FromHTTP(tagging("unused", new([]string)))(context.Background(), "input"); err == nil {
+		t.Error("FromHTTP accepted a non-exchange input")
+	}
+}
+
+func TestFromHTTPReleasesOnCancel(t *testing.T) {
+	returned := make(chan struct{})
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			close(returned)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exchange := NewExchange(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if _, _, err := FromHTTP(mw)(ctx, exchange); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exchange is never Done; canceling the request releases the middleware
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("middleware still waiting after the context was canceled")
+	}
+}
+
+func TestFromHTTPCanceledBeforeNext(t *testing.T) {
+	proceed, returned := make(chan struct{}), make(chan struct{})
+	slow := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-proceed
+			next.ServeHTTP(w, r)
+			close(returned)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exchange := NewExchange(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if _, _, err := FromHTTP(slow)(ctx, exchange); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	close(proceed)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("middleware still waiting after the step gave up")
+	}
+}
+
+func TestToHTTPRunsStepBeforeNext(t *testing.T) {
+	step := func(ctx context.Context, input any) (context.Context, any, error) {
+		input.(*Exchange).Current().W.Header().Set("X-Step", "auth")
+		return middleware.SetUserID(ctx, "ada"), input, nil
+	}
+
+	var user string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = middleware.GetUserID(r.Context())
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(DefaultRequestIDHeader, "req-7")
+	rec := httptest.NewRecorder()
+	ToHTTP(step)(next).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted || rec.Header().Get("X-Step") != "auth" {
+		t.Errorf("response = %d with X-Step %q, want 202 and auth", rec.Code, rec.Header().Get("X-Step"))
+	}
+	if user != "ada" {
+		t.Errorf("user in next handler = %q, want ada", user)
+	}
+	if got := rec.Header().Get(DefaultRequestIDHeader); got != "req-7" {
+		t.Errorf("request ID header = %q, want req-7", got)
+	}
+}
+
+func TestToHTTPStopsOnError(t *testing.T) {
+	step := func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, middleware.ErrNotFound
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler ran after the step failed")
+	})
+
+	rec := httptest.NewRecorder()
+	ToHTTP(step)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if body := decodeError(t, rec); body.Error != middleware.ErrNotFound.Error() {
+		t.Errorf("error = %q, want %q", body.Error, middleware.ErrNotFound)
+	}
+}
+
+func TestToHTTPIsolatesSharedBaseMetadata(t *testing.T) {
+	base := middleware.WithMetadata(context.Background(), middleware.NewMetadata())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(base)
+	req.Header.Set(DefaultRequestIDHeader, "req-8")
+	ToHTTP(passThrough)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if requestID, leaked := middleware.GetRequestID(base); leaked {
+		t.Errorf("request ID %q written to the base context", requestID)
+	}
+}
+
+func TestExchangeDoneCompletesOnce(t *testing.T) {
+	var completed []string
+	exchange := NewExchange(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	ctx := context.Background()
+
+	ctx, output, err := FromHTTP(tagging("first", &completed))(ctx, exchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FromHTTP(tagging("second", &completed))(ctx, output); err != nil {
+		t.Fatal(err)
+	}
+
+	current := exchange.Current()
+	if current == exchange || current.R.Context().Value(tenantKey{}) != "second" {
+		t.Errorf("current exchange does not carry the request seen by the last middleware")
+	}
+	if current.HTTPRequest() != current.R {
+		t.Error("HTTPRequest does not return the exchange's request")
+	}
+
+	exchange.Done()
+	exchange.Done()
+	if !slices.Equal(completed, []string{"second", "first"}) {
+		t.Errorf("completed = %v, want [second first]", completed)
+	}
+}
@@ -0,0 +1,116 @@
+package httpmw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/raywall/go-middleware"
+)
+
+// Decoder converts an HTTP request into the input of a chain. Decoding errors
+// should wrap middleware.ErrValidation so they are reported as 400 Bad Request.
+type Decoder func(r *http.Request) (any, error)
+
+// Request returns a Decoder that passes the *http.Request itself as the input.
+func Request() Decoder {
+	return func(r *http.Request) (any, error) {
+		return r, nil
+	}
+}
+
+// JSON returns a Decoder that decodes the JSON request body into a value of
+// type T. Malformed bodies and unknown fields are rejected with an error
+// wrapping middleware.ErrValidation.
+//
+// Example:
+//
+//	type CreateOrder struct {
+//		Product  string `json:"product"`
+//		Quantity int    `json:"quantity"`
+//	}
+//
+//	config.Decoder = httpmw.JSON[CreateOrder]()
+func JSON[T any]() Decoder {
+	return func(r *http.Request) (any, error) {
+		var value T
+		if r.Body == nil {
+			return nil, fmt.Errorf("%w: request body is empty", middleware.ErrValidation)
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&value); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, err
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("%w: request body is empty", middleware.ErrValidation)
+			}
+			return nil, fmt.Errorf("%w: invalid JSON body: %w", middleware.ErrValidation, err)
+		}
+
+		return value, nil
+	}
+}
+
+// Query returns a Decoder that passes the query parameters as a
+// map[string]string, keeping the first value of repeated parameters.
+//
+// Example:
+//
+//	// GET /orders?status=open -> map[string]string{"status": "open"}
+//	config.Decoder = httpmw.Query()
+func Query() Decoder {
+	return Params()
+}
+
+// PathParams returns a Decoder that passes the named path wildcards of the
+// route, e.g. "id" in "GET /orders/{id}", as a map[string]string.
+//
+// Example:
+//
+//	mux.Handle("GET /orders/{id}", httpmw.HandlerWithConfig(chain, &httpmw.Config{
+//		Decoder: httpmw.PathParams("id"),
+//	}))
+func PathParams(names ...string) Decoder {
+	return func(r *http.Request) (any, error) {
+		params := make(map[string]string, len(names))
+		for _, name := range names {
+			params[name] = r.PathValue(name)
+		}
+
+		return params, nil
+	}
+}
+
+// Params returns a Decoder that passes the query parameters and the named
+// path wildcards as a single map[string]string. Path wildcards take precedence
+// over query parameters with the same name.
+//
+// Example:
+//
+//	// GET /orders/42?expand=items -> {"id": "42", "expand": "items"}
+//	config.Decoder = httpmw.Params("id")
+func Params(names ...string) Decoder {
+	return func(r *http.Request) (any, error) {
+		query := r.URL.Query()
+
+		params := make(map[string]string, len(query)+len(names))
+		for name, values := range query {
+			if len(values) > 0 {
+				params[name] = values[0]
+			}
+		}
+		for _, name := range names {
+			if value := r.PathValue(name); value != "" {
+				params[name] = value
+			}
+		}
+
+		return params, nil
+	}
+}
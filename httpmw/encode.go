@@ -0,0 +1,135 @@
+package httpmw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/raywall/go-middleware"
+)
+
+// Encoder writes the output of a chain as the HTTP response. Encoders should
+// not write anything before they know encoding succeeds, so that a failure can
+// still be reported by the ErrorEncoder.
+type Encoder func(w http.ResponseWriter, r *http.Request, output any) error
+
+// Response lets a chain control the status code and headers of the response.
+// Encoders write its Body in place of the output.
+//
+// Example:
+//
+//	return ctx, httpmw.Response{Status: http.StatusCreated, Body: order}, nil
+type Response struct {
+	Status int
+	Header http.Header
+	Body   any
+}
+
+// JSONEncoder returns an Encoder that writes the output as JSON with the given
+// status code. A nil output is answered with 204 No Content.
+func JSONEncoder(status int) Encoder {
+	return func(w http.ResponseWriter, r *http.Request, output any) error {
+		status, body := unwrapResponse(w, status, output)
+		if body == nil {
+			w.WriteHeader(noContent(status))
+			return nil
+		}
+
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+}
+
+// TextEncoder returns an Encoder that writes the output formatted with
+// fmt.Sprint as plain text with the given status code. A nil output is
+// answered with 204 No Content.
+func TextEncoder(status int) Encoder {
+	return func(w http.ResponseWriter, r *http.Request, output any) error {
+		status, body := unwrapResponse(w, status, output)
+		if body == nil {
+			w.WriteHeader(noContent(status))
+			return nil
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, err := fmt.Fprint(w, body)
+		return err
+	}
+}
+
+// NoContent returns an Encoder that discards the output and answers with 204
+// No Content.
+func NoContent() Encoder {
+	return func(w http.ResponseWriter, r *http.Request, output any) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// unwrapResponse applies the status and headers of a Response output and
+// returns the status and body to write.
+func unwrapResponse(w http.ResponseWriter, status int, output any) (int, any) {
+	response, ok := output.(Response)
+	if !ok {
+		if pointer, isPointer := output.(*Response); isPointer && pointer != nil {
+			response, ok = *pointer, true
+		}
+	}
+	if !ok {
+		return status, output
+	}
+
+	for name, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	if response.Status != 0 {
+		status = response.Status
+	}
+
+	return status, response.Body
+}
+
+// noContent returns the status of a response without body: 204 replaces 200.
+func noContent(status int) int {
+	if status == http.StatusOK {
+		return http.StatusNoContent
+	}
+
+	return status
+}
+
+// CloudEventEncoder returns an Encoder that writes a middleware.CloudEvent
+// output, e.g. produced by middleware.EncodeCloudEvent, in the given HTTP
+// mode with the given status code. Other outputs are written as JSON.
+//
+// Example:
+//
+//	config.Encoder = httpmw.CloudEventEncoder(http.StatusOK, middleware.CloudEventBinary)
+func CloudEventEncoder(status int, mode middleware.CloudEventMode) Encoder {
+	fallback := JSONEncoder(status)
+
+	return func(w http.ResponseWriter, r *http.Request, output any) error {
+		switch event := output.(type) {
+		case middleware.CloudEvent:
+			return event.WriteHTTP(w, status, mode)
+		case *middleware.CloudEvent:
+			if event != nil {
+				return event.WriteHTTP(w, status, mode)
+			}
+		}
+
+		return fallback(w, r, output)
+	}
+}
@@ -0,0 +1,98 @@
+package httpmw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/raywall/go-middleware"
+)
+
+// StatusClientClosedRequest is the non-standard status reported when the
+// client went away before the chain finished.
+const StatusClientClosedRequest = 499
+
+// ErrorEncoder writes a failure as the HTTP response.
+type ErrorEncoder func(w http.ResponseWriter, r *http.Request, err error)
+
+// StatusCoder is implemented by errors that choose their own status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ErrorBody is the JSON body written by JSONErrorEncoder.
+type ErrorBody struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// StatusCode maps the cause of a failure to an HTTP status code. The chain of
+// wrapped errors, including *middleware.ChainError, is inspected for:
+//   - errors implementing StatusCoder, which choose their own status
+//   - middleware.ErrValidation: 400 Bad Request
+//   - middleware.ErrUnauthenticated: 401 Unauthorized
+//   - middleware.ErrPermissionDenied: 403 Forbidden
+//   - middleware.ErrNotFound: 404 Not Found
+//   - middleware.ErrConflict: 409 Conflict
+//   - *http.MaxBytesError: 413 Request Entity Too Large
+//   - middleware.ErrRateLimited: 429 Too Many Requests
+//   - context.Canceled: 499 Client Closed Request
+//   - context.DeadlineExceeded: 504 Gateway Timeout
+//
+// Any other error, including recovered panics, is 500 Internal Server Error.
+func StatusCode(err error) int {
+	var coder StatusCoder
+	if errors.As(err, &coder) {
+		return coder.StatusCode()
+	}
+
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, middleware.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, middleware.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, middleware.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, middleware.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, middleware.ErrConflict):
+		return http.StatusConflict
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, middleware.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// JSONErrorEncoder writes failures as an ErrorBody with the status chosen by
+// StatusCode. Client errors (4xx) carry the message of the failing step;
+// server errors only carry the status text, so that internal details do not
+// leak to callers.
+func JSONErrorEncoder(w http.ResponseWriter, r *http.Request, err error) {
+	status := StatusCode(err)
+
+	body := ErrorBody{Status: status, Error: http.StatusText(status)}
+	if status >= 400 && status < 500 {
+		body.Error = middleware.ErrorMessage(err)
+	}
+	if body.Error == "" {
+		body.Error = "HTTP " + strconv.Itoa(status)
+	}
+	body.RequestID, _ = middleware.GetRequestID(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
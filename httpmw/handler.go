@@ -0,0 +1,181 @@
+// Package httpmw serves middleware chains over net/http.
+//
+// A Handler decodes the HTTP request into the chain input, runs the chain and
+// encodes its output as the response. Failures are mapped to status codes with
+// StatusCode, so steps can return the sentinel errors of the middleware
+// package, e.g. middleware.ErrNotFound:
+//
+//	chain := middleware.NewNamedChain("create-order",
+//		middleware.RequestID(),
+//		middleware.Observability(logger),
+//		createOrder,
+//	)
+//
+//	config := httpmw.DefaultConfig()
+//	config.Decoder = httpmw.JSON[CreateOrder]()
+//	http.Handle("POST /orders", httpmw.HandlerWithConfig(chain, config))
+//
+// Handlers are plain http.Handler values and can be tested with httptest.
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/raywall/go-middleware"
+)
+
+// DefaultRequestIDHeader is the header carrying the request ID.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// Config configures a Handler.
+type Config struct {
+	// Decoder converts the request into the chain input. When nil, the
+	// *http.Request itself is the input.
+	Decoder Decoder
+
+	// Encoder writes the chain output as the response. When nil, the output
+	// is encoded as JSON.
+	Encoder Encoder
+
+	// ErrorEncoder writes decoding and chain failures as the response. When
+	// nil, JSONErrorEncoder is used.
+	ErrorEncoder ErrorEncoder
+
+	// RequestIDHeader is copied into the request ID of the context, and the
+	// request ID is echoed in the response header of the same name. When
+	// empty, no header is read or written.
+	RequestIDHeader string
+
+	// MaxBodyBytes limits the size of request bodies. When zero, bodies are
+	// not limited.
+	MaxBodyBytes int64
+}
+
+// DefaultConfig returns the default Handler configuration: the request is the
+// chain input, the output is encoded as JSON, request IDs are read from and
+// written to X-Request-ID, and bodies are limited to 1 MiB.
+func DefaultConfig() *Config {
+	return &Config{
+		Decoder:         Request(),
+		Encoder:         JSONEncoder(http.StatusOK),
+		ErrorEncoder:    JSONErrorEncoder,
+		RequestIDHeader: DefaultRequestIDHeader,
+		MaxBodyBytes:    1 << 20,
+	}
+}
+
+// Handler serves a chain with the default configuration.
+//
+// Example:
+//
+//	http.Handle("/orders", httpmw.Handler(chain))
+func Handler(chain *middleware.Chain) http.Handler {
+	return HandlerWithConfig(chain, DefaultConfig())
+}
+
+// HandlerWithConfig serves a chain with a custom configuration. The chain is
+// compiled once, when the handler is created.
+//
+// For every request the handler:
+//   - forks the metadata of the request context
+//   - copies the request ID header into middleware.SetRequestID
+//   - stores the URL path under middleware.HTTPPathKey
+//   - decodes the input, runs the chain and encodes the output
+//   - maps failures to status codes through the ErrorEncoder
+//
+// Example:
+//
+//	config := httpmw.DefaultConfig()
+//	config.Decoder = httpmw.Params("id")
+//	config.Encoder = httpmw.JSONEncoder(http.StatusOK)
+//	mux.Handle("GET /orders/{id}", httpmw.HandlerWithConfig(chain, config))
+func HandlerWithConfig(chain *middleware.Chain, config *Config) http.Handler {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+
+	decoder := config.Decoder
+	if decoder == nil {
+		decoder = Request()
+	}
+
+	encoder := config.Encoder
+	if encoder == nil {
+		encoder = JSONEncoder(http.StatusOK)
+	}
+
+	errorEncoder := config.ErrorEncoder
+	if errorEncoder == nil {
+		errorEncoder = JSONErrorEncoder
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests may share metadata through the server's base context, so
+		// every request writes to its own copy
+		ctx := middleware.ForkMetadata(r.Context())
+
+		if config.RequestIDHeader != "" {
+			if requestID := r.Header.Get(config.RequestIDHeader); requestID != "" {
+				ctx = middleware.SetRequestID(ctx, requestID)
+			}
+		}
+		ctx = middleware.HTTPPathKey.Set(ctx, r.URL.Path)
+
+		if config.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodyBytes)
+		}
+		r = r.WithContext(context.WithValue(ctx, responseWriterKey{}, w))
+
+		input, err := decoder(r)
+		if err != nil {
+			writeRequestID(w, ctx, config.RequestIDHeader)
+			errorEncoder(w, r, err)
+			return
+		}
+
+		// Responses of chains adapting net/http middleware are written through
+		// the writers those middleware installed
+		exchange, isExchange := input.(*Exchange)
+		if isExchange {
+			defer exchange.Done()
+		}
+
+		ctx, output, err := compiled.Then(ctx, input)
+		if isExchange {
+			w = exchange.Current().W
+		}
+		writeRequestID(w, ctx, config.RequestIDHeader)
+		r = r.WithContext(ctx)
+
+		if err != nil {
+			if !errors.Is(err, ErrResponseWritten) {
+				errorEncoder(w, r, err)
+			}
+			return
+		}
+
+		// Chains returning an exchange wrote the response themselves
+		if _, ok := output.(*Exchange); ok {
+			return
+		}
+
+		if err := encoder(w, r, output); err != nil {
+			errorEncoder(w, r, err)
+		}
+	})
+}
+
+// writeRequestID echoes the request ID of the context in the response.
+func writeRequestID(w http.ResponseWriter, ctx context.Context, header string) {
+	if header == "" {
+		return
+	}
+
+	if requestID, ok := middleware.GetRequestID(ctx); ok && requestID != "" {
+		w.Header().Set(header, requestID)
+	}
+}
@@ -0,0 +1,221 @@
+package httpmw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+)
+
+type createOrder struct {
+	Product  string `json:"product"`
+	Quantity int    `json:"quantity"`
+}
+
+// passThrough is a step that forwards its input unchanged.
+func passThrough(ctx context.Context, input any) (context.Context, any, error) {
+	return ctx, input, nil
+}
+
+// decodeError decodes the ErrorBody of a failed response.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorBody {
+	t.Helper()
+
+	var body ErrorBody
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	return body
+}
+
+func TestHandlerDecodesAndEncodesJSON(t *testing.T) {
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		order := input.(createOrder)
+		return ctx, Response{
+			Status: http.StatusCreated,
+			Header: http.Header{"Location": {"/orders/1"}},
+			Body:   map[string]any{"product": order.Product, "quantity": order.Quantity},
+		}, nil
+	})
+
+	config := DefaultConfig()
+	config.Decoder = JSON[createOrder]()
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"product":"book","quantity":2}`))
+	req.Header.Set(DefaultRequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(chain, config).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if got := rec.Header().Get("Location"); got != "/orders/1" {
+		t.Errorf("Location = %q, want /orders/1", got)
+	}
+	if got := rec.Header().Get(DefaultRequestIDHeader); got != "req-1" {
+		t.Errorf("request ID header = %q, want req-1", got)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"product":"book","quantity":2}` {
+		t.Errorf("body = %s", got)
+	}
+}
+
+func TestHandlerCopiesRequestID(t *testing.T) {
+	var requestID string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		requestID, _ = middleware.GetRequestID(ctx)
+		return ctx, nil, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(DefaultRequestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	Handler(chain).ServeHTTP(rec, req)
+
+	if requestID != "req-42" {
+		t.Errorf("request ID in chain = %q, want req-42", requestID)
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d for a nil output", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestHandlerRejectsInvalidJSON(t *testing.T) {
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		t.Error("chain ran for an invalid body")
+		return ctx, input, nil
+	})
+
+	config := DefaultConfig()
+	config.Decoder = JSON[createOrder]()
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"product":"book","color":"red"}`))
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(chain, config).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if body := decodeError(t, rec); !strings.Contains(body.Error, "invalid JSON body") {
+		t.Errorf("error = %q, want the decoding failure", body.Error)
+	}
+}
+
+func TestHandlerLimitsBodySize(t *testing.T) {
+	config := DefaultConfig()
+	config.Decoder = JSON[createOrder]()
+	config.MaxBodyBytes = 8
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"product":"a long product name"}`))
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(middleware.NewChain(), config).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestHandlerMapsChainErrors(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		message string
+	}{
+		{fmt.Errorf("%w: order 7", middleware.ErrNotFound), http.StatusNotFound, "not found: order 7"},
+		{fmt.Errorf("%w: missing product", middleware.ErrValidation), http.StatusBadRequest, "validation failed: missing product"},
+		{middleware.ErrConflict, http.StatusConflict, middleware.ErrConflict.Error()},
+		{&StatusError{Status: http.StatusTeapot, Err: errors.New("short and stout")}, http.StatusTeapot, "short and stout"},
+		{errors.New("database password is hunter2"), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			chain := middleware.NewChain(
+				passThrough,
+				func(ctx context.Context, input any) (context.Context, any, error) {
+					return ctx, nil, tt.err
+				},
+			)
+
+			rec := httptest.NewRecorder()
+			Handler(chain).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			// Client errors carry the step's message without the chain position
+			if body := decodeError(t, rec); body.Error != tt.message || body.Status != tt.status {
+				t.Errorf("body = %+v, want error %q and status %d", body, tt.message, tt.status)
+			}
+		})
+	}
+}
+
+func TestHandlerDecodesParams(t *testing.T) {
+	var params map[string]string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		params = input.(map[string]string)
+		return ctx, nil, nil
+	})
+
+	config := DefaultConfig()
+	config.Decoder = Params("id")
+	mux := http.NewServeMux()
+	mux.Handle("GET /orders/{id}", HandlerWithConfig(chain, config))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders/42?expand=items&id=7", nil))
+
+	if params["id"] != "42" || params["expand"] != "items" {
+		t.Errorf("params = %v, want id 42 and expand items", params)
+	}
+}
+
+func TestHandlerIsolatesSharedBaseMetadata(t *testing.T) {
+	// A server's BaseContext may carry a metadata container shared by all requests
+	base := middleware.WithMetadata(context.Background(), middleware.NewMetadata())
+	middleware.AddMetadata(base, "service", "orders")
+
+	release := make(chan struct{})
+	var entered sync.WaitGroup
+	entered.Add(2)
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		entered.Done()
+		<-release
+		requestID, _ := middleware.GetRequestID(ctx)
+		path, _ := middleware.HTTPPathKey.Get(ctx)
+		service, _ := middleware.GetMetadata(ctx, "service")
+		return ctx, map[string]any{"request_id": requestID, "path": path, "service": service}, nil
+	})
+	handler := Handler(chain)
+
+	recorders := make([]*httptest.ResponseRecorder, 2)
+	var done sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/orders/%d", i), nil).WithContext(base)
+		req.Header.Set(DefaultRequestIDHeader, fmt.Sprintf("req-%d", i))
+
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(recorders[i], req)
+		}()
+	}
+	// Both requests have written their metadata before either reads it
+	entered.Wait()
+	close(release)
+	done.Wait()
+
+	for i, rec := range recorders {
+		want := fmt.Sprintf(`{"path":"/orders/%d","request_id":"req-%d","service":"orders"}`, i, i)
+		if got := strings.TrimSpace(rec.Body.String()); got != want {
+			t.Errorf("request %d: body = %s, want %s", i, got, want)
+		}
+	}
+	if _, leaked := middleware.GetRequestID(base); leaked {
+		t.Error("request ID written to the base context")
+	}
+}
@@ -0,0 +1,327 @@
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/raywall/go-middleware"
+)
+
+// RouteKey holds the pattern of the route serving the request, e.g.
+// "GET /orders/{id}". Router sets it before running the route's chain.
+var RouteKey = middleware.NewKey[string]("http.route")
+
+// pathParamPrefix prefixes the metadata keys of path parameters.
+const pathParamPrefix = "http.path_param."
+
+// PathParam returns a path parameter of the route serving the request, e.g.
+// "id" in "GET /orders/{id}". Router stores path parameters in the metadata,
+// so they are available to every step without the *http.Request.
+//
+// Example:
+//
+//	func loadOrder(ctx context.Context, input any) (context.Context, any, error) {
+//		id, _ := httpmw.PathParam(ctx, "id")
+//		...
+//	}
+func PathParam(ctx context.Context, name string) (string, bool) {
+	return middleware.GetMetadataString(ctx, pathParamPrefix+name)
+}
+
+// StatusError is an error carrying an HTTP status code.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode implements StatusCoder.
+func (e *StatusError) StatusCode() int {
+	return e.Status
+}
+
+// Route describes a route registered on a Router.
+type Route struct {
+	// Method is the HTTP method, empty for routes matching any method
+	Method string
+
+	// Pattern is the full path pattern, including group prefixes
+	Pattern string
+
+	// Chain is the name of the route's chain, empty for unnamed chains
+	Chain string
+}
+
+// Router dispatches requests to chains by method and path, using the patterns
+// of http.ServeMux, e.g. "/orders/{id}" or "/files/{path...}". Path parameters
+// are stored in the metadata, where PathParam reads them.
+//
+// Routes can be organized in groups sharing a path prefix and leading steps,
+// such as authentication. Requests matching no route are served by the
+// NotFound chain, and requests matching a route with another method by the
+// MethodNotAllowed chain.
+//
+// Example:
+//
+//	router := httpmw.NewRouter(nil)
+//	router.Get("/health", healthChain)
+//
+//	api := router.Group("/api", middleware.RequestID(), authenticate)
+//	api.Get("/orders/{id}", getOrder)
+//	api.Post("/orders", createOrder)
+//
+//	http.ListenAndServe(":8080", router)
+type Router struct {
+	shared *routerShared
+	config *Config
+	prefix string
+	steps  []middleware.MiddlewareFunc
+}
+
+// routerShared is the state shared by a router and its groups.
+type routerShared struct {
+	mux *http.ServeMux
+
+	mu               sync.RWMutex
+	routes           []Route
+	methods          map[string]bool
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+}
+
+// NewRouter creates a Router whose routes are served with the given handler
+// configuration. When config is nil, DefaultConfig is used.
+func NewRouter(config *Config) *Router {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	router := &Router{
+		shared: &routerShared{
+			mux:     http.NewServeMux(),
+			methods: map[string]bool{},
+		},
+		config: config,
+	}
+
+	router.NotFound(middleware.NewNamedChain("not-found", func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, middleware.ErrNotFound
+	}))
+	router.MethodNotAllowed(middleware.NewNamedChain("method-not-allowed", func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, &StatusError{Status: http.StatusMethodNotAllowed, Err: errors.New("method not allowed")}
+	}))
+
+	return router
+}
+
+// Group returns a router registering routes under a path prefix, with the
+// given steps prepended to their chains. Groups can be nested; the steps of
+// outer groups run first.
+//
+// Example:
+//
+//	admin := router.Group("/admin", authenticate, requireAdmin)
+//	admin.Delete("/users/{id}", deleteUser)
+func (rt *Router) Group(prefix string, steps ...middleware.MiddlewareFunc) *Router {
+	return &Router{
+		shared: rt.shared,
+		config: rt.config,
+		prefix: joinPath(rt.prefix, prefix),
+		steps:  append(append([]middleware.MiddlewareFunc(nil), rt.steps...), steps...),
+	}
+}
+
+// Handle registers a chain for a method and path pattern. An empty method
+// matches any method. Like http.ServeMux.Handle, it panics if the pattern is
+// invalid or conflicts with a registered one.
+func (rt *Router) Handle(method, pattern string, chain *middleware.Chain) {
+	rt.HandleWithConfig(method, pattern, chain, rt.config)
+}
+
+// HandleWithConfig registers a chain with its own handler configuration, e.g.
+// a different Decoder.
+//
+// Example:
+//
+//	config := httpmw.DefaultConfig()
+//	config.Decoder = httpmw.JSON[CreateOrder]()
+//	router.HandleWithConfig(http.MethodPost, "/orders", createOrder, config)
+func (rt *Router) HandleWithConfig(method, pattern string, chain *middleware.Chain, config *Config) {
+	path := joinPath(rt.prefix, pattern)
+	if len(rt.steps) > 0 {
+		chain = chain.Prepend(rt.steps...)
+	}
+
+	route := path
+	if method != "" {
+		route = method + " " + path
+	}
+
+	params := pathParams(path)
+	handler := HandlerWithConfig(chain, config)
+
+	rt.shared.mux.Handle(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := RouteKey.Set(middleware.ForkMetadata(r.Context()), route)
+		for _, name := range params {
+			ctx = middleware.AddMetadata(ctx, pathParamPrefix+name, r.PathValue(name))
+		}
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}))
+
+	rt.shared.mu.Lock()
+	defer rt.shared.mu.Unlock()
+
+	rt.shared.routes = append(rt.shared.routes, Route{Method: method, Pattern: path, Chain: chain.Name()})
+	if method != "" {
+		rt.shared.methods[method] = true
+	}
+}
+
+// Get registers a chain for GET requests. GET routes also match HEAD requests.
+func (rt *Router) Get(pattern string, chain *middleware.Chain) {
+	rt.Handle(http.MethodGet, pattern, chain)
+}
+
+// Post registers a chain for POST requests.
+func (rt *Router) Post(pattern string, chain *middleware.Chain) {
+	rt.Handle(http.MethodPost, pattern, chain)
+}
+
+// Put registers a chain for PUT requests.
+func (rt *Router) Put(pattern string, chain *middleware.Chain) {
+	rt.Handle(http.MethodPut, pattern, chain)
+}
+
+// Patch registers a chain for PATCH requests.
+func (rt *Router) Patch(pattern string, chain *middleware.Chain) {
+	rt.Handle(http.MethodPatch, pattern, chain)
+}
+
+// Delete registers a chain for DELETE requests.
+func (rt *Router) Delete(pattern string, chain *middleware.Chain) {
+	rt.Handle(http.MethodDelete, pattern, chain)
+}
+
+// NotFound sets the chain serving requests that match no route. By default
+// they are answered with 404 Not Found.
+func (rt *Router) NotFound(chain *middleware.Chain) {
+	handler := HandlerWithConfig(chain, rt.config)
+
+	rt.shared.mu.Lock()
+	defer rt.shared.mu.Unlock()
+
+	rt.shared.notFound = handler
+}
+
+// MethodNotAllowed sets the chain serving requests that match a route with a
+// different method. The Allow header is set before the chain runs. By default
+// they are answered with 405 Method Not Allowed.
+func (rt *Router) MethodNotAllowed(chain *middleware.Chain) {
+	handler := HandlerWithConfig(chain, rt.config)
+
+	rt.shared.mu.Lock()
+	defer rt.shared.mu.Unlock()
+
+	rt.shared.methodNotAllowed = handler
+}
+
+// Routes returns the registered routes, sorted by pattern and method.
+func (rt *Router) Routes() []Route {
+	rt.shared.mu.RLock()
+	defer rt.shared.mu.RUnlock()
+
+	routes := append([]Route(nil), rt.shared.routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// ServeHTTP dispatches the request to the chain of the matching route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.shared.mux.Handler(r); pattern != "" {
+		rt.shared.mux.ServeHTTP(w, r)
+		return
+	}
+
+	rt.shared.mu.RLock()
+	notFound, methodNotAllowed := rt.shared.notFound, rt.shared.methodNotAllowed
+	rt.shared.mu.RUnlock()
+
+	if allowed := rt.allowedMethods(r); len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		methodNotAllowed.ServeHTTP(w, r)
+		return
+	}
+
+	notFound.ServeHTTP(w, r)
+}
+
+// allowedMethods returns the methods of the routes matching the request's
+// path, sorted.
+func (rt *Router) allowedMethods(r *http.Request) []string {
+	rt.shared.mu.RLock()
+	methods := make([]string, 0, len(rt.shared.methods))
+	for method := range rt.shared.methods {
+		methods = append(methods, method)
+	}
+	hasHead := rt.shared.methods[http.MethodHead]
+	rt.shared.mu.RUnlock()
+
+	var allowed []string
+	for _, method := range methods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := rt.shared.mux.Handler(probe); pattern != "" {
+			allowed = append(allowed, method)
+
+			// GET routes also serve HEAD requests
+			if method == http.MethodGet && !hasHead {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+
+	sort.Strings(allowed)
+	return allowed
+}
+
+// wildcardPattern matches the wildcards of a path pattern, e.g. "{id}" or
+// "{path...}", but not the end anchor "{$}".
+var wildcardPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\.\.\.)?\}`)
+
+// pathParams returns the wildcard names of a path pattern.
+func pathParams(pattern string) []string {
+	var names []string
+	for _, match := range wildcardPattern.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, match[1])
+	}
+
+	return names
+}
+
+// joinPath joins a group prefix and a pattern, avoiding duplicate slashes.
+func joinPath(prefix, pattern string) string {
+	if prefix == "" {
+		return pattern
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(pattern, "/")
+}
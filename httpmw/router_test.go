@@ -0,0 +1,104 @@
+package httpmw
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+)
+
+// echoParam is a chain answering with the "id" path parameter.
+func echoParam() *middleware.Chain {
+	return middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		id, _ := PathParam(ctx, "id")
+		return ctx, id, nil
+	})
+}
+
+func TestRouterServesRoutesAndGroups(t *testing.T) {
+	router := NewRouter(nil)
+	api := router.Group("/api")
+	api.Get("/orders/{id}", echoParam())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders/42", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "\"42\"\n" {
+		t.Errorf("GET: status %d body %q, want 200 and \"42\"", rec.Code, rec.Body)
+	}
+}
+
+func TestRouterAnswersMethodNotAllowed(t *testing.T) {
+	router := NewRouter(nil)
+	router.Get("/orders/{id}", echoParam())
+	router.Delete("/orders/{id}", echoParam())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders/42", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != "DELETE, GET, HEAD" {
+		t.Errorf("Allow = %q, want %q", got, "DELETE, GET, HEAD")
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/customers", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouterRegistersWhileServing(t *testing.T) {
+	router := NewRouter(nil)
+	router.Get("/orders/{id}", echoParam())
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders/1", nil))
+			}
+		}
+	}()
+
+	for i := range 1000 {
+		router.Handle(http.MethodHead, fmt.Sprintf("/items/%d", i), echoParam())
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRouterIsolatesPathParams(t *testing.T) {
+	base := middleware.WithMetadata(context.Background(), middleware.NewMetadata())
+	router := NewRouter(nil)
+	router.Get("/orders/{id}", echoParam())
+	router.Get("/customers/{name}", echoParam())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders/42", nil).WithContext(base))
+	if rec.Body.String() != "\"42\"\n" {
+		t.Fatalf("body = %q, want \"42\"", rec.Body)
+	}
+
+	// The parameter of the first request is not visible to the next one
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/customers/ada", nil).WithContext(base))
+	if rec.Body.String() != "\"\"\n" {
+		t.Errorf("body = %q, want no id", rec.Body)
+	}
+	if route, ok := RouteKey.Get(base); ok {
+		t.Errorf("route %q written to the base context", route)
+	}
+}
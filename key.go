@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+)
+
+// Key is a typed context key backed by the execution's metadata container.
+// Values set through a Key are stored under the key's name, so they are listed
+// by AllMetadata and can be exported to logs and traces like any other
+// metadata, while Get returns them with their static type.
+//
+// Key names share the metadata namespace, so choose names that do not clash
+// with plain AddMetadata keys of a different type.
+//
+// Example:
+//
+//	var TenantKey = middleware.NewKey[string]("tenant_id")
+//
+//	ctx = TenantKey.Set(ctx, "acme")
+//	tenant, ok := TenantKey.Get(ctx)
+type Key[T any] struct {
+	name       string
+	def        T
+	hasDefault bool
+}
+
+// NewKey creates a typed key with the given metadata name.
+//
+// Example:
+//
+//	var AttemptKey = middleware.NewKey[int]("attempt")
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// NewKeyWithDefault creates a typed key that yields def when no value is set.
+//
+// Example:
+//
+//	var RegionKey = middleware.NewKeyWithDefault("region", "us-east-1")
+//	region := RegionKey.MustGet(ctx) // "us-east-1" unless set
+func NewKeyWithDefault[T any](name string, def T) Key[T] {
+	return Key[T]{name: name, def: def, hasDefault: true}
+}
+
+// Name returns the metadata name of the key.
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Set stores a value for the key in the context's metadata container.
+// Like AddMetadata, it returns the context to use for subsequent calls.
+func (k Key[T]) Set(ctx context.Context, value T) context.Context {
+	ctx, md := ensureMetadata(ctx)
+	md.Set(k.name, value)
+	return ctx
+}
+
+// Get retrieves the value of the key from the context. It returns the value
+// and a boolean indicating whether a value of type T was set. When no value is
+// set, the key's default value (or the zero value of T) is returned.
+func (k Key[T]) Get(ctx context.Context) (T, bool) {
+	if md := MetadataFrom(ctx); md != nil {
+		if value, ok := md.Get(k.name); ok {
+			if typed, ok := value.(T); ok {
+				return typed, true
+			}
+		}
+	}
+
+	return k.def, false
+}
+
+// MustGet retrieves the value of the key from the context. If no value is set
+// it returns the key's default value, and panics if the key has no default.
+func (k Key[T]) MustGet(ctx context.Context) T {
+	value, ok := k.Get(ctx)
+	if !ok && !k.hasDefault {
+		panic(fmt.Sprintf("middleware: no value set for key %q", k.name))
+	}
+
+	return value
+}
+
+// Delete removes the value of the key from the context's metadata container.
+func (k Key[T]) Delete(ctx context.Context) {
+	DeleteMetadata(ctx, k.name)
+}
@@ -0,0 +1,134 @@
+package lambdamw
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/raywall/go-middleware"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SQSMessageIDKey holds the ID of the SQS message being processed.
+var SQSMessageIDKey = middleware.NewKey[string]("aws.sqs.message_id")
+
+// SQSConfig configures an SQSHandler.
+type SQSConfig struct {
+	// Concurrency is the number of messages of a standard queue batch
+	// processed in parallel. Messages of FIFO queues are always processed in
+	// order. When zero, messages are processed one at a time.
+	Concurrency int
+
+	// Logger reports failed messages. When nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// DefaultSQSConfig returns the default SQSHandler configuration.
+func DefaultSQSConfig() *SQSConfig {
+	return &SQSConfig{
+		Concurrency: 1,
+		Logger:      slog.Default(),
+	}
+}
+
+// SQSHandler returns a lambda.Start compatible handler running the chain once
+// per message of an SQS batch, with the events.SQSMessage as input. Failed
+// messages are reported in an events.SQSEventResponse, so that only they are
+// retried; the event source mapping must enable ReportBatchItemFailures.
+//
+// Every message runs with its own copy of the invocation metadata and with
+// SQSMessageIDKey set. For FIFO queues, once a message fails, the remaining
+// messages of the batch are reported as failed without being processed, to
+// preserve their order.
+//
+// Example:
+//
+//	chain := middleware.NewNamedChain("order-events", decodeOrderEvent, applyOrderEvent)
+//	lambda.Start(lambdamw.SQSHandler(chain, nil))
+func SQSHandler(chain *middleware.Chain, config *SQSConfig) HandlerFunc {
+	if config == nil {
+		config = DefaultSQSConfig()
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	concurrency := max(config.Concurrency, 1)
+	compiled := chain.Compile()
+
+	return func(ctx context.Context, event json.RawMessage) (any, error) {
+		ctx = invocationContext(ctx)
+
+		batch, err := unmarshal[events.SQSEvent](event)
+		if err != nil {
+			return nil, err
+		}
+
+		process := func(message events.SQSMessage) bool {
+			msgCtx := SQSMessageIDKey.Set(middleware.ForkMetadata(ctx), message.MessageId)
+			if _, _, err := compiled.Then(msgCtx, message); err != nil {
+				logger.ErrorContext(msgCtx, "SQS message failed",
+					slog.String("message_id", message.MessageId),
+					slog.String("error", err.Error()),
+				)
+				return false
+			}
+			return true
+		}
+
+		failed := make([]bool, len(batch.Records))
+
+		if concurrency == 1 || isFIFO(batch) {
+			for i, message := range batch.Records {
+				if !process(message) {
+					failed[i] = true
+					if isFIFO(batch) {
+						// Later messages must not be processed before this one
+						for j := i + 1; j < len(batch.Records); j++ {
+							failed[j] = true
+						}
+						break
+					}
+				}
+			}
+		} else {
+			var wg sync.WaitGroup
+			slots := make(chan struct{}, concurrency)
+			for i, message := range batch.Records {
+				wg.Add(1)
+				slots <- struct{}{}
+				go func() {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+					failed[i] = !process(message)
+				}()
+			}
+			wg.Wait()
+		}
+
+		response := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+		for i, message := range batch.Records {
+			if failed[i] {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: message.MessageId})
+			}
+		}
+
+		if len(response.BatchItemFailures) == len(batch.Records) && len(batch.Records) > 0 {
+			logger.WarnContext(ctx, "All SQS messages of the batch failed", slog.Int("messages", len(batch.Records)))
+		}
+
+		return response, nil
+	}
+}
+
+// isFIFO reports whether a batch comes from a FIFO queue.
+func isFIFO(batch events.SQSEvent) bool {
+	return len(batch.Records) > 0 && strings.HasSuffix(batch.Records[0].EventSourceARN, ".fifo")
+}
@@ -0,0 +1,105 @@
+package lambdamw
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// failMessages returns a chain failing the given message IDs and recording
+// the IDs of the processed messages.
+func failMessages(processed *[]string, failing ...string) *middleware.Chain {
+	var mu sync.Mutex
+	return middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		message := input.(events.SQSMessage)
+		if id, _ := SQSMessageIDKey.Get(ctx); id != message.MessageId {
+			return ctx, nil, errors.New("message ID not set")
+		}
+
+		mu.Lock()
+		*processed = append(*processed, message.MessageId)
+		mu.Unlock()
+
+		if slices.Contains(failing, message.MessageId) {
+			return ctx, nil, errors.New("failed")
+		}
+		return ctx, nil, nil
+	})
+}
+
+// failures returns the item identifiers of a batch response.
+func failures(t *testing.T, output any) []string {
+	t.Helper()
+
+	var ids []string
+	for _, failure := range output.(events.SQSEventResponse).BatchItemFailures {
+		ids = append(ids, failure.ItemIdentifier)
+	}
+	return ids
+}
+
+func quietSQSConfig(concurrency int) *SQSConfig {
+	return &SQSConfig{Concurrency: concurrency, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func TestSQSHandlerReportsFailedMessages(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		var processed []string
+		handler := SQSHandler(failMessages(&processed, "msg-2"), quietSQSConfig(concurrency))
+
+		output, err := handler(invocation("aws-req-1"), fixture(t, "sqs.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := failures(t, output); !slices.Equal(got, []string{"msg-2"}) {
+			t.Errorf("concurrency %d: failures = %v, want [msg-2]", concurrency, got)
+		}
+		if len(processed) != 3 {
+			t.Errorf("concurrency %d: processed %v, want every message", concurrency, processed)
+		}
+	}
+}
+
+func TestSQSHandlerStopsFIFOBatchAtFailure(t *testing.T) {
+	var processed []string
+	handler := SQSHandler(failMessages(&processed, "msg-2"), quietSQSConfig(3))
+
+	output, err := handler(invocation("aws-req-1"), fixture(t, "sqs-fifo.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := failures(t, output); !slices.Equal(got, []string{"msg-2", "msg-3"}) {
+		t.Errorf("failures = %v, want [msg-2 msg-3]", got)
+	}
+	if !slices.Equal(processed, []string{"msg-1", "msg-2"}) {
+		t.Errorf("processed %v, want [msg-1 msg-2] in order", processed)
+	}
+}
+
+func TestSQSHandlerIsolatesMessageMetadata(t *testing.T) {
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		if _, leaked := middleware.GetMetadata(ctx, "handled"); leaked {
+			return ctx, nil, errors.New("metadata of another message is visible")
+		}
+		return middleware.AddMetadata(ctx, "handled", true), nil, nil
+	})
+
+	output, err := SQSHandler(chain, quietSQSConfig(1))(invocation("aws-req-1"), fixture(t, "sqs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := failures(t, output); len(got) != 0 {
+		t.Errorf("failures = %v, want none", got)
+	}
+}
@@ -0,0 +1,96 @@
+package lambdamw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/raywall/go-middleware"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Decoder converts a raw Lambda event into the input of a chain. It may
+// enrich the context, e.g. with the HTTP path of API Gateway events.
+// Decoding errors wrap middleware.ErrValidation.
+type Decoder func(ctx context.Context, event json.RawMessage) (context.Context, any, error)
+
+// Raw returns a Decoder that passes the raw event JSON as a json.RawMessage.
+func Raw() Decoder {
+	return func(ctx context.Context, event json.RawMessage) (context.Context, any, error) {
+		return ctx, event, nil
+	}
+}
+
+// Event returns a Decoder that unmarshals the event into a value of type T,
+// for event types without a dedicated decoder.
+//
+// Example:
+//
+//	config.Decoder = lambdamw.Event[events.DynamoDBEvent]()
+func Event[T any]() Decoder {
+	return func(ctx context.Context, event json.RawMessage) (context.Context, any, error) {
+		value, err := unmarshal[T](event)
+		return ctx, value, err
+	}
+}
+
+// APIGatewayProxy returns a Decoder for API Gateway REST API (v1) and ALB
+// style proxy events, passing an events.APIGatewayProxyRequest and setting
+// middleware.HTTPPathKey.
+func APIGatewayProxy() Decoder {
+	return func(ctx context.Context, event json.RawMessage) (context.Context, any, error) {
+		request, err := unmarshal[events.APIGatewayProxyRequest](event)
+		if err != nil {
+			return ctx, nil, err
+		}
+
+		return middleware.HTTPPathKey.Set(ctx, request.Path), request, nil
+	}
+}
+
+// APIGatewayV2 returns a Decoder for API Gateway HTTP API (v2) and Lambda
+// function URL events, passing an events.APIGatewayV2HTTPRequest and setting
+// middleware.HTTPPathKey.
+func APIGatewayV2() Decoder {
+	return func(ctx context.Context, event json.RawMessage) (context.Context, any, error) {
+		request, err := unmarshal[events.APIGatewayV2HTTPRequest](event)
+		if err != nil {
+			return ctx, nil, err
+		}
+
+		return middleware.HTTPPathKey.Set(ctx, request.RawPath), request, nil
+	}
+}
+
+// SQS returns a Decoder passing the whole batch as an events.SQSEvent. Use
+// SQSHandler to run the chain once per message with partial batch failures.
+func SQS() Decoder {
+	return Event[events.SQSEvent]()
+}
+
+// SNS returns a Decoder passing an events.SNSEvent.
+func SNS() Decoder {
+	return Event[events.SNSEvent]()
+}
+
+// EventBridge returns a Decoder passing an events.EventBridgeEvent, whose
+// Detail holds the raw event detail.
+func EventBridge() Decoder {
+	return Event[events.EventBridgeEvent]()
+}
+
+// S3 returns a Decoder passing an events.S3Event.
+func S3() Decoder {
+	return Event[events.S3Event]()
+}
+
+// unmarshal decodes an event into a value of type T.
+func unmarshal[T any](event json.RawMessage) (T, error) {
+	var value T
+	if err := json.Unmarshal(event, &value); err != nil {
+		return value, fmt.Errorf("%w: invalid %T event: %w", middleware.ErrValidation, value, err)
+	}
+
+	return value, nil
+}
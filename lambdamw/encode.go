@@ -0,0 +1,118 @@
+package lambdamw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/raywall/go-middleware"
+	"github.com/raywall/go-middleware/httpmw"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Encoder converts the output or failure of a chain into the Lambda response.
+// Returning an error fails the invocation.
+type Encoder func(ctx context.Context, output any, err error) (any, error)
+
+// Output returns an Encoder that returns the chain output as the response and
+// fails the invocation when the chain fails.
+func Output() Encoder {
+	return func(ctx context.Context, output any, err error) (any, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		return output, nil
+	}
+}
+
+// APIGatewayProxyResponse returns an Encoder for API Gateway REST API (v1)
+// proxy integrations. Outputs are encoded as JSON with status 200, or as
+// described by an httpmw.Response or *httpmw.Response. Failures become error
+// responses with the status chosen by httpmw.StatusCode, so the invocation
+// itself succeeds. Outputs that already are events.APIGatewayProxyResponse
+// are returned as they are.
+func APIGatewayProxyResponse() Encoder {
+	return func(ctx context.Context, output any, err error) (any, error) {
+		if response, ok := output.(events.APIGatewayProxyResponse); ok && err == nil {
+			return response, nil
+		}
+
+		status, headers, body, encodeErr := httpResponse(ctx, output, err)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+
+		return events.APIGatewayProxyResponse{StatusCode: status, Headers: headers, Body: body}, nil
+	}
+}
+
+// APIGatewayV2Response returns an Encoder for API Gateway HTTP API (v2) and
+// Lambda function URL integrations, with the same rules as
+// APIGatewayProxyResponse.
+func APIGatewayV2Response() Encoder {
+	return func(ctx context.Context, output any, err error) (any, error) {
+		if response, ok := output.(events.APIGatewayV2HTTPResponse); ok && err == nil {
+			return response, nil
+		}
+
+		status, headers, body, encodeErr := httpResponse(ctx, output, err)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+
+		return events.APIGatewayV2HTTPResponse{StatusCode: status, Headers: headers, Body: body}, nil
+	}
+}
+
+// httpResponse builds the status, headers and JSON body of an HTTP response.
+func httpResponse(ctx context.Context, output any, err error) (int, map[string]string, string, error) {
+	headers := map[string]string{"Content-Type": "application/json"}
+	requestID, _ := middleware.GetRequestID(ctx)
+	if requestID != "" {
+		headers[httpmw.DefaultRequestIDHeader] = requestID
+	}
+
+	if err != nil {
+		status := httpmw.StatusCode(err)
+		message := http.StatusText(status)
+		if status >= 400 && status < 500 {
+			message = middleware.ErrorMessage(err)
+		}
+
+		body, _ := json.Marshal(httpmw.ErrorBody{Error: message, Status: status, RequestID: requestID})
+		return status, headers, string(body), nil
+	}
+
+	status := http.StatusOK
+	body := output
+	response, ok := output.(httpmw.Response)
+	if pointer, isPointer := output.(*httpmw.Response); isPointer && pointer != nil {
+		response, ok = *pointer, true
+	}
+	if ok {
+		if response.Status != 0 {
+			status = response.Status
+		}
+		for name := range response.Header {
+			headers[name] = response.Header.Get(name)
+		}
+		body = response.Body
+	}
+
+	if body == nil {
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		return status, headers, "", nil
+	}
+
+	data, marshalErr := json.Marshal(body)
+	if marshalErr != nil {
+		return 0, nil, "", fmt.Errorf("failed to encode response: %w", marshalErr)
+	}
+
+	return status, headers, string(data), nil
+}
@@ -0,0 +1,121 @@
+// Package lambdamw runs middleware chains as AWS Lambda handlers.
+//
+// A Handler decodes the raw event into the chain input with a typed Decoder,
+// runs the chain and encodes its output as the Lambda response. The AWS
+// request ID of the invocation becomes the request ID of the chain:
+//
+//	chain := middleware.NewNamedChain("orders-api",
+//		middleware.Observability(logger),
+//		handleOrder,
+//	)
+//
+//	lambda.Start(lambdamw.Handler(chain, &lambdamw.Config{
+//		Decoder: lambdamw.APIGatewayV2(),
+//		Encoder: lambdamw.APIGatewayV2Response(),
+//	}))
+//
+// SQS batches are processed message by message with SQSHandler, reporting
+// partial batch failures. Handlers take the raw event JSON, so they can be
+// invoked directly with recorded events, without AWS access.
+package lambdamw
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/raywall/go-middleware"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// FunctionARNKey holds the ARN of the invoked function, including the alias
+// or version used to invoke it.
+var FunctionARNKey = middleware.NewKey[string]("aws.lambda.function_arn")
+
+// HandlerFunc is a handler accepted by lambda.Start.
+type HandlerFunc func(ctx context.Context, event json.RawMessage) (any, error)
+
+// Config configures a Handler.
+type Config struct {
+	// Decoder converts the raw event into the chain input. When nil, the raw
+	// event is the input.
+	Decoder Decoder
+
+	// Encoder converts the chain output or failure into the Lambda response.
+	// When nil, the output is returned as it is and failures fail the
+	// invocation.
+	Encoder Encoder
+}
+
+// DefaultConfig returns the default Handler configuration: the raw event is
+// the chain input and the output is the response.
+func DefaultConfig() *Config {
+	return &Config{
+		Decoder: Raw(),
+		Encoder: Output(),
+	}
+}
+
+// Handler returns a lambda.Start compatible handler running the chain once per
+// invocation. When config is nil, DefaultConfig is used.
+//
+// Before the chain runs, the AWS request ID of the invocation is set with
+// middleware.SetRequestID and the function ARN with FunctionARNKey. Decoders
+// of HTTP events also set middleware.HTTPPathKey, so health checks can be
+// matched by path.
+//
+// Example:
+//
+//	lambda.Start(lambdamw.Handler(chain, &lambdamw.Config{
+//		Decoder: lambdamw.EventBridge(),
+//	}))
+func Handler(chain *middleware.Chain, config *Config) HandlerFunc {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	compiled := chain.Compile()
+
+	decoder := config.Decoder
+	if decoder == nil {
+		decoder = Raw()
+	}
+
+	encoder := config.Encoder
+	if encoder == nil {
+		encoder = Output()
+	}
+
+	return func(ctx context.Context, event json.RawMessage) (any, error) {
+		ctx = invocationContext(ctx)
+
+		ctx, input, err := decoder(ctx, event)
+		if err != nil {
+			return encoder(ctx, nil, err)
+		}
+
+		ctx, output, err := compiled.Then(ctx, input)
+		return encoder(ctx, output, err)
+	}
+}
+
+// invocationContext forks the metadata of the context, so warm invocations
+// sharing a base context do not see each other's values, and copies the
+// fields of the Lambda context into the request ID and the metadata.
+func invocationContext(ctx context.Context) context.Context {
+	ctx = middleware.ForkMetadata(ctx)
+
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	if lc.AwsRequestID != "" {
+		ctx = middleware.SetRequestID(ctx, lc.AwsRequestID)
+	}
+	if lc.InvokedFunctionArn != "" {
+		ctx = FunctionARNKey.Set(ctx, lc.InvokedFunctionArn)
+	}
+
+	return ctx
+}
@@ -0,0 +1,233 @@
+package lambdamw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+	"github.com/raywall/go-middleware/httpmw"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// fixture reads a recorded event from testdata.
+func fixture(t *testing.T, name string) json.RawMessage {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// invocation returns a context as passed by the Lambda runtime.
+func invocation(requestID string) context.Context {
+	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		AwsRequestID:       requestID,
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:orders:live",
+	})
+}
+
+func TestHandlerAPIGatewayProxy(t *testing.T) {
+	var path, arn string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		request := input.(events.APIGatewayProxyRequest)
+		path, _ = middleware.HTTPPathKey.Get(ctx)
+		arn, _ = FunctionARNKey.Get(ctx)
+		return ctx, map[string]string{"id": request.PathParameters["id"]}, nil
+	})
+
+	handler := Handler(chain, &Config{Decoder: APIGatewayProxy(), Encoder: APIGatewayProxyResponse()})
+	output, err := handler(invocation("aws-req-1"), fixture(t, "apigateway-v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := output.(events.APIGatewayProxyResponse)
+	if response.StatusCode != http.StatusOK || response.Body != `{"id":"42"}` {
+		t.Errorf("response = %d %s, want 200 {\"id\":\"42\"}", response.StatusCode, response.Body)
+	}
+	if got := response.Headers[httpmw.DefaultRequestIDHeader]; got != "aws-req-1" {
+		t.Errorf("request ID header = %q, want the AWS request ID", got)
+	}
+	if path != "/orders/42" {
+		t.Errorf("HTTP path = %q, want /orders/42", path)
+	}
+	if arn == "" {
+		t.Error("function ARN not set")
+	}
+}
+
+func TestHandlerAPIGatewayV2Response(t *testing.T) {
+	tests := []struct {
+		name   string
+		output any
+	}{
+		{"value", httpmw.Response{Status: http.StatusCreated, Header: http.Header{"Location": {"/orders/7"}}, Body: map[string]int{"id": 7}}},
+		{"pointer", &httpmw.Response{Status: http.StatusCreated, Header: http.Header{"Location": {"/orders/7"}}, Body: map[string]int{"id": 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+				request := input.(events.APIGatewayV2HTTPRequest)
+				if request.Body != `{"product":"book","quantity":2}` {
+					t.Errorf("body = %s", request.Body)
+				}
+				return ctx, tt.output, nil
+			})
+
+			handler := Handler(chain, &Config{Decoder: APIGatewayV2(), Encoder: APIGatewayV2Response()})
+			output, err := handler(invocation("aws-req-2"), fixture(t, "apigateway-v2.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := output.(events.APIGatewayV2HTTPResponse)
+			if response.StatusCode != http.StatusCreated || response.Body != `{"id":7}` {
+				t.Errorf("response = %d %s, want 201 {\"id\":7}", response.StatusCode, response.Body)
+			}
+			if got := response.Headers["Location"]; got != "/orders/7" {
+				t.Errorf("Location = %q, want /orders/7", got)
+			}
+		})
+	}
+}
+
+func TestHandlerMapsErrorsToResponses(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		message string
+	}{
+		{fmt.Errorf("%w: order 42", middleware.ErrNotFound), http.StatusNotFound, "not found: order 42"},
+		{fmt.Errorf("connecting to db: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout)},
+		{fmt.Errorf("secret token leaked"), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+				return ctx, nil, tt.err
+			})
+
+			handler := Handler(chain, &Config{Decoder: APIGatewayProxy(), Encoder: APIGatewayProxyResponse()})
+			output, err := handler(invocation("aws-req-3"), fixture(t, "apigateway-v1.json"))
+			if err != nil {
+				t.Fatalf("invocation failed: %v", err)
+			}
+
+			response := output.(events.APIGatewayProxyResponse)
+			var body httpmw.ErrorBody
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.status || body.Error != tt.message || body.RequestID != "aws-req-3" {
+				t.Errorf("response = %d %+v, want %d with %q", response.StatusCode, body, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestHandlerRejectsMalformedEvents(t *testing.T) {
+	handler := Handler(middleware.NewChain(), &Config{Decoder: APIGatewayProxy(), Encoder: APIGatewayProxyResponse()})
+	output, err := handler(context.Background(), json.RawMessage(`{"path": 42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := output.(events.APIGatewayProxyResponse); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerEventBridge(t *testing.T) {
+	var detailType string
+	var detail struct {
+		Order int     `json:"order"`
+		Total float64 `json:"total"`
+	}
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		event := input.(events.EventBridgeEvent)
+		detailType = event.DetailType
+		return ctx, nil, json.Unmarshal(event.Detail, &detail)
+	})
+
+	if _, err := Handler(chain, &Config{Decoder: EventBridge()})(context.Background(), fixture(t, "eventbridge.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if detailType != "OrderPlaced" || detail.Order != 42 || detail.Total != 19.99 {
+		t.Errorf("decoded %q %+v, want OrderPlaced with order 42", detailType, detail)
+	}
+}
+
+func TestHandlerSNS(t *testing.T) {
+	var notification events.SNSEntity
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		notification = input.(events.SNSEvent).Records[0].SNS
+		return ctx, nil, nil
+	})
+
+	if _, err := Handler(chain, &Config{Decoder: SNS()})(invocation("aws-req-4"), fixture(t, "sns.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if notification.Subject != "OrderShipped" || notification.Message != `{"order":42,"status":"shipped"}` {
+		t.Errorf("notification = %q %s, want OrderShipped for order 42", notification.Subject, notification.Message)
+	}
+	if tenant := notification.MessageAttributes["tenant"].(map[string]any)["Value"]; tenant != "acme" {
+		t.Errorf("tenant attribute = %v, want acme", tenant)
+	}
+}
+
+func TestHandlerS3(t *testing.T) {
+	var record events.S3EventRecord
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		record = input.(events.S3Event).Records[0]
+		return ctx, nil, nil
+	})
+
+	if _, err := Handler(chain, &Config{Decoder: S3()})(invocation("aws-req-5"), fixture(t, "s3.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.EventName != "ObjectCreated:Put" || record.S3.Bucket.Name != "orders-invoices" ||
+		record.S3.Object.Key != "invoices/2025/order-42.pdf" || record.S3.Object.Size != 48213 {
+		t.Errorf("record = %s %s/%s (%d bytes)", record.EventName, record.S3.Bucket.Name, record.S3.Object.Key, record.S3.Object.Size)
+	}
+}
+
+func TestHandlerIsolatesWarmInvocations(t *testing.T) {
+	// A warm function may reuse a base context carrying a metadata container
+	base := middleware.WithMetadata(context.Background(), middleware.NewMetadata())
+	var requestID string
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		requestID, _ = middleware.GetRequestID(ctx)
+		return ctx, nil, nil
+	})
+	handler := Handler(chain, &Config{Decoder: APIGatewayProxy(), Encoder: APIGatewayProxyResponse()})
+
+	first := lambdacontext.NewContext(base, &lambdacontext.LambdaContext{AwsRequestID: "aws-req-6"})
+	if _, err := handler(first, fixture(t, "apigateway-v1.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next invocation has no request ID of its own
+	if _, err := handler(base, json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "" {
+		t.Errorf("second invocation saw request ID %q of the first", requestID)
+	}
+	if _, leaked := middleware.HTTPPathKey.Get(base); leaked {
+		t.Error("HTTP path written to the base context")
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ContextHandler is a slog.Handler that enriches every record with the
+// execution details found in the context passed to the logger: request ID,
+// chain name and path, step name, user ID and the active trace and span IDs.
+// With it, middleware can log through the ...Context logger methods without
+// collecting these fields by hand.
+//
+// Attributes already present on a record, or added to the logger with
+// WithAttrs, are not duplicated.
+//
+// Example:
+//
+//	logger := slog.New(middleware.NewContextHandler(slog.NewJSONHandler(os.Stdout, nil)))
+//
+//	func Charge(logger *slog.Logger) middleware.MiddlewareFunc {
+//		return func(ctx context.Context, input any) (context.Context, any, error) {
+//			logger.InfoContext(ctx, "Charging card") // includes request_id, chain_name, step, ...
+//			return ctx, input, nil
+//		}
+//	}
+type ContextHandler struct {
+	next slog.Handler
+
+	// preset holds the keys of the attributes added with WithAttrs, which
+	// the context attributes do not repeat
+	preset map[string]struct{}
+}
+
+// NewContextHandler wraps a handler so that records are enriched with the
+// execution details found in their context.
+func NewContextHandler(next slog.Handler) *ContextHandler {
+	return &ContextHandler{next: next}
+}
+
+// Enabled reports whether the wrapped handler handles records at the given level.
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle adds the context attributes to the record and passes it to the
+// wrapped handler.
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		return h.next.Handle(ctx, record)
+	}
+
+	attrs := contextLogAttrs(ctx)
+	if len(h.preset) > 0 {
+		attrs = slices.DeleteFunc(attrs, func(attr slog.Attr) bool {
+			_, ok := h.preset[attr.Key]
+			return ok
+		})
+	}
+	if len(attrs) == 0 {
+		return h.next.Handle(ctx, record)
+	}
+
+	// Skip attributes the caller already logged explicitly
+	record.Attrs(func(existing slog.Attr) bool {
+		for i := range attrs {
+			if attrs[i].Key == existing.Key {
+				attrs = append(attrs[:i], attrs[i+1:]...)
+				break
+			}
+		}
+		return len(attrs) > 0
+	})
+
+	record = record.Clone()
+	record.AddAttrs(attrs...)
+	return h.next.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler whose records include the given attributes.
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	preset := maps.Clone(h.preset)
+	if preset == nil {
+		preset = make(map[string]struct{}, len(attrs))
+	}
+	for _, attr := range attrs {
+		preset[attr.Key] = struct{}{}
+	}
+
+	return &ContextHandler{next: h.next.WithAttrs(attrs), preset: preset}
+}
+
+// WithGroup returns a handler that qualifies subsequent attributes, including
+// the context attributes, with the given group name. Context attributes added
+// before the group with WithAttrs are still not repeated.
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &ContextHandler{next: h.next.WithGroup(name), preset: h.preset}
+}
+
+// contextLogAttrs collects the execution details stored in the context.
+func contextLogAttrs(ctx context.Context) []slog.Attr {
+	var attrs []slog.Attr
+
+	if requestID, ok := GetRequestID(ctx); ok && requestID != "" {
+		attrs = append(attrs, slog.String("request_id", requestID))
+	}
+
+	if chainName, ok := GetChainName(ctx); ok && chainName != "" {
+		attrs = append(attrs, slog.String("chain_name", chainName))
+	}
+
+	if st := stateFrom(ctx); st != nil {
+		if path := chainPath(st); strings.Contains(path, "/") {
+			attrs = append(attrs, slog.String("chain_path", path))
+		}
+	}
+
+	if step, ok := GetStepName(ctx); ok {
+		attrs = append(attrs, slog.String("step", step))
+	}
+
+	if userID, ok := GetUserID(ctx); ok && userID != "" {
+		attrs = append(attrs, slog.String("user_id", userID))
+	}
+
+	if span, ok := SpanFromContext(ctx); ok {
+		if correlator, ok := span.(LogCorrelator); ok {
+			attrs = append(attrs, correlator.CorrelationAttrs()...)
+		} else if traceID := span.TraceID(); traceID != "" {
+			attrs = append(attrs,
+				slog.String("trace_id", traceID),
+				slog.String("span_id", span.SpanID()),
+			)
+		}
+	}
+
+	return attrs
+}
+
+// chainPath returns the names of the nested chains of an execution, outermost
+// first, joined by slashes. Unnamed chains are left out.
+func chainPath(st *execState) string {
+	var names []string
+	for current := st; current != nil; current = current.parent {
+		if current.chain.name != "" {
+			names = append(names, current.chain.name)
+		}
+	}
+
+	// Reverse to get the outermost chain first
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	return strings.Join(names, "/")
+}
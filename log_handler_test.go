@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// jsonLogger returns a logger writing JSON records through a ContextHandler
+// into buf.
+func jsonLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(NewContextHandler(slog.NewJSONHandler(buf, nil)))
+}
+
+// logEntry decodes the single record written to buf.
+func logEntry(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %s: %v", buf, err)
+	}
+	return entry
+}
+
+// logInStep calls log from a step of a named chain run with the
+// request ID "req-1" and the user ID "ada".
+func logInStep(t *testing.T, log func(ctx context.Context)) {
+	t.Helper()
+
+	chain := NewNamedChain("orders").AppendNamed("charge", func(ctx context.Context, input any) (context.Context, any, error) {
+		log(ctx)
+		return ctx, input, nil
+	})
+
+	ctx := SetUserID(SetRequestID(context.Background(), "req-1"), "ada")
+	if _, _, err := chain.Then(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContextHandlerAddsExecutionDetails(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonLogger(&buf)
+	logInStep(t, func(ctx context.Context) {
+		logger.InfoContext(ctx, "Charging card")
+	})
+
+	entry := logEntry(t, &buf)
+	for key, want := range map[string]string{
+		"request_id": "req-1",
+		"chain_name": "orders",
+		"step":       "charge",
+		"user_id":    "ada",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s = %v; want %s", key, entry[key], want)
+		}
+	}
+}
+
+func TestContextHandlerAddsTraceAndChainPath(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonLogger(&buf)
+
+	inner := NewNamedChain("payment", func(ctx context.Context, input any) (context.Context, any, error) {
+		logger.InfoContext(ctx, "Paying")
+		return ctx, input, nil
+	})
+	outer := NewNamedChain("checkout", func(ctx context.Context, input any) (context.Context, any, error) {
+		return inner.Then(ctx, input)
+	})
+
+	ctx, span := StartSpan(context.Background(), NewRecordingTracer(), "request")
+	if _, _, err := outer.Then(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := logEntry(t, &buf)
+	if entry["chain_path"] != "checkout/payment" {
+		t.Errorf("chain_path = %v; want checkout/payment", entry["chain_path"])
+	}
+	if entry["trace_id"] != span.TraceID() || entry["span_id"] == nil {
+		t.Errorf("trace_id = %v, span_id = %v; want the IDs of the active span", entry["trace_id"], entry["span_id"])
+	}
+}
+
+func TestContextHandlerDoesNotDuplicateAttrs(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(logger *slog.Logger, ctx context.Context)
+	}{
+		{"record attribute", func(logger *slog.Logger, ctx context.Context) {
+			logger.InfoContext(ctx, "Charging card", "request_id", "explicit")
+		}},
+		{"WithAttrs", func(logger *slog.Logger, ctx context.Context) {
+			logger.With("request_id", "explicit").InfoContext(ctx, "Charging card")
+		}},
+		{"WithAttrs before a group", func(logger *slog.Logger, ctx context.Context) {
+			logger.With("request_id", "explicit").WithGroup("payment").InfoContext(ctx, "Charging card", "amount", 10)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := jsonLogger(&buf)
+			logInStep(t, func(ctx context.Context) {
+				tt.log(logger, ctx)
+			})
+
+			if n := strings.Count(buf.String(), `"request_id"`); n != 1 {
+				t.Errorf("request_id logged %d times: %s", n, buf.String())
+			}
+			if !strings.Contains(buf.String(), `"request_id":"explicit"`) {
+				t.Errorf("explicit request_id replaced: %s", buf.String())
+			}
+			if !strings.Contains(buf.String(), `"chain_name":"orders"`) {
+				t.Errorf("other execution details missing: %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestContextHandlerGroupsContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonLogger(&buf).WithGroup("payment")
+	logInStep(t, func(ctx context.Context) {
+		logger.InfoContext(ctx, "Charging card")
+	})
+
+	group, _ := logEntry(t, &buf)["payment"].(map[string]any)
+	if group["request_id"] != "req-1" {
+		t.Errorf("payment group = %v; want the request ID in it", group)
+	}
+}
+
+func TestContextHandlerOutsideChain(t *testing.T) {
+	var buf bytes.Buffer
+	jsonLogger(&buf).Info("Starting")
+
+	entry := logEntry(t, &buf)
+	if len(entry) != 3 {
+		t.Errorf("entry = %v; want only time, level and msg", entry)
+	}
+}
@@ -4,25 +4,42 @@ import (
 	"context"
 )
 
-// Define a custom key type to avoid collisions
-type metadataKey string
+// Typed keys for the metadata managed by this package
+var (
+	// UserIDKey holds the ID of the user performing the request. Its name is
+	// "user", the key SetUserID has always used, so GetMetadata(ctx, "user")
+	// keeps reading it.
+	UserIDKey = NewKey[string]("user")
 
-const (
-	// Define your metadata keys
-	userKey    metadataKey = "user"
-	sessionKey metadataKey = "session"
-	requestKey metadataKey = "request_id"
+	// RequestIDKey holds the unique ID of the request
+	RequestIDKey = NewKey[string]("request_id")
+
+	// DeliveryCountKey holds how many times the message being processed was
+	// delivered, starting at 1. Message consumers set it.
+	DeliveryCountKey = NewKey[int]("delivery_count")
 )
 
-// AddMetadata adds a key-value pair to the context as metadata.
-// It returns a new context that contains the metadata.
+// AddMetadata adds a key-value pair to the context's metadata container.
+// The container is modified in place: when the context already carries one,
+// as it always does within a chain execution, the value is visible through
+// every context sharing that container, including ones derived before the
+// call, and the same context is returned. Only when the context carries no
+// container is a new one attached, so the returned context must be used for
+// subsequent calls.
+//
+// Every chain execution works on its own copy of the caller's metadata, so
+// values added by its steps are not seen through the caller's context, only
+// through the context returned by Then. Use ForkMetadata to isolate parallel
+// branches started within a step.
 //
 // Example:
 //
 //	ctx = AddMetadata(ctx, "validated", true)
 //	ctx = AddMetadata(ctx, "user_id", "12345")
 func AddMetadata(ctx context.Context, key string, value interface{}) context.Context {
-	return context.WithValue(ctx, key, value)
+	ctx, md := ensureMetadata(ctx)
+	md.Set(key, value)
+	return ctx
 }
 
 // GetMetadata retrieves metadata by key from the context.
@@ -35,8 +52,13 @@ func AddMetadata(ctx context.Context, key string, value interface{}) context.Con
 //	    fmt.Printf("Found value: %v\n", value)
 //	}
 func GetMetadata(ctx context.Context, key string) (interface{}, bool) {
-	value := ctx.Value(key)
-	return value, value != nil
+	md := MetadataFrom(ctx)
+	if md == nil {
+		return nil, false
+	}
+
+	value, ok := md.Get(key)
+	return value, ok && value != nil
 }
 
 // GetMetadataString retrieves a string value by key from the context metadata.
@@ -50,7 +72,7 @@ func GetMetadata(ctx context.Context, key string) (interface{}, bool) {
 //	    fmt.Printf("User ID: %s\n", userID)
 //	}
 func GetMetadataString(ctx context.Context, key string) (string, bool) {
-	value := ctx.Value(key)
+	value, _ := GetMetadata(ctx, key)
 	str, ok := value.(string)
 	return str, ok
 }
@@ -66,19 +88,19 @@ func GetMetadataString(ctx context.Context, key string) (string, bool) {
 //	    fmt.Println("Request is validated")
 //	}
 func GetMetadataBool(ctx context.Context, key string) (bool, bool) {
-	value := ctx.Value(key)
+	value, _ := GetMetadata(ctx, key)
 	b, ok := value.(bool)
 	return b, ok
 }
 
 // SetUserID sets the user ID in the context using a type-safe approach.
-// It demonstrates the recommended pattern for setting specific metadata types.
+// It is a shorthand for UserIDKey.Set.
 //
 // Example:
 //
 //	ctx = SetUserID(ctx, "user123")
 func SetUserID(ctx context.Context, userID string) context.Context {
-	return context.WithValue(ctx, userKey, userID)
+	return UserIDKey.Set(ctx, userID)
 }
 
 // GetUserID retrieves the user ID from the context in a type-safe manner.
@@ -92,18 +114,18 @@ func SetUserID(ctx context.Context, userID string) context.Context {
 //	    fmt.Printf("Current user: %s\n", userID)
 //	}
 func GetUserID(ctx context.Context) (string, bool) {
-	userID, ok := ctx.Value(userKey).(string)
-	return userID, ok
+	return UserIDKey.Get(ctx)
 }
 
 // SetRequestID sets the request ID in the context using a type-safe approach.
 // This is useful for request tracing and logging purposes.
+// It is a shorthand for RequestIDKey.Set.
 //
 // Example:
 //
 //	ctx = SetRequestID(ctx, "req_abc123")
 func SetRequestID(ctx context.Context, requestID string) context.Context {
-	return context.WithValue(ctx, requestKey, requestID)
+	return RequestIDKey.Set(ctx, requestID)
 }
 
 // GetRequestID retrieves the request ID from the context in a type-safe manner.
@@ -117,6 +139,5 @@ func SetRequestID(ctx context.Context, requestID string) context.Context {
 //	    log.Printf("Processing request: %s", requestID)
 //	}
 func GetRequestID(ctx context.Context) (string, bool) {
-	requestID, ok := ctx.Value(requestKey).(string)
-	return requestID, ok
+	return RequestIDKey.Get(ctx)
 }
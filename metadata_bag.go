@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metadataCtxKey is the context key under which a Metadata container is stored
+// when it is not attached to a chain execution.
+type metadataCtxKey struct{}
+
+// Metadata is a concurrency-safe container of metadata for a single execution.
+// Chain executions attach one container to the context, and every AddMetadata
+// call within the execution writes to it, so metadata can be listed as a whole
+// and lookups do not depend on the depth of the context.
+//
+// Snapshots share their values with the original container until either side
+// writes, which makes forking metadata for parallel branches cheap.
+type Metadata struct {
+	mu     sync.RWMutex
+	values map[string]any
+	shared bool // values is shared with a snapshot and must be copied before writing
+}
+
+// NewMetadata creates an empty metadata container.
+func NewMetadata() *Metadata {
+	return &Metadata{}
+}
+
+// Set stores a value under the given key, replacing any previous value.
+func (m *Metadata) Set(key string, value any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prepareWrite()
+	m.values[key] = value
+}
+
+// Get returns the value stored under the given key and whether it was found.
+func (m *Metadata) Get(key string) (any, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// Delete removes the value stored under the given key, if any.
+func (m *Metadata) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+
+	m.prepareWrite()
+	delete(m.values, key)
+}
+
+// Len returns the number of stored values.
+func (m *Metadata) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.values)
+}
+
+// Keys returns the stored keys in sorted order.
+func (m *Metadata) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// All returns a copy of all stored values. Modifying the returned map does not
+// affect the container.
+func (m *Metadata) All() map[string]any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := make(map[string]any, len(m.values))
+	for key, value := range m.values {
+		all[key] = value
+	}
+
+	return all
+}
+
+// Range calls fn for every stored value in sorted key order until fn returns
+// false. The container must not be modified from within fn.
+func (m *Metadata) Range(fn func(key string, value any) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !fn(key, m.values[key]) {
+			return
+		}
+	}
+}
+
+// Snapshot returns a copy-on-write copy of the container. The snapshot and the
+// original share their values until one of them is modified, after which the
+// modification is only visible on the modified side.
+func (m *Metadata) Snapshot() *Metadata {
+	snapshot := &Metadata{}
+	m.snapshotInto(snapshot)
+	return snapshot
+}
+
+// snapshotInto makes dst a copy-on-write copy of the container, like Snapshot,
+// without allocating a new container. dst must not be in use yet.
+func (m *Metadata) snapshotInto(dst *Metadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.values != nil {
+		m.shared = true
+	}
+
+	dst.values = m.values
+	dst.shared = m.values != nil
+}
+
+// Namespace returns a view of the container whose keys are prefixed with the
+// given namespace and a dot, e.g. "http.method" for Namespace("http").Set("method", ...).
+func (m *Metadata) Namespace(namespace string) *MetadataNamespace {
+	return &MetadataNamespace{metadata: m, prefix: namespace + "."}
+}
+
+// prepareWrite makes the values map writable. It must be called with the lock held.
+func (m *Metadata) prepareWrite() {
+	if m.values == nil {
+		m.values = make(map[string]any)
+		return
+	}
+
+	if m.shared {
+		values := make(map[string]any, len(m.values)+1)
+		for key, value := range m.values {
+			values[key] = value
+		}
+		m.values = values
+		m.shared = false
+	}
+}
+
+// MetadataNamespace is a view of a Metadata container restricted to keys with
+// a common prefix.
+type MetadataNamespace struct {
+	metadata *Metadata
+	prefix   string
+}
+
+// Set stores a value under the namespaced key.
+func (n *MetadataNamespace) Set(key string, value any) {
+	n.metadata.Set(n.prefix+key, value)
+}
+
+// Get returns the value stored under the namespaced key and whether it was found.
+func (n *MetadataNamespace) Get(key string) (any, bool) {
+	return n.metadata.Get(n.prefix + key)
+}
+
+// Delete removes the value stored under the namespaced key, if any.
+func (n *MetadataNamespace) Delete(key string) {
+	n.metadata.Delete(n.prefix + key)
+}
+
+// All returns a copy of the values in the namespace, keyed without the prefix.
+func (n *MetadataNamespace) All() map[string]any {
+	all := make(map[string]any)
+	n.metadata.Range(func(key string, value any) bool {
+		if strings.HasPrefix(key, n.prefix) {
+			all[strings.TrimPrefix(key, n.prefix)] = value
+		}
+		return true
+	})
+
+	return all
+}
+
+// MetadataFrom returns the metadata container attached to the context, or nil
+// if there is none. Within a chain execution a container is always attached.
+//
+// Example:
+//
+//	if md := middleware.MetadataFrom(ctx); md != nil {
+//	    for key, value := range md.All() {
+//	        log.Printf("%s=%v", key, value)
+//	    }
+//	}
+func MetadataFrom(ctx context.Context) *Metadata {
+	md, _ := ctx.Value(metadataCtxKey{}).(*Metadata)
+	return md
+}
+
+// WithMetadata returns a context carrying the given metadata container.
+// Subsequent metadata writes through the returned context go to md.
+func WithMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, metadataCtxKey{}, md)
+}
+
+// ForkMetadata returns a context carrying a copy-on-write snapshot of the
+// current metadata. Use it when starting a parallel branch, so that metadata
+// written by the branch does not leak into its siblings.
+//
+// Example:
+//
+//	for _, item := range items {
+//		branchCtx := middleware.ForkMetadata(ctx)
+//		go process(branchCtx, item)
+//	}
+func ForkMetadata(ctx context.Context) context.Context {
+	md := MetadataFrom(ctx)
+	if md == nil {
+		return WithMetadata(ctx, NewMetadata())
+	}
+
+	return WithMetadata(ctx, md.Snapshot())
+}
+
+// AllMetadata returns a copy of all metadata attached to the context.
+// It returns an empty map if the context carries no metadata.
+//
+// Example:
+//
+//	for key, value := range middleware.AllMetadata(ctx) {
+//	    log.Printf("%s=%v", key, value)
+//	}
+func AllMetadata(ctx context.Context) map[string]any {
+	md := MetadataFrom(ctx)
+	if md == nil {
+		return map[string]any{}
+	}
+
+	return md.All()
+}
+
+// DeleteMetadata removes a metadata key from the context's metadata container.
+//
+// Example:
+//
+//	middleware.DeleteMetadata(ctx, "validated")
+func DeleteMetadata(ctx context.Context, key string) {
+	if md := MetadataFrom(ctx); md != nil {
+		md.Delete(key)
+	}
+}
+
+// ensureMetadata returns the context's metadata container, attaching a new one
+// if the context has none yet.
+func ensureMetadata(ctx context.Context) (context.Context, *Metadata) {
+	if md := MetadataFrom(ctx); md != nil {
+		return ctx, md
+	}
+
+	md := NewMetadata()
+	return WithMetadata(ctx, md), md
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUserIDKeepsItsMetadataName(t *testing.T) {
+	ctx := SetUserID(context.Background(), "u-1")
+
+	if value, _ := GetMetadataString(ctx, "user"); value != "u-1" {
+		t.Errorf(`GetMetadataString(ctx, "user") = %q, want u-1`, value)
+	}
+	if value, _ := UserIDKey.Get(AddMetadata(context.Background(), "user", "u-2")); value != "u-2" {
+		t.Errorf(`UserIDKey after AddMetadata(ctx, "user", ...) = %q, want u-2`, value)
+	}
+}
+
+func TestKeyDefaultsAndTypes(t *testing.T) {
+	region := NewKeyWithDefault("region", "us-east-1")
+	attempt := NewKey[int]("attempt")
+
+	ctx := AddMetadata(context.Background(), "attempt", "three")
+	if got := region.MustGet(ctx); got != "us-east-1" {
+		t.Errorf("default = %q, want us-east-1", got)
+	}
+	if _, ok := attempt.Get(ctx); ok {
+		t.Error("Get reported a value of the wrong type as set")
+	}
+
+	ctx = attempt.Set(ctx, 3)
+	if got, ok := attempt.Get(ctx); !ok || got != 3 {
+		t.Errorf("Get = %d, %v, want 3, true", got, ok)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustGet without value or default did not panic")
+		}
+	}()
+	NewKey[string]("missing").MustGet(ctx)
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Names of the metrics recorded by WithMetrics
+const (
+	// MetricChainExecutions counts chain executions by chain and outcome
+	MetricChainExecutions = "middleware_chain_executions_total"
+
+	// MetricChainErrors counts failed chain executions by chain and failing step
+	MetricChainErrors = "middleware_chain_errors_total"
+
+	// MetricChainPanics counts panics by chain and panicking step
+	MetricChainPanics = "middleware_chain_panics_total"
+
+	// MetricChainInFlight is the number of executions currently running per chain
+	MetricChainInFlight = "middleware_chain_in_flight"
+
+	// MetricChainDuration is the latency histogram of chain executions, in seconds
+	MetricChainDuration = "middleware_chain_duration_seconds"
+
+	// MetricStepDuration is the latency histogram of step executions, in seconds
+	MetricStepDuration = "middleware_step_duration_seconds"
+)
+
+// unnamedChain is the chain label used for chains without a name
+const unnamedChain = "unnamed"
+
+// Labels are the dimensions of a metric series, such as chain and step names.
+type Labels map[string]string
+
+// MetricKind identifies the type of a metric.
+type MetricKind string
+
+// Supported metric kinds
+const (
+	KindCounter   MetricKind = "counter"
+	KindGauge     MetricKind = "gauge"
+	KindHistogram MetricKind = "histogram"
+)
+
+// Metrics records counters, gauges and histograms. Implementations must be
+// safe for concurrent use. The context carries the execution being measured,
+// which implementations may use, e.g. to attach trace exemplars.
+//
+// MemoryMetrics keeps metrics in memory and renders them in the Prometheus
+// text format; DogStatsD sends them to a DogStatsD agent.
+type Metrics interface {
+	// AddCounter increases a counter by delta
+	AddCounter(ctx context.Context, name string, labels Labels, delta float64)
+
+	// SetGauge sets a gauge to value
+	SetGauge(ctx context.Context, name string, labels Labels, value float64)
+
+	// ObserveHistogram records a value in a histogram
+	ObserveHistogram(ctx context.Context, name string, labels Labels, value float64)
+}
+
+// MetricsDescriber is implemented by Metrics that keep metric descriptions,
+// e.g. for HELP and TYPE lines in the Prometheus format.
+type MetricsDescriber interface {
+	Describe(name string, kind MetricKind, help string)
+}
+
+// WithMetrics returns a ChainOption that records chain and step metrics:
+// executions by outcome, errors and panics by step, in-flight executions, and
+// latency histograms per chain and per step. Series are labeled with the chain
+// name ("unnamed" for chains without one) and the step name. The in-flight
+// gauge counts the executions of every chain of that name recording to the
+// same Metrics, whichever WithMetrics option they were given.
+//
+// Example:
+//
+//	metrics := middleware.NewMemoryMetrics()
+//	chain := middleware.NewNamedChain("orders", authMiddleware, createOrder).
+//		With(middleware.WithMetrics(metrics))
+func WithMetrics(metrics Metrics) ChainOption {
+	if describer, ok := metrics.(MetricsDescriber); ok {
+		describeChainMetrics(describer)
+	}
+
+	recorder := &chainMetrics{metrics: metrics, owner: metrics}
+	if !reflect.TypeOf(metrics).Comparable() {
+		// Values that cannot be map keys keep counts of their own
+		recorder.owner = recorder
+	}
+
+	return WithHooks(Hooks{
+		BeforeChain: recorder.beforeChain,
+		AfterChain:  recorder.afterChain,
+		AfterStep:   recorder.afterStep,
+		OnPanic:     recorder.onPanic,
+	})
+}
+
+// describeChainMetrics registers the descriptions of the chain metrics.
+func describeChainMetrics(describer MetricsDescriber) {
+	describer.Describe(MetricChainExecutions, KindCounter, "Number of chain executions by outcome.")
+	describer.Describe(MetricChainErrors, KindCounter, "Number of failed chain executions by failing step.")
+	describer.Describe(MetricChainPanics, KindCounter, "Number of panics by panicking step.")
+	describer.Describe(MetricChainInFlight, KindGauge, "Number of chain executions in progress.")
+	describer.Describe(MetricChainDuration, KindHistogram, "Duration of chain executions in seconds.")
+	describer.Describe(MetricStepDuration, KindHistogram, "Duration of step executions in seconds.")
+}
+
+// chainMetrics implements the hooks installed by WithMetrics.
+type chainMetrics struct {
+	metrics Metrics
+
+	// owner identifies the in-flight counts of the Metrics
+	owner any
+}
+
+// inFlightKey identifies the in-flight count of a chain recorded to a Metrics.
+type inFlightKey struct {
+	owner any
+	chain string
+}
+
+// inFlight counts the running executions per Metrics and chain, so that every
+// WithMetrics option recording to the same Metrics publishes the same gauge.
+var inFlight = struct {
+	mu     sync.Mutex
+	counts map[inFlightKey]int
+}{counts: map[inFlightKey]int{}}
+
+func (m *chainMetrics) beforeChain(ctx context.Context, info ChainInfo) context.Context {
+	chain := chainLabel(info.Name)
+	m.setInFlight(ctx, chain, 1)
+	return ctx
+}
+
+func (m *chainMetrics) afterChain(ctx context.Context, info ChainInfo, err error, elapsed time.Duration) {
+	chain := chainLabel(info.Name)
+	m.setInFlight(ctx, chain, -1)
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+
+	m.metrics.AddCounter(ctx, MetricChainExecutions, Labels{"chain": chain, "outcome": outcome}, 1)
+	m.metrics.ObserveHistogram(ctx, MetricChainDuration, Labels{"chain": chain}, elapsed.Seconds())
+}
+
+func (m *chainMetrics) afterStep(ctx context.Context, info StepInfo, err error, elapsed time.Duration) {
+	labels := Labels{"chain": chainLabel(info.Chain), "step": info.Name}
+	m.metrics.ObserveHistogram(ctx, MetricStepDuration, labels, elapsed.Seconds())
+
+	if err != nil {
+		m.metrics.AddCounter(ctx, MetricChainErrors, labels, 1)
+	}
+}
+
+func (m *chainMetrics) onPanic(ctx context.Context, info StepInfo, recovered any) {
+	labels := Labels{"chain": chainLabel(info.Chain), "step": info.Name}
+	m.metrics.AddCounter(ctx, MetricChainPanics, labels, 1)
+}
+
+// setInFlight adjusts the in-flight count of a chain and publishes it.
+func (m *chainMetrics) setInFlight(ctx context.Context, chain string, delta int) {
+	key := inFlightKey{owner: m.owner, chain: chain}
+
+	inFlight.mu.Lock()
+	count := inFlight.counts[key] + delta
+	if count == 0 {
+		delete(inFlight.counts, key)
+	} else {
+		inFlight.counts[key] = count
+	}
+	inFlight.mu.Unlock()
+
+	m.metrics.SetGauge(ctx, MetricChainInFlight, Labels{"chain": chain}, float64(count))
+}
+
+// chainLabel returns the label value for a chain name.
+func chainLabel(name string) string {
+	if name == "" {
+		return unnamedChain
+	}
+
+	return name
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content types of the supported exposition formats
+const (
+	ContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// MetricsHandler returns an http.Handler that serves the metrics of a
+// MemoryMetrics for scraping. Scrapers that accept
+// "application/openmetrics-text" receive the OpenMetrics format, including
+// exemplars with the trace and span IDs of recorded executions; all others
+// receive the Prometheus text format. A nil metrics serves DefaultMetrics.
+//
+// Example:
+//
+//	metrics := middleware.NewMemoryMetrics()
+//	chain = chain.With(middleware.WithMetrics(metrics))
+//
+//	http.Handle("/metrics", middleware.MetricsHandler(metrics))
+func MetricsHandler(metrics *MemoryMetrics) http.Handler {
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		families := metrics.Families()
+
+		// Render into a buffer so that errors can still produce a status code
+		var buf bytes.Buffer
+		contentType := ContentTypePrometheus
+		var err error
+		if acceptsOpenMetrics(r.Header.Get("Accept")) {
+			contentType = ContentTypeOpenMetrics
+			err = WriteOpenMetrics(&buf, families)
+		} else {
+			err = WritePrometheus(&buf, families)
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		w.Write(buf.Bytes())
+	})
+}
+
+// WriteOpenMetrics writes metric families in the OpenMetrics 1.0 text format,
+// including exemplars, and terminates the output with "# EOF".
+func WriteOpenMetrics(w io.Writer, families []MetricFamily) error {
+	bw := bufio.NewWriter(w)
+
+	for _, family := range families {
+		// OpenMetrics names counter families without the _total suffix
+		name := family.Name
+		if family.Kind == KindCounter {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, family.Kind)
+		if family.Help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeOpenMetricsHelp(family.Help))
+		}
+
+		for _, series := range family.Series {
+			switch family.Kind {
+			case KindCounter:
+				fmt.Fprintf(bw, "%s_total%s %s%s\n", name, formatLabels(series.Labels, "", ""),
+					formatFloat(series.Value), formatExemplar(series.Exemplar))
+			case KindHistogram:
+				for _, bucket := range series.Buckets {
+					fmt.Fprintf(bw, "%s_bucket%s %d%s\n", name,
+						formatLabels(series.Labels, "le", formatFloat(bucket.UpperBound)),
+						bucket.Count, formatExemplar(bucket.Exemplar))
+				}
+				fmt.Fprintf(bw, "%s_sum%s %s\n", name, formatLabels(series.Labels, "", ""), formatFloat(series.Sum))
+				fmt.Fprintf(bw, "%s_count%s %d\n", name, formatLabels(series.Labels, "", ""), series.Count)
+			default:
+				fmt.Fprintf(bw, "%s%s %s\n", name, formatLabels(series.Labels, "", ""), formatFloat(series.Value))
+			}
+		}
+	}
+
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+// acceptsOpenMetrics reports whether an Accept header asks for OpenMetrics.
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "application/openmetrics-text" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatExemplar renders an exemplar suffix, or nothing for a nil exemplar.
+func formatExemplar(exemplar *Exemplar) string {
+	if exemplar == nil {
+		return ""
+	}
+
+	timestamp := float64(exemplar.Timestamp.UnixNano()) / 1e9
+	return fmt.Sprintf(" # %s %s %s", formatLabels(exemplar.Labels, "", ""),
+		formatFloat(exemplar.Value), strconv.FormatFloat(timestamp, 'f', 3, 64))
+}
+
+// escapeOpenMetricsHelp escapes backslashes, quotes and newlines in help texts.
+func escapeOpenMetricsHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(help)
+}
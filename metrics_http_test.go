@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape requests the metrics handler with the given method and Accept header.
+func scrape(t *testing.T, handler http.Handler, method, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMetricsHandlerServesPrometheus(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.Describe("jobs_total", KindCounter, "Processed jobs.")
+	metrics.AddCounter(context.Background(), "jobs_total", Labels{"queue": `a"b`}, 2)
+
+	rec := scrape(t, MetricsHandler(metrics), http.MethodGet, "text/plain")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != ContentTypePrometheus {
+		t.Errorf("Content-Type = %q, want %q", got, ContentTypePrometheus)
+	}
+
+	want := "# HELP jobs_total Processed jobs.\n" +
+		"# TYPE jobs_total counter\n" +
+		`jobs_total{queue="a\"b"} 2` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMetricsHandlerServesOpenMetrics(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.SetBuckets("latency_seconds", []float64{0.1})
+	rec := NewRecordingTracer()
+	ctx, span := StartSpan(context.Background(), rec, "request")
+	metrics.AddCounter(ctx, "jobs_total", nil, 1)
+	metrics.ObserveHistogram(ctx, "latency_seconds", nil, 0.05)
+	span.Finish()
+
+	resp := scrape(t, MetricsHandler(metrics), http.MethodGet, "application/openmetrics-text; version=1.0.0, text/plain;q=0.5")
+
+	if got := resp.Header().Get("Content-Type"); got != ContentTypeOpenMetrics {
+		t.Errorf("Content-Type = %q, want %q", got, ContentTypeOpenMetrics)
+	}
+
+	body := resp.Body.String()
+	exemplar := `# {span_id="` + span.SpanID() + `",trace_id="` + span.TraceID() + `"}`
+	for _, want := range []string{
+		"# TYPE jobs counter\n",
+		"jobs_total 1 " + exemplar,
+		`latency_seconds_bucket{le="0.1"} 1 ` + exemplar,
+		`latency_seconds_bucket{le="+Inf"} 1` + "\n",
+		"latency_seconds_count 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q:\n%s", want, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("body does not end with # EOF:\n%s", body)
+	}
+}
+
+func TestMetricsHandlerHead(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.SetGauge(context.Background(), "workers", nil, 3)
+
+	get := scrape(t, MetricsHandler(metrics), http.MethodGet, "")
+	head := scrape(t, MetricsHandler(metrics), http.MethodHead, "")
+
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Fatalf("HEAD: status %d with %d body bytes, want 200 and no body", head.Code, head.Body.Len())
+	}
+	if got, want := head.Header().Get("Content-Length"), get.Header().Get("Content-Length"); got != want {
+		t.Errorf("HEAD Content-Length = %q, want %q", got, want)
+	}
+}
+
+func TestMetricsHandlerRejectsOtherMethods(t *testing.T) {
+	rec := scrape(t, MetricsHandler(NewMemoryMetrics()), http.MethodPost, "")
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, HEAD" {
+		t.Errorf("Allow = %q, want %q", got, "GET, HEAD")
+	}
+}
@@ -0,0 +1,423 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds used by MemoryMetrics,
+// suited for latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMetrics is a MemoryMetrics shared by the application. MetricsHandler
+// serves it when called with nil.
+var DefaultMetrics = NewMemoryMetrics()
+
+// MetricFamily is a snapshot of all series of a metric.
+type MetricFamily struct {
+	Name   string
+	Help   string
+	Kind   MetricKind
+	Series []MetricSeries
+}
+
+// MetricSeries is a snapshot of a single labeled series of a metric.
+type MetricSeries struct {
+	Labels Labels
+
+	// Value is the current value of a counter or gauge
+	Value float64
+
+	// Exemplar is the most recent exemplar of a counter, if any
+	Exemplar *Exemplar
+
+	// Count, Sum and Buckets describe a histogram
+	Count   uint64
+	Sum     float64
+	Buckets []Bucket
+}
+
+// Bucket is a cumulative histogram bucket.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+
+	// Exemplar is the most recent exemplar that fell into this bucket, if any
+	Exemplar *Exemplar
+}
+
+// Exemplar links a sample to the trace that produced it.
+type Exemplar struct {
+	Labels    Labels
+	Value     float64
+	Timestamp time.Time
+}
+
+// exemplarFromContext creates an exemplar holding the IDs of the active span,
+// or returns nil if the context carries no traced span.
+func exemplarFromContext(ctx context.Context, value float64) *Exemplar {
+	if ctx == nil {
+		return nil
+	}
+
+	span, ok := rootSpanFromContext(ctx)
+	if !ok || span.TraceID() == "" {
+		return nil
+	}
+
+	return &Exemplar{
+		Labels:    Labels{"trace_id": span.TraceID(), "span_id": span.SpanID()},
+		Value:     value,
+		Timestamp: time.Now(),
+	}
+}
+
+// MemoryMetrics is an in-memory Metrics implementation. Its content can be
+// inspected with Families and Value, which makes it suitable for tests, and
+// rendered in the Prometheus text format with WritePrometheus.
+//
+// Example:
+//
+//	metrics := middleware.NewMemoryMetrics()
+//	chain = chain.With(middleware.WithMetrics(metrics))
+//
+//	chain.Then(ctx, input)
+//	metrics.WritePrometheus(os.Stdout)
+type MemoryMetrics struct {
+	mu       sync.RWMutex
+	families map[string]*memoryFamily
+	buckets  map[string][]float64
+}
+
+// memoryFamily holds the series of a metric.
+type memoryFamily struct {
+	name   string
+	help   string
+	kind   MetricKind
+	series map[string]*memorySeries
+}
+
+// memorySeries holds the state of a single series.
+type memorySeries struct {
+	labels    Labels
+	value     float64
+	exemplar  *Exemplar
+	count     uint64
+	sum       float64
+	bounds    []float64
+	buckets   []uint64    // non-cumulative count per bound
+	exemplars []*Exemplar // latest exemplar per bound, plus one for +Inf
+}
+
+// NewMemoryMetrics creates an empty MemoryMetrics.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		families: map[string]*memoryFamily{},
+		buckets:  map[string][]float64{},
+	}
+}
+
+// SetBuckets sets the bucket upper bounds of a histogram. It only affects
+// series created afterwards, so call it before recording.
+func (m *MemoryMetrics) SetBuckets(name string, buckets []float64) {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buckets[name] = sorted
+}
+
+// Describe sets the kind and help text of a metric.
+func (m *MemoryMetrics) Describe(name string, kind MetricKind, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family := m.family(name, kind)
+	family.help = help
+}
+
+// AddCounter increases a counter by delta. If the context carries a traced
+// span, its IDs are kept as the series exemplar.
+func (m *MemoryMetrics) AddCounter(ctx context.Context, name string, labels Labels, delta float64) {
+	exemplar := exemplarFromContext(ctx, delta)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series := m.family(name, KindCounter).get(labels, nil)
+	series.value += delta
+	if exemplar != nil {
+		series.exemplar = exemplar
+	}
+}
+
+// SetGauge sets a gauge to value.
+func (m *MemoryMetrics) SetGauge(ctx context.Context, name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.family(name, KindGauge).get(labels, nil).value = value
+}
+
+// ObserveHistogram records a value in a histogram. If the context carries a
+// traced span, its IDs are kept as the exemplar of the matching bucket.
+func (m *MemoryMetrics) ObserveHistogram(ctx context.Context, name string, labels Labels, value float64) {
+	exemplar := exemplarFromContext(ctx, value)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bounds, ok := m.buckets[name]
+	if !ok {
+		bounds = DefaultBuckets
+	}
+
+	series := m.family(name, KindHistogram).get(labels, bounds)
+	series.count++
+	series.sum += value
+
+	index := sort.SearchFloat64s(series.bounds, value)
+	if index < len(series.bounds) {
+		series.buckets[index]++
+	}
+	if exemplar != nil {
+		series.exemplars[index] = exemplar
+	}
+}
+
+// Value returns the current value of a counter or gauge series and whether
+// the series exists.
+func (m *MemoryMetrics) Value(name string, labels Labels) (float64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	family, ok := m.families[name]
+	if !ok {
+		return 0, false
+	}
+
+	series, ok := family.series[labelsKey(labels)]
+	if !ok {
+		return 0, false
+	}
+
+	return series.value, true
+}
+
+// Families returns a snapshot of all metrics, sorted by name and labels.
+func (m *MemoryMetrics) Families() []MetricFamily {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	families := make([]MetricFamily, 0, len(m.families))
+	for _, family := range m.families {
+		snapshot := MetricFamily{Name: family.name, Help: family.help, Kind: family.kind}
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			snapshot.Series = append(snapshot.Series, family.series[key].snapshot())
+		}
+
+		families = append(families, snapshot)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+
+	return families
+}
+
+// Reset discards all recorded series, keeping descriptions and buckets.
+func (m *MemoryMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, family := range m.families {
+		family.series = map[string]*memorySeries{}
+	}
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+func (m *MemoryMetrics) WritePrometheus(w io.Writer) error {
+	return WritePrometheus(w, m.Families())
+}
+
+// family returns the family of a metric, creating it if needed. It must be
+// called with the write lock held.
+func (m *MemoryMetrics) family(name string, kind MetricKind) *memoryFamily {
+	family, ok := m.families[name]
+	if !ok {
+		family = &memoryFamily{name: name, kind: kind, series: map[string]*memorySeries{}}
+		m.families[name] = family
+	}
+
+	return family
+}
+
+// get returns the series with the given labels, creating it if needed.
+func (f *memoryFamily) get(labels Labels, bounds []float64) *memorySeries {
+	key := labelsKey(labels)
+	series, ok := f.series[key]
+	if !ok {
+		copied := make(Labels, len(labels))
+		for name, value := range labels {
+			copied[name] = value
+		}
+
+		series = &memorySeries{labels: copied, bounds: bounds}
+		if bounds != nil {
+			series.buckets = make([]uint64, len(bounds))
+			series.exemplars = make([]*Exemplar, len(bounds)+1)
+		}
+		f.series[key] = series
+	}
+
+	return series
+}
+
+// snapshot copies the series, converting histogram buckets to cumulative counts.
+func (s *memorySeries) snapshot() MetricSeries {
+	snapshot := MetricSeries{
+		Labels:   s.labels,
+		Value:    s.value,
+		Exemplar: s.exemplar,
+		Count:    s.count,
+		Sum:      s.sum,
+	}
+
+	if s.bounds != nil {
+		var cumulative uint64
+		for i, bound := range s.bounds {
+			cumulative += s.buckets[i]
+			snapshot.Buckets = append(snapshot.Buckets, Bucket{UpperBound: bound, Count: cumulative, Exemplar: s.exemplars[i]})
+		}
+		snapshot.Buckets = append(snapshot.Buckets, Bucket{UpperBound: math.Inf(1), Count: s.count, Exemplar: s.exemplars[len(s.bounds)]})
+	}
+
+	return snapshot
+}
+
+// WritePrometheus writes metric families in the Prometheus text exposition
+// format, version 0.0.4.
+func WritePrometheus(w io.Writer, families []MetricFamily) error {
+	bw := bufio.NewWriter(w)
+
+	for _, family := range families {
+		if family.Help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.Name, family.Kind)
+
+		for _, series := range family.Series {
+			if family.Kind != KindHistogram {
+				fmt.Fprintf(bw, "%s%s %s\n", family.Name, formatLabels(series.Labels, "", ""), formatFloat(series.Value))
+				continue
+			}
+
+			for _, bucket := range series.Buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", family.Name,
+					formatLabels(series.Labels, "le", formatFloat(bucket.UpperBound)), bucket.Count)
+			}
+			fmt.Fprintf(bw, "%s_sum%s %s\n", family.Name, formatLabels(series.Labels, "", ""), formatFloat(series.Sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", family.Name, formatLabels(series.Labels, "", ""), series.Count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// labelsKey returns a canonical string identifying a label set.
+func labelsKey(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := sortedLabelNames(labels)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+
+	return b.String()
+}
+
+// sortedLabelNames returns the label names in sorted order.
+func sortedLabelNames(labels Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// formatLabels renders a label set in the Prometheus format, optionally with
+// an extra label such as the histogram "le" label.
+func formatLabels(labels Labels, extraName, extraValue string) string {
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range sortedLabelNames(labels) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabelValue(labels[name]))
+	}
+
+	if extraName != "" {
+		if len(labels) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, escapeLabelValue(extraValue))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// formatFloat renders a sample value in the Prometheus format.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines in label values.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp escapes backslashes and newlines in help texts.
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
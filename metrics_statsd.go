@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DogStatsD is a Metrics implementation that writes metrics in the DogStatsD
+// datagram format, one metric per write. Labels are sent as tags; since the
+// format has no escaping, the characters ',', '|', ':' and line breaks in
+// label names and values are replaced with '_'.
+//
+// Example:
+//
+//	statsd, err := middleware.DialDogStatsD("127.0.0.1:8125", "checkout", "env:prod")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer statsd.Close()
+//
+//	chain = chain.With(middleware.WithMetrics(statsd))
+type DogStatsD struct {
+	mu        sync.Mutex
+	w         io.Writer
+	namespace string
+	tags      []string
+	err       error
+}
+
+// NewDogStatsD creates a DogStatsD writing to w. The namespace, if not empty,
+// is prepended to every metric name followed by a dot, and the given tags
+// ("key:value") are added to every metric.
+func NewDogStatsD(w io.Writer, namespace string, tags ...string) *DogStatsD {
+	return &DogStatsD{w: w, namespace: namespace, tags: tags}
+}
+
+// DialDogStatsD creates a DogStatsD sending datagrams to the agent listening
+// on the given UDP address.
+func DialDogStatsD(addr, namespace string, tags ...string) (*DogStatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDogStatsD(conn, namespace, tags...), nil
+}
+
+// AddCounter sends a count metric.
+func (d *DogStatsD) AddCounter(ctx context.Context, name string, labels Labels, delta float64) {
+	d.send(name, delta, "c", labels)
+}
+
+// SetGauge sends a gauge metric.
+func (d *DogStatsD) SetGauge(ctx context.Context, name string, labels Labels, value float64) {
+	d.send(name, value, "g", labels)
+}
+
+// ObserveHistogram sends a histogram metric.
+func (d *DogStatsD) ObserveHistogram(ctx context.Context, name string, labels Labels, value float64) {
+	d.send(name, value, "h", labels)
+}
+
+// Err returns the last error that occurred while writing a metric, if any.
+func (d *DogStatsD) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.err
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (d *DogStatsD) Close() error {
+	if closer, ok := d.w.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// send formats and writes a single datagram.
+func (d *DogStatsD) send(name string, value float64, metricType string, labels Labels) {
+	var b strings.Builder
+	if d.namespace != "" {
+		b.WriteString(d.namespace)
+		b.WriteByte('.')
+	}
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(metricType)
+
+	if len(d.tags) > 0 || len(labels) > 0 {
+		b.WriteString("|#")
+		first := true
+		for _, tag := range d.tags {
+			if !first {
+				b.WriteByte(',')
+			}
+			b.WriteString(tag)
+			first = false
+		}
+		for _, label := range sortedLabelNames(labels) {
+			if !first {
+				b.WriteByte(',')
+			}
+			b.WriteString(statsdTagEscaper.Replace(label))
+			b.WriteByte(':')
+			b.WriteString(statsdTagEscaper.Replace(labels[label]))
+			first = false
+		}
+	}
+	b.WriteByte('\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := io.WriteString(d.w, b.String()); err != nil {
+		d.err = err
+	}
+}
+
+// statsdTagEscaper replaces the characters that delimit tags, tag values and
+// datagrams.
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", ":", "_", "\n", "_", "\r", "_")
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// histogramCount returns the number of observations of a histogram series.
+func histogramCount(metrics *MemoryMetrics, name string, labels Labels) uint64 {
+	for _, family := range metrics.Families() {
+		if family.Name != name {
+			continue
+		}
+		for _, series := range family.Series {
+			if labelsKey(series.Labels) == labelsKey(labels) {
+				return series.Count
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestMetricsRecordPanickingSteps(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	chain := NewNamedChain("orders").
+		AppendNamed("explode", panicking).
+		With(WithMetrics(metrics))
+
+	func() {
+		defer func() { recover() }()
+		chain.Then(context.Background(), nil)
+	}()
+
+	step := Labels{"chain": "orders", "step": "explode"}
+	if count := histogramCount(metrics, MetricStepDuration, step); count != 1 {
+		t.Errorf("step duration observations = %d; want 1", count)
+	}
+	if value, _ := metrics.Value(MetricChainErrors, step); value != 1 {
+		t.Errorf("%s = %v; want 1", MetricChainErrors, value)
+	}
+	if value, _ := metrics.Value(MetricChainPanics, step); value != 1 {
+		t.Errorf("%s = %v; want 1", MetricChainPanics, value)
+	}
+}
+
+func TestMetricsShareInFlightGauge(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	release := make(chan struct{})
+	var entered sync.WaitGroup
+	block := func(ctx context.Context, input any) (context.Context, any, error) {
+		entered.Done()
+		<-release
+		return ctx, input, nil
+	}
+
+	// Replicas of a chain built with their own options, e.g. per handler
+	replicas := []*Chain{
+		NewNamedChain("orders", block).With(WithMetrics(metrics)),
+		NewNamedChain("orders", block).With(WithMetrics(metrics)),
+	}
+
+	var done sync.WaitGroup
+	for _, chain := range replicas {
+		entered.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			chain.Then(context.Background(), nil)
+		}()
+	}
+	entered.Wait()
+
+	inFlight := Labels{"chain": "orders"}
+	if value, _ := metrics.Value(MetricChainInFlight, inFlight); value != 2 {
+		t.Errorf("%s = %v while both replicas run; want 2", MetricChainInFlight, value)
+	}
+
+	close(release)
+	done.Wait()
+	if value, _ := metrics.Value(MetricChainInFlight, inFlight); value != 0 {
+		t.Errorf("%s = %v after both replicas finished; want 0", MetricChainInFlight, value)
+	}
+}
+
+func TestAfterStepObservesPropagatingPanic(t *testing.T) {
+	var stepErr error
+	chain := NewChain(panicking).With(WithHooks(Hooks{
+		AfterStep: func(ctx context.Context, info StepInfo, err error, elapsed time.Duration) {
+			stepErr = err
+		},
+	}))
+
+	func() {
+		defer func() { recover() }()
+		chain.Then(context.Background(), nil)
+	}()
+
+	var panicErr *PanicError
+	if !errors.As(stepErr, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("AfterStep error = %v; want a *PanicError", stepErr)
+	}
+}
+
+func TestDogStatsDEscapesTags(t *testing.T) {
+	var out strings.Builder
+	statsd := NewDogStatsD(&out, "app", "env:prod")
+
+	statsd.AddCounter(context.Background(), "requests", Labels{"step": "a,b|c:d\ne"}, 1)
+
+	if got, want := out.String(), "app.requests:1|c|#env:prod,step:a_b_c_d_e\n"; got != want {
+		t.Errorf("datagram = %q; want %q", got, want)
+	}
+}
@@ -2,10 +2,11 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"reflect"
+	"sync"
 	"time"
-
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 // Define a custom key type to avoid collisions
@@ -33,8 +34,38 @@ type ObservabilityConfig struct {
 	// LogLevel is the log level to use for middleware logs
 	LogLevel slog.Level
 
-	// SkipHealthChecks determines whether to skip logging for health check requests
+	// SkipHealthChecks determines whether to skip logging and tracing for
+	// health check requests. The rest of the chain still runs.
 	SkipHealthChecks bool
+
+	// HealthCheck detects health check requests. When nil,
+	// DefaultHealthCheckMatcher is used.
+	HealthCheck HealthCheckMatcher
+
+	// Sampler decides which requests are logged and kept in traces. Failed
+	// requests are always kept. When nil, every request is kept.
+	Sampler Sampler
+
+	// Tracer is the tracing backend used to create spans. When nil, the tracer
+	// set with SetDefaultTracer is used.
+	Tracer Tracer
+
+	// MetadataExport selects the context metadata that is written as log
+	// attributes and span tags. When nil, no metadata is exported.
+	MetadataExport *MetadataExportPolicy
+
+	// PayloadSize adds size estimates of the input and output to span tags and
+	// log attributes: bytes for strings and byte slices, element counts for
+	// slices and maps
+	PayloadSize bool
+
+	// PayloadJSONSize adds the size of the JSON encoding of the input and
+	// output. It marshals every payload, so it is disabled by default.
+	PayloadJSONSize bool
+
+	// Redactor removes sensitive data from the logged input and output. When
+	// nil, DefaultRedactor is used.
+	Redactor *Redactor
 }
 
 // DefaultObservabilityConfig returns a default configuration for observability middleware
@@ -45,19 +76,26 @@ func DefaultObservabilityConfig() *ObservabilityConfig {
 		LogOutput:        false,
 		LogLevel:         slog.LevelInfo,
 		SkipHealthChecks: true,
+		Redactor:         DefaultRedactor(),
 	}
 }
 
 // Observability creates a middleware function that provides distributed tracing
-// and structured logging capabilities. It integrates with DataDog APM for
-// distributed tracing and uses structured logging for observability.
+// and structured logging capabilities. Spans are reported through the tracer
+// set with SetDefaultTracer, e.g. DataDog APM or OpenTelemetry.
 //
 // The middleware automatically:
-//   - Creates distributed tracing spans
+//   - Creates a distributed tracing span that stays open until the chain
+//     finishes, and a child span for every following step
 //   - Logs request processing with structured data
 //   - Tracks request duration
 //   - Adds observability metadata to context
 //
+// Called outside a chain, e.g. as a plain function, the middleware cannot
+// tell when the request ends: the span stays open until ObservabilityComplete
+// runs on the returned context, or until the caller finishes the span
+// returned by SpanFromContext.
+//
 // Example:
 //
 //	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -91,15 +129,71 @@ func ObservabilityWithConfig(config *ObservabilityConfig) MiddlewareFunc {
 		config.SpanName = "middleware.request"
 	}
 
+	if config.Redactor == nil {
+		config.Redactor = DefaultRedactor()
+	}
+
+	if config.HealthCheck == nil {
+		config.HealthCheck = DefaultHealthCheckMatcher()
+	}
+
 	return func(ctx context.Context, input any) (context.Context, any, error) {
+		// Health checks are flagged so that the completion middleware skips
+		// them as well, but are neither logged nor traced
+		if config.isHealthCheck(ctx, input) {
+			ctx = HealthCheckKey.Set(ctx, true)
+			return ctx, input, nil
+		}
+
 		startTime := time.Now()
 
-		// Create distributed tracing span
-		span := tracer.StartSpan(config.SpanName)
-		defer span.Finish()
+		tracer := config.Tracer
+		if tracer == nil {
+			tracer = DefaultTracer()
+		}
+
+		// Decide whether the request is logged and traced
+		decision := SampleKeep
+		if config.Sampler != nil {
+			decision = config.Sampler.SampleStart(ctx, input)
+		}
 
-		// Add span to context for downstream middleware
-		ctx = tracer.ContextWithSpan(ctx, span)
+		// Create distributed tracing span and add it to the context for
+		// downstream middleware
+		ctx, span := StartSpan(ctx, tracer, config.SpanName)
+
+		obs := &observation{
+			startTime: startTime,
+			span:      span,
+			decision:  decision,
+			sampler:   config.Sampler,
+			logger:    config.Logger,
+			level:     config.LogLevel,
+		}
+		ctx = context.WithValue(ctx, observationKey{}, obs)
+
+		if st := stateFrom(ctx); st != nil {
+			// Keep the span open until the whole chain finished, and run
+			// every following step in a child span
+			st.traceSteps(tracer, span)
+			st.onFinish(func(ctx context.Context, err error) {
+				duration := time.Since(startTime)
+				span.SetTag("duration.ms", float64(duration.Nanoseconds())/1e6)
+				if err != nil {
+					span.SetError(err)
+				}
+
+				obs.resolve(ctx, err, duration)
+				if err != nil {
+					config.logFailure(ctx, err, duration)
+				}
+				span.Finish()
+			})
+		} else {
+			// Outside of a chain the end of the request is only known to
+			// ObservabilityComplete, which finishes the span
+			obs.openSpan = true
+		}
 
 		// Store start time in context
 		ctx = context.WithValue(ctx, StartTimeKey, startTime)
@@ -130,12 +224,28 @@ func ObservabilityWithConfig(config *ObservabilityConfig) MiddlewareFunc {
 		}
 
 		if config.LogInput {
-			logAttrs = append(logAttrs, slog.Any("input", input))
-			// Set trace tag for input (convert to string for safety)
-			span.SetTag("input.type", getTypeName(input))
+			logAttrs = append(logAttrs, slog.Any("input", loggedPayload(config.Redactor, input)))
+
+			// Describe the input type and size in tags and attributes
+			shape := describePayload(input, config.PayloadSize, config.PayloadJSONSize)
+			shape.tag(span, "input")
+			logAttrs = shape.attrs(logAttrs, "input")
 		}
 
-		config.Logger.LogAttrs(ctx, config.LogLevel, "Request started", logAttrs...)
+		// Export selected metadata set by previous middleware
+		logAttrs = exportMetadata(ctx, config.MetadataExport, span, logAttrs)
+
+		switch decision {
+		case SampleKeep:
+			setSamplingPriority(span, true)
+			config.Logger.LogAttrs(ctx, config.LogLevel, "Request started", logAttrs...)
+		case SampleDrop:
+			setSamplingPriority(span, false)
+		case SampleDefer:
+			// Buffer the start entry until the request completes
+			obs.startCtx = ctx
+			obs.startAttrs = logAttrs
+		}
 
 		// Mark context as observed
 		ctx = AddMetadata(ctx, "observed", true)
@@ -158,7 +268,42 @@ func ObservabilityWithConfig(config *ObservabilityConfig) MiddlewareFunc {
 //		middleware.ObservabilityComplete(logger), // Log completion
 //	)
 func ObservabilityComplete(logger *slog.Logger) MiddlewareFunc {
+	config := DefaultObservabilityConfig()
+	config.Logger = logger
+	config.LogOutput = true
+	return ObservabilityCompleteWithConfig(config)
+}
+
+// ObservabilityCompleteWithConfig creates a completion middleware with custom
+// configuration. Use the same configuration as the matching Observability
+// middleware so that both log entries export the same metadata.
+//
+// Example:
+//
+//	config := middleware.DefaultObservabilityConfig()
+//	config.Logger = logger
+//	config.MetadataExport = middleware.DefaultMetadataExportPolicy()
+//
+//	chain := middleware.NewChain(
+//		middleware.ObservabilityWithConfig(config),
+//		businessLogicMiddleware,
+//		middleware.ObservabilityCompleteWithConfig(config),
+//	)
+func ObservabilityCompleteWithConfig(config *ObservabilityConfig) MiddlewareFunc {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	if config.Redactor == nil {
+		config.Redactor = DefaultRedactor()
+	}
+
 	return func(ctx context.Context, input any) (context.Context, any, error) {
+		// Health checks were detected and flagged by Observability
+		if flagged, _ := HealthCheckKey.Get(ctx); flagged && config.SkipHealthChecks {
+			return ctx, input, nil
+		}
+
 		startTimeValue := ctx.Value(StartTimeKey)
 		var duration time.Duration
 
@@ -182,42 +327,135 @@ func ObservabilityComplete(logger *slog.Logger) MiddlewareFunc {
 			logAttrs = append(logAttrs, slog.String("chain_name", chainName))
 		}
 
-		logAttrs = append(logAttrs, slog.Any("output", input))
+		if config.LogOutput {
+			logAttrs = append(logAttrs, slog.Any("output", loggedPayload(config.Redactor, input)))
+		}
+
+		shape := describePayload(input, config.PayloadSize, config.PayloadJSONSize)
+		logAttrs = shape.attrs(logAttrs, "output")
+
+		obs := observationFrom(ctx)
+		if obs != nil && obs.openSpan {
+			defer obs.span.Finish()
+		}
+
+		// Requests dropped by sampling are not logged, unless they fail
+		if obs != nil && !obs.resolve(ctx, nil, duration) {
+			return ctx, input, nil
+		}
+
+		span, hasSpan := rootSpanFromContext(ctx)
+
+		// Export metadata accumulated while processing the request
+		logAttrs = exportMetadata(ctx, config.MetadataExport, span, logAttrs)
 
-		logger.LogAttrs(ctx, slog.LevelInfo, "Request completed", logAttrs...)
+		config.Logger.LogAttrs(ctx, config.LogLevel, "Request completed", logAttrs...)
 
 		// Add span tags for completion
-		if span, ok := tracer.SpanFromContext(ctx); ok {
+		if hasSpan {
 			span.SetTag("duration.ms", float64(duration.Nanoseconds())/1e6)
-			span.SetTag("output.type", getTypeName(input))
+			shape.tag(span, "output")
 		}
 
 		return ctx, input, nil
 	}
 }
 
-// getTypeName safely extracts the type name from any value
-func getTypeName(v any) string {
-	if v == nil {
-		return "nil"
-	}
-
-	switch v.(type) {
-	case string:
-		return "string"
-	case int, int8, int16, int32, int64:
-		return "int"
-	case uint, uint8, uint16, uint32, uint64:
-		return "uint"
-	case float32, float64:
-		return "float"
-	case bool:
-		return "bool"
-	case []byte:
-		return "[]byte"
-	case map[string]any:
-		return "map[string]any"
-	default:
-		return "unknown"
+// logFailure logs a failed request. Failures are logged regardless of sampling.
+func (config *ObservabilityConfig) logFailure(ctx context.Context, err error, duration time.Duration) {
+	logAttrs := []slog.Attr{
+		slog.String("error", err.Error()),
+		slog.Duration("duration", duration),
 	}
+
+	if requestID, _ := GetRequestID(ctx); requestID != "" {
+		logAttrs = append(logAttrs, slog.String("request_id", requestID))
+	}
+
+	if chainName, _ := GetChainName(ctx); chainName != "" {
+		logAttrs = append(logAttrs, slog.String("chain_name", chainName))
+	}
+
+	var chainErr *ChainError
+	if errors.As(err, &chainErr) {
+		logAttrs = append(logAttrs, slog.String("step", chainErr.Step))
+	}
+
+	logAttrs = exportMetadata(ctx, config.MetadataExport, nil, logAttrs)
+
+	config.Logger.LogAttrs(ctx, slog.LevelError, "Request failed", logAttrs...)
+}
+
+// observationKey is the context key of the observation of a request.
+type observationKey struct{}
+
+// observation is the per-request state shared by Observability and
+// ObservabilityComplete, mainly the sampling decision.
+type observation struct {
+	startTime time.Time
+	span      Span
+	sampler   Sampler
+	logger    *slog.Logger
+	level     slog.Level
+
+	// openSpan is set outside of chains, where ObservabilityComplete
+	// finishes the span
+	openSpan bool
+
+	mu         sync.Mutex
+	decision   SamplingDecision
+	startCtx   context.Context // context of the buffered start entry
+	startAttrs []slog.Attr     // start entry buffered while the decision is deferred
+}
+
+// observationFrom returns the observation stored in the context, if any.
+func observationFrom(ctx context.Context) *observation {
+	obs, _ := ctx.Value(observationKey{}).(*observation)
+	return obs
+}
+
+// resolve makes the final sampling decision once the outcome of the request is
+// known, flushing the buffered start entry of kept requests, and reports
+// whether the request is kept. Failed requests are always kept.
+func (o *observation) resolve(ctx context.Context, err error, duration time.Duration) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.decision == SampleDefer {
+		keep := err != nil || o.sampler.SampleEnd(ctx, err, duration)
+		o.decision = decide(keep)
+		setSamplingPriority(o.span, keep)
+
+		if keep {
+			o.logger.LogAttrs(o.startCtx, o.level, "Request started", o.startAttrs...)
+		}
+		o.startCtx, o.startAttrs = nil, nil
+	}
+
+	if err != nil && o.decision == SampleDrop {
+		o.decision = SampleKeep
+		setSamplingPriority(o.span, true)
+	}
+
+	return o.decision == SampleKeep
+}
+
+// rootSpanFromContext returns the span covering the current chain execution,
+// falling back to the active span outside of traced chains.
+func rootSpanFromContext(ctx context.Context) (Span, bool) {
+	if st := stateFrom(ctx); st != nil && st.rootSpan != nil {
+		return st.rootSpan, true
+	}
+
+	return SpanFromContext(ctx)
+}
+
+// loggedPayload returns the value logged for a payload: its redacted copy, or
+// only its type name when it is not data, e.g. a *http.Request.
+func loggedPayload(redactor *Redactor, payload any) any {
+	if payload != nil && isOpaque(reflect.TypeOf(payload)) {
+		return typeName(payload)
+	}
+
+	return redactor.Redact(payload)
 }
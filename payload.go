@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// typeNames caches the descriptions of the types seen by typeName.
+var typeNames sync.Map // reflect.Type -> string
+
+// typeName describes the dynamic type of a value, e.g. "string",
+// "*orders.CreateOrder" or "map[string]interface {}". Descriptions are
+// computed with reflection once per type.
+func typeName(v any) string {
+	if v == nil {
+		return "nil"
+	}
+
+	t := reflect.TypeOf(v)
+	if name, ok := typeNames.Load(t); ok {
+		return name.(string)
+	}
+
+	name := t.String()
+	typeNames.Store(t, name)
+	return name
+}
+
+// maxPayloadDerefs bounds the pointers followed to reach the payload value.
+// Payloads still behind a pointer are not measured: they are self-referential
+// pointer types in practice, which encoding/json cannot encode without
+// overflowing the stack.
+const maxPayloadDerefs = 8
+
+// payloadShape describes a payload for span tags and log attributes.
+// Negative sizes are unknown.
+type payloadShape struct {
+	Type     string
+	Size     int // bytes of strings and byte slices
+	Len      int // elements of slices, arrays and maps
+	JSONSize int // bytes of the JSON encoding
+}
+
+// describePayload computes the shape of a payload. Sizes are only estimated
+// when withSize is set, and the JSON encoding is only measured when
+// withJSONSize is set, since it marshals the whole value. Values that cannot
+// be encoded, e.g. cyclic ones, have an unknown JSON size.
+func describePayload(v any, withSize, withJSONSize bool) payloadShape {
+	shape := payloadShape{Type: typeName(v), Size: -1, Len: -1, JSONSize: -1}
+	if v == nil || !withSize && !withJSONSize {
+		return shape
+	}
+
+	value := reflect.ValueOf(v)
+	for range maxPayloadDerefs {
+		if value.Kind() != reflect.Pointer || value.IsNil() {
+			break
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		return shape
+	}
+
+	if withSize {
+		switch value.Kind() {
+		case reflect.String:
+			shape.Size = value.Len()
+		case reflect.Slice:
+			if value.Type().Elem().Kind() == reflect.Uint8 {
+				shape.Size = value.Len()
+			} else {
+				shape.Len = value.Len()
+			}
+		case reflect.Array, reflect.Map:
+			shape.Len = value.Len()
+		}
+	}
+
+	if withJSONSize {
+		var counter byteCounter
+		if err := json.NewEncoder(&counter).Encode(v); err == nil {
+			shape.JSONSize = int(counter) - 1 // Encode appends a newline
+		}
+	}
+
+	return shape
+}
+
+// tag sets the shape as span tags named after prefix, e.g. "input.type".
+func (shape payloadShape) tag(span Span, prefix string) {
+	span.SetTag(prefix+".type", shape.Type)
+	if shape.Size >= 0 {
+		span.SetTag(prefix+".size", shape.Size)
+	}
+	if shape.Len >= 0 {
+		span.SetTag(prefix+".len", shape.Len)
+	}
+	if shape.JSONSize >= 0 {
+		span.SetTag(prefix+".json_size", shape.JSONSize)
+	}
+}
+
+// attrs appends the shape as log attributes named after prefix, e.g.
+// "input_type".
+func (shape payloadShape) attrs(attrs []slog.Attr, prefix string) []slog.Attr {
+	attrs = append(attrs, slog.String(prefix+"_type", shape.Type))
+	if shape.Size >= 0 {
+		attrs = append(attrs, slog.Int(prefix+"_size", shape.Size))
+	}
+	if shape.Len >= 0 {
+		attrs = append(attrs, slog.Int(prefix+"_len", shape.Len))
+	}
+	if shape.JSONSize >= 0 {
+		attrs = append(attrs, slog.Int(prefix+"_json_size", shape.JSONSize))
+	}
+
+	return attrs
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+// loop is a pointer type pointing to itself.
+type loop *loop
+
+// node is a struct that can form a cycle.
+type node struct {
+	Name string `json:"name"`
+	Next *node  `json:"next,omitempty"`
+}
+
+func TestDescribePayload(t *testing.T) {
+	text := "hello"
+	var self loop
+	self = &self
+	cyclic := &node{Name: "a"}
+	cyclic.Next = cyclic
+
+	tests := []struct {
+		name    string
+		payload any
+		want    payloadShape
+	}{
+		{"nil", nil, payloadShape{Type: "nil", Size: -1, Len: -1, JSONSize: -1}},
+		{"string", "hello", payloadShape{Type: "string", Size: 5, Len: -1, JSONSize: 7}},
+		{"bytes", []byte("hello"), payloadShape{Type: "[]uint8", Size: 5, Len: -1, JSONSize: 10}},
+		{"pointer to string", &text, payloadShape{Type: "*string", Size: 5, Len: -1, JSONSize: 7}},
+		{"slice", []int{1, 2, 3}, payloadShape{Type: "[]int", Size: -1, Len: 3, JSONSize: 7}},
+		{"array", [2]bool{}, payloadShape{Type: "[2]bool", Size: -1, Len: 2, JSONSize: 13}},
+		{"map", map[string]int{"a": 1}, payloadShape{Type: "map[string]int", Size: -1, Len: 1, JSONSize: 7}},
+		{"struct", node{Name: "a"}, payloadShape{Type: "middleware.node", Size: -1, Len: -1, JSONSize: 12}},
+		{"nil pointer", (*node)(nil), payloadShape{Type: "*middleware.node", Size: -1, Len: -1, JSONSize: 4}},
+		{"self-referential pointer", self, payloadShape{Type: "middleware.loop", Size: -1, Len: -1, JSONSize: -1}},
+		{"cyclic struct", cyclic, payloadShape{Type: "*middleware.node", Size: -1, Len: -1, JSONSize: -1}},
+		{"unencodable", func() {}, payloadShape{Type: "func()", Size: -1, Len: -1, JSONSize: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describePayload(tt.payload, true, true); got != tt.want {
+				t.Errorf("describePayload() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribePayloadOnlyMeasuresWhenAsked(t *testing.T) {
+	want := payloadShape{Type: "[]uint8", Size: -1, Len: -1, JSONSize: -1}
+	if got := describePayload([]byte("hello"), false, false); got != want {
+		t.Errorf("describePayload() = %+v; want %+v", got, want)
+	}
+}
+
+func TestPayloadShapeTagsAndAttrs(t *testing.T) {
+	shape := payloadShape{Type: "[]int", Size: -1, Len: 3, JSONSize: 7}
+
+	rec := NewRecordingTracer()
+	_, span := rec.StartSpan(context.Background(), "step")
+	shape.tag(span, "input")
+	span.Finish()
+
+	tags := rec.FinishedSpans()[0].Tags
+	if tags["input.type"] != "[]int" || tags["input.len"] != 3 || tags["input.json_size"] != 7 {
+		t.Errorf("tags = %v; want type, len and json_size", tags)
+	}
+	if _, ok := tags["input.size"]; ok {
+		t.Error("unknown size tagged")
+	}
+
+	attrs := shape.attrs(nil, "output")
+	want := []slog.Attr{slog.String("output_type", "[]int"), slog.Int("output_len", 3), slog.Int("output_json_size", 7)}
+	if len(attrs) != len(want) {
+		t.Fatalf("attrs = %v; want %v", attrs, want)
+	}
+	for i := range want {
+		if !attrs[i].Equal(want[i]) {
+			t.Errorf("attrs[%d] = %v; want %v", i, attrs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package queuemw
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/raywall/go-middleware"
+)
+
+// MessageIDKey holds the ID of the message being processed.
+var MessageIDKey = middleware.NewKey[string]("queue.message_id")
+
+// ConsumerConfig configures a Consumer.
+type ConsumerConfig struct {
+	// Concurrency is the number of messages processed in parallel. When
+	// zero, messages are processed one at a time.
+	Concurrency int
+
+	// MaxDeliveries is the number of deliveries after which a failing message
+	// is not retried anymore. When zero, retryable failures are retried
+	// indefinitely.
+	MaxDeliveries int
+
+	// Retryable reports whether a failure is retried. When nil, every failure
+	// is retried unless middleware.IsPermanent reports it as permanent.
+	Retryable func(err error) bool
+
+	// RetryDelay returns how long a failed message waits before it is
+	// delivered again. When nil, ExponentialBackoff(time.Second, 5*time.Minute)
+	// is used.
+	RetryDelay func(msg Message, err error) time.Duration
+
+	// RequestIDAttribute is the message attribute holding the request ID.
+	// Messages without it use their message ID as the request ID.
+	RequestIDAttribute string
+
+	// ReceiveBackoff is how long the consumer waits after a failed Receive
+	// before trying again. When zero, one second is used.
+	ReceiveBackoff time.Duration
+
+	// Logger reports failures. When nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// DefaultConsumerConfig returns the default Consumer configuration.
+func DefaultConsumerConfig() *ConsumerConfig {
+	return &ConsumerConfig{
+		Concurrency:        1,
+		RetryDelay:         ExponentialBackoff(time.Second, 5*time.Minute),
+		RequestIDAttribute: "request_id",
+		ReceiveBackoff:     time.Second,
+		Logger:             slog.Default(),
+	}
+}
+
+// ExponentialBackoff returns a RetryDelay doubling the delay with every
+// delivery, starting at base and capped at limit.
+func ExponentialBackoff(base, limit time.Duration) func(msg Message, err error) time.Duration {
+	return func(msg Message, err error) time.Duration {
+		delay := base
+		for i := 1; i < msg.DeliveryCount() && delay < limit; i++ {
+			delay *= 2
+		}
+
+		return min(delay, limit)
+	}
+}
+
+// Consumer runs messages pulled from a Source through a chain.
+type Consumer struct {
+	source Source
+	chain  *middleware.CompiledChain
+	config *ConsumerConfig
+	logger *slog.Logger
+}
+
+// NewConsumer creates a Consumer running the messages of source through
+// chain, with the Message as the chain input. When config is nil,
+// DefaultConsumerConfig is used.
+func NewConsumer(source Source, chain *middleware.Chain, config *ConsumerConfig) *Consumer {
+	if config == nil {
+		config = DefaultConsumerConfig()
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Consumer{
+		source: source,
+		chain:  chain.Compile(),
+		config: config,
+		logger: logger,
+	}
+}
+
+// Run receives and processes messages until ctx is done or the source is
+// closed, and waits for the messages being processed. Messages in progress
+// when ctx is done are still completed, with a context that is not canceled.
+//
+// Messages with a partition key are processed by the worker owning that key,
+// one at a time and in delivery order; other messages go to any idle worker.
+// The order is kept across retries only if the source holds back the
+// partition of a nacked message until it is delivered again; see Source.
+//
+// Run returns nil once the source is closed, or the context's error.
+func (c *Consumer) Run(ctx context.Context) error {
+	workers := max(c.config.Concurrency, 1)
+	processCtx := context.WithoutCancel(ctx)
+
+	shared := make(chan Message)
+	partitions := make([]chan Message, workers)
+
+	var wg sync.WaitGroup
+	for i := range partitions {
+		partitions[i] = make(chan Message)
+
+		wg.Add(1)
+		go func(own chan Message) {
+			defer wg.Done()
+			c.work(processCtx, own, shared)
+		}(partitions[i])
+	}
+
+	err := c.dispatch(ctx, shared, partitions)
+
+	close(shared)
+	for _, partition := range partitions {
+		close(partition)
+	}
+	wg.Wait()
+
+	return err
+}
+
+// dispatch receives messages and hands them to the workers.
+func (c *Consumer) dispatch(ctx context.Context, shared chan<- Message, partitions []chan Message) error {
+	backoff := c.config.ReceiveBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for {
+		msg, err := c.source.Receive(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrSourceClosed):
+				return nil
+			case ctx.Err() != nil:
+				return ctx.Err()
+			}
+
+			c.logger.ErrorContext(ctx, "Failed to receive message", slog.String("error", err.Error()))
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		target := shared
+		if key := msg.PartitionKey(); key != "" {
+			target = partitions[partitionIndex(key, len(partitions))]
+		}
+
+		select {
+		case target <- msg:
+		case <-ctx.Done():
+			// Hand the message back for another consumer
+			if err := msg.Nack(context.WithoutCancel(ctx), 0); err != nil {
+				c.logger.ErrorContext(ctx, "Failed to nack message", slog.String("message_id", msg.ID()), slog.String("error", err.Error()))
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// work processes the messages of a worker's partitions and shared messages.
+func (c *Consumer) work(ctx context.Context, own, shared <-chan Message) {
+	for own != nil || shared != nil {
+		select {
+		case msg, ok := <-own:
+			if !ok {
+				own = nil
+				continue
+			}
+			c.process(ctx, msg)
+		case msg, ok := <-shared:
+			if !ok {
+				shared = nil
+				continue
+			}
+			c.process(ctx, msg)
+		}
+	}
+}
+
+// process runs a message through the chain and settles it. Every message runs
+// with its own copy of the metadata, so values set while processing one
+// message are not seen by the others.
+func (c *Consumer) process(ctx context.Context, msg Message) {
+	requestID := msg.ID()
+	if c.config.RequestIDAttribute != "" {
+		if value := msg.Attributes()[c.config.RequestIDAttribute]; value != "" {
+			requestID = value
+		}
+	}
+
+	msgCtx := middleware.SetRequestID(middleware.ForkMetadata(ctx), requestID)
+	msgCtx = MessageIDKey.Set(msgCtx, msg.ID())
+	msgCtx = middleware.DeliveryCountKey.Set(msgCtx, msg.DeliveryCount())
+
+	_, _, err := c.chain.Then(msgCtx, msg)
+	if err == nil {
+		if ackErr := msg.Ack(msgCtx); ackErr != nil {
+			c.logger.ErrorContext(msgCtx, "Failed to ack message", slog.String("message_id", msg.ID()), slog.String("error", ackErr.Error()))
+		}
+		return
+	}
+
+	if c.retryable(msg, err) {
+		delay := c.retryDelay(msg, err)
+		c.logger.WarnContext(msgCtx, "Message failed, retrying",
+			slog.String("message_id", msg.ID()),
+			slog.Int("delivery_count", msg.DeliveryCount()),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()),
+		)
+		if nackErr := msg.Nack(msgCtx, delay); nackErr != nil {
+			c.logger.ErrorContext(msgCtx, "Failed to nack message", slog.String("message_id", msg.ID()), slog.String("error", nackErr.Error()))
+		}
+		return
+	}
+
+	// Permanent failures are acknowledged so they are not redelivered forever
+	c.logger.ErrorContext(msgCtx, "Message failed permanently, dropping",
+		slog.String("message_id", msg.ID()),
+		slog.Int("delivery_count", msg.DeliveryCount()),
+		slog.String("error", err.Error()),
+	)
+	if ackErr := msg.Ack(msgCtx); ackErr != nil {
+		c.logger.ErrorContext(msgCtx, "Failed to ack message", slog.String("message_id", msg.ID()), slog.String("error", ackErr.Error()))
+	}
+}
+
+// retryable reports whether a failed message is delivered again.
+func (c *Consumer) retryable(msg Message, err error) bool {
+	if c.config.MaxDeliveries > 0 && msg.DeliveryCount() >= c.config.MaxDeliveries {
+		return false
+	}
+
+	if c.config.Retryable != nil {
+		return c.config.Retryable(err)
+	}
+
+	return !middleware.IsPermanent(err)
+}
+
+// retryDelay returns how long a failed message waits before redelivery.
+func (c *Consumer) retryDelay(msg Message, err error) time.Duration {
+	if c.config.RetryDelay != nil {
+		return c.config.RetryDelay(msg, err)
+	}
+
+	return ExponentialBackoff(time.Second, 5*time.Minute)(msg, err)
+}
+
+// partitionIndex assigns a partition key to a worker.
+func partitionIndex(key string, workers int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(workers))
+}
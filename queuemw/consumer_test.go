@@ -0,0 +1,225 @@
+package queuemw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raywall/go-middleware"
+)
+
+// runConsumer runs a consumer with the given chain until the closed source is
+// drained.
+func runConsumer(t *testing.T, source *MemorySource, chain *middleware.Chain, config *ConsumerConfig) {
+	t.Helper()
+
+	source.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := NewConsumer(source, chain, config).Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+// testConfig returns a consumer configuration retrying without delay.
+func testConfig(concurrency int) *ConsumerConfig {
+	config := DefaultConsumerConfig()
+	config.Concurrency = concurrency
+	config.RetryDelay = func(Message, error) time.Duration { return time.Millisecond }
+	config.Logger = nil
+	return config
+}
+
+func TestConsumerAcksProcessedMessages(t *testing.T) {
+	type order struct {
+		ID string `json:"id"`
+	}
+
+	source := NewMemorySource()
+	source.Publish("", []byte(`{"id":"1"}`), map[string]string{"request_id": "req-1"})
+	source.Publish("", []byte(`{"id":"2"}`), nil)
+
+	var mu sync.Mutex
+	seen := map[string]string{}
+	chain := middleware.NewChain(DecodeJSON[order](), func(ctx context.Context, input any) (context.Context, any, error) {
+		requestID, _ := middleware.GetRequestID(ctx)
+		mu.Lock()
+		seen[input.(order).ID] = requestID
+		mu.Unlock()
+		return ctx, input, nil
+	})
+
+	runConsumer(t, source, chain, testConfig(2))
+
+	if stats := source.Stats(); stats.Acked != 2 || stats.Nacked != 0 {
+		t.Errorf("Stats() = %+v; want 2 acked, 0 nacked", stats)
+	}
+	if seen["1"] != "req-1" {
+		t.Errorf("request ID of message 1 = %q; want the request_id attribute", seen["1"])
+	}
+	if seen["2"] == "" {
+		t.Error("message 2 has no request ID; want its message ID")
+	}
+}
+
+func TestConsumerRetriesUntilSuccess(t *testing.T) {
+	source := NewMemorySource()
+	source.Publish("", []byte(`{}`), nil)
+
+	var deliveries []int
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		count, _ := middleware.DeliveryCountKey.Get(ctx)
+		deliveries = append(deliveries, count)
+		if count < 3 {
+			return ctx, nil, errors.New("temporary")
+		}
+		return ctx, input, nil
+	})
+
+	runConsumer(t, source, chain, testConfig(1))
+
+	if len(deliveries) != 3 || deliveries[2] != 3 {
+		t.Errorf("deliveries = %v; want [1 2 3]", deliveries)
+	}
+	if stats := source.Stats(); stats.Acked != 1 || stats.Nacked != 2 {
+		t.Errorf("Stats() = %+v; want 1 acked, 2 nacked", stats)
+	}
+}
+
+func TestConsumerDropsPermanentFailures(t *testing.T) {
+	source := NewMemorySource()
+	source.Publish("", []byte(`not json`), nil)
+	source.Publish("", []byte(`{}`), nil)
+
+	attempts := 0
+	chain := middleware.NewChain(DecodeJSON[map[string]any](), func(ctx context.Context, input any) (context.Context, any, error) {
+		attempts++
+		return ctx, nil, errors.New("always failing")
+	})
+
+	config := testConfig(1)
+	config.MaxDeliveries = 2
+	runConsumer(t, source, chain, config)
+
+	if attempts != 2 {
+		t.Errorf("attempts of the retryable message = %d; want MaxDeliveries", attempts)
+	}
+	if stats := source.Stats(); stats.Acked != 2 || stats.Nacked != 1 {
+		t.Errorf("Stats() = %+v; want 2 acked, 1 nacked", stats)
+	}
+}
+
+func TestConsumerKeepsPartitionOrderAcrossRetries(t *testing.T) {
+	source := NewMemorySource()
+	for _, body := range []string{"a1", "b1", "a2", "b2", "a3"} {
+		source.Publish(body[:1], []byte(body), nil)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	failed := map[string]bool{}
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		body := string(input.(Message).Body())
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first message of every partition fails once
+		if body[1] == '1' && !failed[body] {
+			failed[body] = true
+			return ctx, nil, errors.New("temporary")
+		}
+		order = append(order, body)
+		return ctx, input, nil
+	})
+
+	runConsumer(t, source, chain, testConfig(4))
+
+	var a, b []string
+	for _, body := range order {
+		if body[0] == 'a' {
+			a = append(a, body)
+		} else {
+			b = append(b, body)
+		}
+	}
+	if len(a) != 3 || a[0] != "a1" || a[1] != "a2" || a[2] != "a3" {
+		t.Errorf("partition a processed as %v; want [a1 a2 a3]", a)
+	}
+	if len(b) != 2 || b[0] != "b1" || b[1] != "b2" {
+		t.Errorf("partition b processed as %v; want [b1 b2]", b)
+	}
+}
+
+func TestConsumerIsolatesMessageMetadata(t *testing.T) {
+	source := NewMemorySource()
+	for range 20 {
+		source.Publish("", []byte(`{}`), nil)
+	}
+
+	var mu sync.Mutex
+	var leaked int
+	chain := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		msg := input.(Message)
+		if _, ok := middleware.GetMetadata(ctx, "handled"); ok {
+			mu.Lock()
+			leaked++
+			mu.Unlock()
+		}
+		return middleware.AddMetadata(ctx, "handled", msg.ID()), input, nil
+	})
+
+	// A caller-attached container must not be shared by the messages
+	md := middleware.NewMetadata()
+	ctx, cancel := context.WithTimeout(middleware.WithMetadata(context.Background(), md), 5*time.Second)
+	defer cancel()
+
+	source.Close()
+	if err := NewConsumer(source, chain, testConfig(4)).Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if leaked != 0 {
+		t.Errorf("%d messages saw metadata of another message", leaked)
+	}
+	for _, key := range []string{"handled", MessageIDKey.Name(), middleware.DeliveryCountKey.Name()} {
+		if _, ok := md.Get(key); ok {
+			t.Errorf("message metadata %q leaked into the consumer's context", key)
+		}
+	}
+}
+
+func TestDecodeDeadLetterRebuildsMessage(t *testing.T) {
+	sink := middleware.NewMemoryDeadLetterSink()
+	source := NewMemorySource()
+	source.Publish("customer-1", []byte(`{"id":"1"}`), map[string]string{"type": "order"})
+
+	chain := middleware.NewChain(
+		middleware.DeadLetter(sink, nil),
+		func(ctx context.Context, input any) (context.Context, any, error) {
+			return ctx, nil, middleware.Permanent(errors.New("rejected"))
+		},
+	)
+	runConsumer(t, source, chain, testConfig(1))
+
+	records := sink.Records()
+	if len(records) != 1 {
+		t.Fatalf("got %d dead letters; want 1", len(records))
+	}
+
+	var replayed Message
+	replay := middleware.NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		replayed = input.(Message)
+		return ctx, input, nil
+	})
+	if _, _, err := middleware.ReplayDeadLetter(context.Background(), replay, records[0], DecodeDeadLetter); err != nil {
+		t.Fatalf("ReplayDeadLetter() error = %v", err)
+	}
+
+	if string(replayed.Body()) != `{"id":"1"}` || replayed.PartitionKey() != "customer-1" || replayed.Attributes()["type"] != "order" {
+		t.Errorf("replayed message = %q %q %v", replayed.Body(), replayed.PartitionKey(), replayed.Attributes())
+	}
+}
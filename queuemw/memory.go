@@ -0,0 +1,257 @@
+package queuemw
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemorySource is an in-memory Source, mainly for tests. Nacked messages are
+// delivered again after their delay with an incremented delivery count, and
+// messages sharing a partition key are not delivered while an earlier one is
+// in flight or waiting for redelivery.
+type MemorySource struct {
+	mu       sync.Mutex
+	notify   chan struct{}
+	nextID   int
+	pending  []*memoryMessage
+	inFlight map[string]*memoryMessage
+	delayed  int
+	busy     map[string]int
+	acked    int
+	nacked   int
+	closed   bool
+}
+
+// MemorySourceStats reports the state of a MemorySource.
+type MemorySourceStats struct {
+	// Pending is the number of messages waiting to be delivered
+	Pending int
+
+	// InFlight is the number of delivered messages not acked or nacked yet
+	InFlight int
+
+	// Delayed is the number of nacked messages waiting for their delay
+	Delayed int
+
+	// Acked is the number of acknowledged messages
+	Acked int
+
+	// Nacked is the number of negative acknowledgements
+	Nacked int
+}
+
+// NewMemorySource creates an empty MemorySource.
+//
+// Example:
+//
+//	source := queuemw.NewMemorySource()
+//	source.Publish("customer-42", []byte(`{"order_id":"1"}`), nil)
+//	source.Close()
+//
+//	err := queuemw.NewConsumer(source, chain, nil).Run(ctx)
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		notify:   make(chan struct{}),
+		inFlight: make(map[string]*memoryMessage),
+		busy:     make(map[string]int),
+	}
+}
+
+// Publish enqueues a message and returns its ID. Publishing to a closed
+// source panics.
+func (s *MemorySource) Publish(partitionKey string, body []byte, attributes map[string]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		panic("queuemw: publish to closed MemorySource")
+	}
+
+	s.nextID++
+	msg := &memoryMessage{
+		source:       s,
+		id:           strconv.Itoa(s.nextID),
+		body:         body,
+		attributes:   maps.Clone(attributes),
+		partitionKey: partitionKey,
+	}
+	if msg.attributes == nil {
+		msg.attributes = map[string]string{}
+	}
+
+	s.pending = append(s.pending, msg)
+	s.wake()
+
+	return msg.id
+}
+
+// Close stops accepting messages. Receive returns ErrSourceClosed once every
+// published message is acked.
+func (s *MemorySource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.wake()
+}
+
+// Stats returns the current state of the source.
+func (s *MemorySource) Stats() MemorySourceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return MemorySourceStats{
+		Pending:  len(s.pending),
+		InFlight: len(s.inFlight),
+		Delayed:  s.delayed,
+		Acked:    s.acked,
+		Nacked:   s.nacked,
+	}
+}
+
+// Receive implements Source.
+func (s *MemorySource) Receive(ctx context.Context) (Message, error) {
+	for {
+		s.mu.Lock()
+		if msg := s.take(); msg != nil {
+			s.mu.Unlock()
+			return msg, nil
+		}
+
+		if s.closed && len(s.pending) == 0 && len(s.inFlight) == 0 && s.delayed == 0 {
+			s.mu.Unlock()
+			return nil, ErrSourceClosed
+		}
+
+		notify := s.notify
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// take removes the first deliverable message from the pending queue. It must
+// be called with s.mu held.
+func (s *MemorySource) take() *memoryMessage {
+	for i, msg := range s.pending {
+		if msg.partitionKey != "" && s.busy[msg.partitionKey] > 0 {
+			continue
+		}
+
+		s.pending = append(s.pending[:i], s.pending[i+1:]...)
+		msg.deliveries++
+		msg.settled = false
+		s.inFlight[msg.id] = msg
+		if msg.partitionKey != "" {
+			s.busy[msg.partitionKey]++
+		}
+
+		return msg
+	}
+
+	return nil
+}
+
+// wake notifies the goroutines blocked in Receive. It must be called with
+// s.mu held.
+func (s *MemorySource) wake() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// settle acks or nacks an in-flight message.
+func (s *MemorySource) settle(msg *memoryMessage, ack bool, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.settled {
+		return errors.New("queuemw: message " + msg.id + " already settled")
+	}
+	msg.settled = true
+	delete(s.inFlight, msg.id)
+
+	if ack {
+		s.acked++
+		s.release(msg)
+		s.wake()
+		return nil
+	}
+
+	s.nacked++
+	if delay <= 0 {
+		s.requeue(msg)
+		return nil
+	}
+
+	// The partition stays busy until the message is back in the queue, so
+	// that later messages with the same key are not delivered before it
+	s.delayed++
+	time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.delayed--
+		s.requeue(msg)
+	})
+
+	return nil
+}
+
+// requeue puts a nacked message back at the front of the queue. It must be
+// called with s.mu held.
+func (s *MemorySource) requeue(msg *memoryMessage) {
+	s.pending = append([]*memoryMessage{msg}, s.pending...)
+	s.release(msg)
+	s.wake()
+}
+
+// release frees the partition of a settled message. It must be called with
+// s.mu held.
+func (s *MemorySource) release(msg *memoryMessage) {
+	if msg.partitionKey == "" {
+		return
+	}
+
+	if s.busy[msg.partitionKey]--; s.busy[msg.partitionKey] <= 0 {
+		delete(s.busy, msg.partitionKey)
+	}
+}
+
+// memoryMessage is a Message of a MemorySource.
+type memoryMessage struct {
+	source       *MemorySource
+	id           string
+	body         []byte
+	attributes   map[string]string
+	partitionKey string
+	deliveries   int
+	settled      bool
+}
+
+func (m *memoryMessage) ID() string                    { return m.id }
+func (m *memoryMessage) Body() []byte                  { return m.body }
+func (m *memoryMessage) Attributes() map[string]string { return m.attributes }
+func (m *memoryMessage) PartitionKey() string          { return m.partitionKey }
+
+func (m *memoryMessage) DeliveryCount() int {
+	m.source.mu.Lock()
+	defer m.source.mu.Unlock()
+
+	return m.deliveries
+}
+
+func (m *memoryMessage) Ack(ctx context.Context) error {
+	return m.source.settle(m, true, 0)
+}
+
+func (m *memoryMessage) Nack(ctx context.Context, delay time.Duration) error {
+	return m.source.settle(m, false, delay)
+}
@@ -0,0 +1,129 @@
+// Package queuemw runs middleware chains as queue workers.
+//
+// A Consumer pulls messages from a Source and runs each one through a chain,
+// acknowledging messages that succeed and negatively acknowledging, with a
+// delay, those that fail with a retryable error. Messages sharing a partition
+// key are processed in order:
+//
+//	chain := middleware.NewNamedChain("order-events",
+//		queuemw.DecodeJSON[OrderEvent](),
+//		applyOrderEvent,
+//	)
+//
+//	consumer := queuemw.NewConsumer(source, chain, nil)
+//	if err := consumer.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+//		log.Fatal(err)
+//	}
+//
+// Sources adapt brokers such as SQS, Pub/Sub or Kafka; MemorySource keeps
+// messages in memory for tests.
+package queuemw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/raywall/go-middleware"
+)
+
+// ErrSourceClosed is returned by Source.Receive once the source is closed and
+// has no more messages to deliver.
+var ErrSourceClosed = errors.New("queuemw: source closed")
+
+// Message is a message delivered by a Source.
+type Message interface {
+	// ID identifies the message
+	ID() string
+
+	// Body is the message payload
+	Body() []byte
+
+	// Attributes are the message headers
+	Attributes() map[string]string
+
+	// PartitionKey groups messages that must be processed in order. Messages
+	// without a partition key are processed in any order. See Source for the
+	// ordering guarantees a source must give across retries.
+	PartitionKey() string
+
+	// DeliveryCount is how many times the message was delivered, starting at 1
+	DeliveryCount() int
+
+	// Ack acknowledges a processed message, removing it from the source
+	Ack(ctx context.Context) error
+
+	// Nack rejects a message so that it is delivered again after delay
+	Nack(ctx context.Context, delay time.Duration) error
+}
+
+// Source delivers messages to a Consumer.
+//
+// The Consumer processes messages sharing a partition key in the order they
+// are received, but a failed message is only retried when the source delivers
+// it again. To keep the order of a partition across retries, a source must not
+// deliver a message while an earlier message with the same partition key is
+// in flight or waiting for redelivery after a Nack, as FIFO queues such as SQS
+// FIFO queues or Pub/Sub ordering keys do. MemorySource behaves this way.
+type Source interface {
+	// Receive blocks until a message is available. It returns the context's
+	// error when the context is done, and ErrSourceClosed when the source
+	// will not deliver messages anymore.
+	Receive(ctx context.Context) (Message, error)
+}
+
+// DecodeJSON returns a step that decodes the JSON body of a Message input into
+// a value of type T and passes it downstream. Malformed bodies fail with an
+// error wrapping middleware.ErrValidation, which is not retried.
+//
+// Example:
+//
+//	chain := middleware.NewChain(queuemw.DecodeJSON[OrderEvent](), applyOrderEvent)
+func DecodeJSON[T any]() middleware.MiddlewareFunc {
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		msg, ok := input.(Message)
+		if !ok {
+			return ctx, nil, fmt.Errorf("queuemw: DecodeJSON requires a queuemw.Message input, got %T", input)
+		}
+
+		var value T
+		if err := json.Unmarshal(msg.Body(), &value); err != nil {
+			return ctx, nil, fmt.Errorf("%w: invalid message body: %w", middleware.ErrValidation, err)
+		}
+
+		return ctx, value, nil
+	}
+}
+
+// DecodeDeadLetter rebuilds the Message recorded by middleware.DeadLetter, to
+// replay it with middleware.ReplayDeadLetter. The rebuilt message reports a
+// single delivery, and its Ack and Nack do nothing.
+//
+// Example:
+//
+//	errs := middleware.ReplayDeadLetters(ctx, chain, records, queuemw.DecodeDeadLetter)
+func DecodeDeadLetter(record middleware.DeadLetterRecord) (any, error) {
+	recorded, err := middleware.DeadLetterInput[middleware.DeadLetterMessage](record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayedMessage{recorded: recorded}, nil
+}
+
+// replayedMessage is a Message rebuilt from a dead letter.
+type replayedMessage struct {
+	recorded middleware.DeadLetterMessage
+}
+
+func (m *replayedMessage) ID() string                    { return m.recorded.ID }
+func (m *replayedMessage) Body() []byte                  { return m.recorded.Body }
+func (m *replayedMessage) Attributes() map[string]string { return m.recorded.Attributes }
+func (m *replayedMessage) PartitionKey() string          { return m.recorded.PartitionKey }
+func (m *replayedMessage) DeliveryCount() int            { return 1 }
+
+func (m *replayedMessage) Ack(ctx context.Context) error { return nil }
+
+func (m *replayedMessage) Nack(ctx context.Context, delay time.Duration) error { return nil }
@@ -0,0 +1,558 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactStrategy defines how a sensitive value is replaced.
+type RedactStrategy int
+
+const (
+	// RedactReplace replaces the value with RedactedPlaceholder
+	RedactReplace RedactStrategy = iota
+
+	// RedactMask masks all but the last four characters of the value
+	RedactMask
+
+	// RedactHash replaces the value with a truncated salted SHA-256 hash, so
+	// equal values can still be correlated
+	RedactHash
+)
+
+// RedactedPlaceholder is the replacement used by RedactReplace.
+const RedactedPlaceholder = "[REDACTED]"
+
+// Detector finds sensitive data inside string values, such as e-mail
+// addresses embedded in free text.
+type Detector struct {
+	// Name identifies the detector
+	Name string
+
+	// Pattern matches the sensitive data
+	Pattern *regexp.Regexp
+
+	// Validate optionally confirms a match, e.g. with a checksum
+	Validate func(match string) bool
+}
+
+// Built-in detectors
+var (
+	// EmailDetector finds e-mail addresses
+	EmailDetector = Detector{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	}
+
+	// CardNumberDetector finds payment card numbers that pass the Luhn check
+	CardNumberDetector = Detector{
+		Name:     "card_number",
+		Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: luhnValid,
+	}
+
+	// JWTDetector finds JSON Web Tokens
+	JWTDetector = Detector{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+	}
+)
+
+// Redactor removes sensitive data from values before they are logged.
+// A value is considered sensitive when:
+//   - it is a struct field tagged with `mw:"redact"`, optionally with a
+//     strategy such as `mw:"redact,mask"` or `mw:"redact,hash"`
+//   - its map key or struct field name matches one of KeyPatterns, as it is
+//     or in snake case, e.g. "AccessToken" as "access_token"
+//   - it is part of a string matched by one of Detectors
+//
+// Unexported struct fields cannot be inspected, so they are zeroed in the
+// copy, except in types that marshal themselves, such as time.Time.
+//
+// Values that are not data, such as contexts, readers, writers, HTTP requests,
+// functions and channels, are not inspected: they are kept as they are.
+//
+// Redact returns a redacted copy; the original value is never modified.
+// A Redactor must not be modified after first use.
+//
+// Example:
+//
+//	type Login struct {
+//		User     string
+//		Password string `mw:"redact"`
+//		Card     string `mw:"redact,mask"`
+//	}
+//
+//	redactor := middleware.DefaultRedactor()
+//	logger.Info("login", slog.Any("input", redactor.Redact(login)))
+type Redactor struct {
+	// KeyPatterns match map keys and struct field names of sensitive values
+	KeyPatterns []*regexp.Regexp
+
+	// Detectors find sensitive data inside strings
+	Detectors []Detector
+
+	// Strategy is applied to values matched by KeyPatterns and Detectors,
+	// and to tagged fields without an explicit strategy
+	Strategy RedactStrategy
+
+	// HashSalt is mixed into RedactHash hashes
+	HashSalt string
+
+	// MaxDepth limits how deep nested values are inspected. Deeper values are
+	// replaced entirely. When zero, 16 is used.
+	MaxDepth int
+
+	// fields caches the field plans of struct types
+	fields sync.Map
+}
+
+// DefaultRedactor returns a Redactor that matches common credential key names
+// (passwords, secrets, tokens, API keys, authorization headers, cookies) and
+// detects e-mail addresses, card numbers and JWTs. Key names only match as
+// whole words separated by "_", "-" or ".", so "access_token" and "X-Api-Key"
+// are redacted but "compass" and "tokenizer_version" are not.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		KeyPatterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(^|[_.-])(pass(word|wd|phrase)?|secrets?|tokens?|api[_-]?keys?|authorization|cookies?|credentials?|private[_-]?keys?|cvv)($|[_.-])`),
+		},
+		Detectors: []Detector{EmailDetector, CardNumberDetector, JWTDetector},
+		Strategy:  RedactReplace,
+	}
+}
+
+// Redaction creates a middleware that redacts its input with the given
+// Redactor and passes the redacted copy downstream. It is useful as the last
+// step of chains whose output leaves the service, e.g. to mask card numbers.
+//
+// Example:
+//
+//	chain := middleware.NewChain(
+//		loadCustomer,
+//		middleware.Redaction(middleware.DefaultRedactor()),
+//	)
+func Redaction(redactor *Redactor) MiddlewareFunc {
+	if redactor == nil {
+		redactor = DefaultRedactor()
+	}
+
+	return func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, redactor.Redact(input), nil
+	}
+}
+
+// Redact returns a copy of v with all sensitive data redacted. Structs, maps,
+// slices and pointers keep their types; errors are converted to their redacted
+// message. A nil Redactor returns v unchanged.
+func (r *Redactor) Redact(v any) any {
+	if r == nil || v == nil {
+		return v
+	}
+
+	if err, ok := v.(error); ok {
+		return r.redactString(err.Error())
+	}
+
+	redacted := r.redactValue(reflect.ValueOf(v), 0)
+	if !redacted.IsValid() {
+		return nil
+	}
+
+	return redacted.Interface()
+}
+
+// RedactString applies the detectors to a string.
+func (r *Redactor) RedactString(s string) string {
+	if r == nil {
+		return s
+	}
+
+	return r.redactString(s)
+}
+
+// redactValue returns a redacted copy of v with the same type.
+func (r *Redactor) redactValue(v reflect.Value, depth int) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+
+	maxDepth := r.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = 16
+	}
+
+	if depth > maxDepth {
+		return r.replaceValue(v, r.Strategy)
+	}
+
+	if isOpaque(v.Type()) {
+		return v
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		redacted := r.redactString(v.String())
+		if redacted == v.String() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.SetString(redacted)
+		return out
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			out := reflect.New(v.Type()).Elem()
+			out.SetBytes([]byte(r.redactString(string(v.Bytes()))))
+			return out
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(r.redactValue(v.Index(i), depth+1))
+		}
+		return out
+
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(r.redactValue(v.Index(i), depth+1))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, value := iter.Key(), iter.Value()
+			if r.matchesMapKey(key) {
+				out.SetMapIndex(key, r.replaceValue(value, r.Strategy))
+				continue
+			}
+			out.SetMapIndex(key, r.redactValue(value, depth+1))
+		}
+		return out
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(r.redactValue(v.Elem(), depth+1))
+		return out
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		if err, ok := v.Interface().(error); ok {
+			// Errors are replaced by their redacted message, as a string when
+			// the interface allows it and as a plain error otherwise
+			message := r.redactString(err.Error())
+			if stringType.AssignableTo(v.Type()) {
+				out.Set(reflect.ValueOf(message))
+			} else if message != err.Error() && errorType.AssignableTo(v.Type()) {
+				out.Set(reflect.ValueOf(errors.New(message)))
+			} else {
+				out.Set(v)
+			}
+			return out
+		}
+		out.Set(r.redactValue(v.Elem(), depth+1))
+		return out
+
+	case reflect.Struct:
+		return r.redactStruct(v, depth)
+
+	default:
+		return v
+	}
+}
+
+// redactStruct returns a redacted copy of a struct. Unexported fields cannot
+// be inspected and are left zero, unless the type marshals itself: such
+// values, e.g. time.Time, are copied as they are.
+func (r *Redactor) redactStruct(v reflect.Value, depth int) reflect.Value {
+	if marshalsItself(v.Type()) {
+		return v
+	}
+
+	out := reflect.New(v.Type()).Elem()
+
+	for _, field := range r.structFields(v.Type()) {
+		value := v.Field(field.index)
+		if field.redact {
+			out.Field(field.index).Set(r.replaceValue(value, field.strategy))
+			continue
+		}
+		out.Field(field.index).Set(r.redactValue(value, depth+1))
+	}
+
+	return out
+}
+
+// fieldPlan describes how an exported struct field is redacted.
+type fieldPlan struct {
+	index    int
+	redact   bool
+	strategy RedactStrategy
+}
+
+// structFields returns the redaction plan of the exported fields of a struct
+// type, computing it once per type.
+func (r *Redactor) structFields(t reflect.Type) []fieldPlan {
+	if cached, ok := r.fields.Load(t); ok {
+		return cached.([]fieldPlan)
+	}
+
+	var plans []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		plan := fieldPlan{index: i, strategy: r.Strategy}
+
+		if tag, ok := field.Tag.Lookup("mw"); ok {
+			options := strings.Split(tag, ",")
+			if options[0] == "redact" {
+				plan.redact = true
+				if len(options) > 1 {
+					plan.strategy = parseRedactStrategy(options[1], r.Strategy)
+				}
+			}
+		}
+
+		if !plan.redact {
+			jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+			plan.redact = r.matchesKey(field.Name) || (jsonName != "" && r.matchesKey(jsonName))
+		}
+
+		plans = append(plans, plan)
+	}
+
+	r.fields.Store(t, plans)
+	return plans
+}
+
+// replaceValue returns the replacement of a sensitive value with the same
+// type. Strings are replaced according to the strategy; other values are
+// replaced by their zero value, or by a string when the type allows it.
+func (r *Redactor) replaceValue(v reflect.Value, strategy RedactStrategy) reflect.Value {
+	out := reflect.New(v.Type()).Elem()
+
+	switch {
+	case v.Kind() == reflect.String:
+		out.SetString(r.replaceString(v.String(), strategy))
+	case v.Kind() == reflect.Interface && !v.IsNil() && stringType.AssignableTo(v.Type()):
+		out.Set(reflect.ValueOf(r.replaceString(stringify(v.Elem()), strategy)))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		out.SetBytes([]byte(r.replaceString(string(v.Bytes()), strategy)))
+	}
+
+	return out
+}
+
+// redactString replaces the parts of s matched by the detectors.
+func (r *Redactor) redactString(s string) string {
+	for _, detector := range r.Detectors {
+		if detector.Pattern == nil {
+			continue
+		}
+		s = detector.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if detector.Validate != nil && !detector.Validate(match) {
+				return match
+			}
+			return r.replaceString(match, r.Strategy)
+		})
+	}
+
+	return s
+}
+
+// replaceString applies a strategy to a sensitive string.
+func (r *Redactor) replaceString(s string, strategy RedactStrategy) string {
+	switch strategy {
+	case RedactMask:
+		runes := []rune(s)
+		keep := 0
+		if len(runes) > 8 {
+			keep = 4
+		}
+		return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+	case RedactHash:
+		sum := sha256.Sum256([]byte(r.HashSalt + s))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	default:
+		return RedactedPlaceholder
+	}
+}
+
+// matchesMapKey reports whether a map key denotes a sensitive value. Keys of
+// interface type, e.g. in map[any]any, are matched by their dynamic value.
+func (r *Redactor) matchesMapKey(key reflect.Value) bool {
+	if key.Kind() == reflect.Interface && !key.IsNil() {
+		key = key.Elem()
+	}
+
+	return key.Kind() == reflect.String && r.matchesKey(key.String())
+}
+
+// matchesKey reports whether a key or field name denotes a sensitive value.
+// Camel case names are also matched in snake case.
+func (r *Redactor) matchesKey(key string) bool {
+	snake := snakeCase(key)
+	for _, pattern := range r.KeyPatterns {
+		if pattern.MatchString(key) || snake != key && pattern.MatchString(snake) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snakeCase converts a camel case name to lower snake case, e.g. "APIKey" to
+// "api_key". Other names are returned as they are.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	changed := false
+	for i, c := range runes {
+		if c < 'A' || c > 'Z' {
+			b.WriteRune(c)
+			continue
+		}
+
+		// A word starts at an upper case letter that follows a lower case
+		// letter or a digit, or that ends an acronym, as the K of "APIKey"
+		if i > 0 {
+			previous := runes[i-1]
+			lowerBefore := previous >= 'a' && previous <= 'z' || previous >= '0' && previous <= '9'
+			acronymEnd := previous >= 'A' && previous <= 'Z' && i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z'
+			if lowerBefore || acronymEnd {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(c + 'a' - 'A')
+		changed = true
+	}
+
+	if !changed {
+		return name
+	}
+	return b.String()
+}
+
+// parseRedactStrategy parses the strategy option of a struct tag.
+func parseRedactStrategy(option string, fallback RedactStrategy) RedactStrategy {
+	switch strings.TrimSpace(option) {
+	case "mask":
+		return RedactMask
+	case "hash":
+		return RedactHash
+	case "replace":
+		return RedactReplace
+	default:
+		return fallback
+	}
+}
+
+// isOpaque reports whether values of a type are not data: functions,
+// channels, contexts, readers, writers and HTTP requests. Copying them is
+// expensive or unsafe and they hold no loggable fields.
+func isOpaque(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return true
+	}
+
+	if t == httpRequestType || t.Kind() == reflect.Pointer && t.Elem() == httpRequestType {
+		return true
+	}
+
+	if t.Kind() == reflect.Interface {
+		// Interfaces are inspected through their dynamic value
+		return false
+	}
+	return t.Implements(contextType) || t.Implements(readerType) || t.Implements(writerType)
+}
+
+var (
+	httpRequestType = reflect.TypeOf(http.Request{})
+	contextType     = reflect.TypeOf((*context.Context)(nil)).Elem()
+	readerType      = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	writerType      = reflect.TypeOf((*io.Writer)(nil)).Elem()
+)
+
+// stringType and errorType are the types of strings and error interfaces.
+var (
+	stringType = reflect.TypeOf("")
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// marshalsItself reports whether values of a type choose their own encoding,
+// in which case their unexported fields are kept. The method set of the
+// pointer type includes the methods with value receivers.
+func marshalsItself(t reflect.Type) bool {
+	pointer := reflect.PointerTo(t)
+	return pointer.Implements(jsonMarshalerType) || pointer.Implements(textMarshalerType)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// stringify converts a value to a string for masking and hashing.
+func stringify(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum.
+func luhnValid(s string) bool {
+	sum := 0
+	double := false
+	digits := 0
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
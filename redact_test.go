@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type credentials struct {
+	User     string
+	password string
+}
+
+type session struct {
+	Name    string
+	Created time.Time
+	creds   credentials
+}
+
+func TestRedactZeroesUnexportedFields(t *testing.T) {
+	created := time.Date(2025, 10, 9, 8, 53, 20, 0, time.UTC)
+	input := session{
+		Name:    "alice",
+		Created: created,
+		creds:   credentials{User: "alice", password: "hunter2"},
+	}
+
+	redacted := DefaultRedactor().Redact(input).(session)
+
+	if redacted.creds != (credentials{}) {
+		t.Errorf("unexported field = %+v, want it zeroed", redacted.creds)
+	}
+	if !redacted.Created.Equal(created) {
+		t.Errorf("time = %v, want %v kept", redacted.Created, created)
+	}
+	if redacted.Name != "alice" {
+		t.Errorf("name = %q, want alice", redacted.Name)
+	}
+	if input.creds.password != "hunter2" {
+		t.Error("original value was modified")
+	}
+}
+
+func TestRedactMatchesInterfaceMapKeys(t *testing.T) {
+	input := map[any]any{
+		"password": "hunter2",
+		"user":     "alice",
+		7:          "seven",
+	}
+
+	redacted := DefaultRedactor().Redact(input).(map[any]any)
+
+	if redacted["password"] != RedactedPlaceholder {
+		t.Errorf("password = %v, want %q", redacted["password"], RedactedPlaceholder)
+	}
+	if redacted["user"] != "alice" || redacted[7] != "seven" {
+		t.Errorf("redacted = %v, want other entries unchanged", redacted)
+	}
+}
+
+func TestObservabilityRedactsByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	observe := ObservabilityWithConfig(&ObservabilityConfig{
+		Logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		LogInput: true,
+	})
+
+	if _, _, err := observe(context.Background(), map[string]string{"api_key": "sk-live-123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "sk-live-123") {
+		t.Errorf("log contains the API key: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), RedactedPlaceholder) {
+		t.Errorf("log does not contain %q: %s", RedactedPlaceholder, buf.String())
+	}
+}
+
+func TestDefaultRedactorKeyPatterns(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"Password", true},
+		{"passwd", true},
+		{"db_pass", true},
+		{"client_secret", true},
+		{"access-token", true},
+		{"AccessToken", true},
+		{"api_key", true},
+		{"X-Api-Key", true},
+		{"APIKey", true},
+		{"apikey", true},
+		{"http.authorization", true},
+		{"Set-Cookie", true},
+		{"credentials", true},
+		{"PrivateKey", true},
+		{"cvv", true},
+		{"compass", false},
+		{"passenger", false},
+		{"bypass", false},
+		{"tokenizer_version", false},
+		{"TokenizerVersion", false},
+		{"secretary", false},
+		{"keyboard", false},
+		{"user", false},
+	}
+
+	redactor := DefaultRedactor()
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := redactor.matchesKey(tt.key); got != tt.want {
+				t.Errorf("matchesKey(%q) = %v; want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"AccessToken": "access_token",
+		"APIKey":      "api_key",
+		"userID":      "user_id",
+		"Key2Value":   "key2_value",
+		"api_key":     "api_key",
+		"X-Api-Key":   "x-api-key",
+	}
+
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestRedactKeepsValuesThatAreNotData(t *testing.T) {
+	type envelope struct {
+		Request *http.Request
+		Done    chan struct{}
+		Token   string
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/orders?token=abc", nil)
+	input := envelope{Request: request, Done: make(chan struct{}), Token: "abc"}
+
+	redacted := DefaultRedactor().Redact(input).(envelope)
+
+	if redacted.Request != request || redacted.Done != input.Done {
+		t.Error("request or channel copied; want them kept as they are")
+	}
+	if redacted.Token != RedactedPlaceholder {
+		t.Errorf("token = %q; want %q", redacted.Token, RedactedPlaceholder)
+	}
+	if got := DefaultRedactor().Redact(request); got != request {
+		t.Errorf("Redact(request) = %v; want the request itself", got)
+	}
+}
+
+func TestObservabilityLogsTypeOfValuesThatAreNotData(t *testing.T) {
+	var buf bytes.Buffer
+	observe := ObservabilityWithConfig(&ObservabilityConfig{
+		Logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		LogInput: true,
+	})
+
+	request, _ := http.NewRequest(http.MethodGet, "/orders", nil)
+	if _, _, err := observe(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"input":"*http.Request"`) {
+		t.Errorf("log = %s; want the input logged as its type name", buf.String())
+	}
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// SamplingDecision is the outcome of sampling a request when it starts.
+type SamplingDecision int
+
+const (
+	// SampleDrop drops the request's logs and trace, unless it fails
+	SampleDrop SamplingDecision = iota
+
+	// SampleKeep keeps the request's logs and trace
+	SampleKeep
+
+	// SampleDefer postpones the decision until the request completes
+	SampleDefer
+)
+
+// Sampler decides which requests the observability middleware logs and
+// traces. Failed requests are always kept, whatever the decision.
+//
+// The decision is propagated to the root span through SamplingPrioritySetter,
+// e.g. as the DataDog sampling priority.
+type Sampler interface {
+	// SampleStart is called when a request starts
+	SampleStart(ctx context.Context, input any) SamplingDecision
+
+	// SampleEnd is called when a request whose start decision was SampleDefer
+	// completes, and reports whether it is kept
+	SampleEnd(ctx context.Context, err error, duration time.Duration) bool
+}
+
+// SamplingPrioritySetter is implemented by spans whose backend supports
+// keeping or dropping a whole trace.
+type SamplingPrioritySetter interface {
+	SetSamplingPriority(keep bool)
+}
+
+// RatioSampler keeps a random fraction of requests, between 0 and 1.
+//
+// Example:
+//
+//	config.Sampler = middleware.RatioSampler(0.1) // keep 10%
+func RatioSampler(ratio float64) Sampler {
+	return ratioSampler{ratio: ratio}
+}
+
+type ratioSampler struct {
+	ratio float64
+}
+
+func (s ratioSampler) SampleStart(ctx context.Context, input any) SamplingDecision {
+	return decide(rand.Float64() < s.ratio)
+}
+
+func (s ratioSampler) SampleEnd(ctx context.Context, err error, duration time.Duration) bool {
+	return err != nil
+}
+
+// RequestIDSampler keeps a fraction of requests chosen deterministically from
+// their request ID, so every service sampling with the same ratio makes the
+// same decision for a request. Requests without an ID are sampled randomly.
+//
+// Example:
+//
+//	config.Sampler = middleware.RequestIDSampler(0.25)
+func RequestIDSampler(ratio float64) Sampler {
+	return requestIDSampler{ratio: ratio}
+}
+
+type requestIDSampler struct {
+	ratio float64
+}
+
+func (s requestIDSampler) SampleStart(ctx context.Context, input any) SamplingDecision {
+	requestID, ok := GetRequestID(ctx)
+	if !ok || requestID == "" {
+		return decide(rand.Float64() < s.ratio)
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(requestID))
+	return decide(float64(hash.Sum64()) < s.ratio*math.MaxUint64)
+}
+
+func (s requestIDSampler) SampleEnd(ctx context.Context, err error, duration time.Duration) bool {
+	return err != nil
+}
+
+// KeyRateSampler keeps at most perSecond requests per second for every key
+// returned by keyFunc, e.g. per tenant or per route, so that noisy keys do not
+// crowd out quiet ones. Keys idle for a second are forgotten, since their
+// budget is full again, so short-lived keys do not accumulate.
+//
+// Example:
+//
+//	config.Sampler = middleware.KeyRateSampler(5, func(ctx context.Context, input any) string {
+//		tenant, _ := middleware.GetMetadataString(ctx, "tenant_id")
+//		return tenant
+//	})
+func KeyRateSampler(perSecond float64, keyFunc func(ctx context.Context, input any) string) Sampler {
+	return &keyRateSampler{
+		perSecond: perSecond,
+		keyFunc:   keyFunc,
+		buckets:   map[string]*rateBucket{},
+		now:       time.Now,
+	}
+}
+
+// rateBucketIdle is how long a bucket takes to refill completely. Idle buckets
+// are indistinguishable from new ones and can be dropped.
+const rateBucketIdle = time.Second
+
+type keyRateSampler struct {
+	perSecond float64
+	keyFunc   func(ctx context.Context, input any) string
+	now       func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+// rateBucket is a token bucket refilled continuously at the sampler's rate.
+type rateBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (s *keyRateSampler) SampleStart(ctx context.Context, input any) SamplingDecision {
+	key := s.keyFunc(ctx, input)
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Sweeping at most once per refill period keeps the cost amortized
+	if now.Sub(s.lastSweep) >= rateBucketIdle {
+		s.sweep(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: s.perSecond, lastRefill: now}
+		s.buckets[key] = bucket
+	}
+
+	// Refill tokens based on elapsed time, up to one second of budget
+	bucket.tokens = math.Min(s.perSecond, bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*s.perSecond)
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
+		return SampleDrop
+	}
+
+	bucket.tokens--
+	return SampleKeep
+}
+
+func (s *keyRateSampler) SampleEnd(ctx context.Context, err error, duration time.Duration) bool {
+	return err != nil
+}
+
+// sweep drops the buckets that have been idle long enough to be full. It must
+// be called with the mutex held.
+func (s *keyRateSampler) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.lastRefill) >= rateBucketIdle {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// TailSampler defers the decision until requests complete. It always keeps
+// failed requests and requests slower than slowThreshold, and a random
+// fraction of the remaining ones. Logs of a request are buffered until the
+// decision is made.
+//
+// Example:
+//
+//	// Keep errors, requests slower than 500ms, and 1% of the rest
+//	config.Sampler = middleware.TailSampler(500*time.Millisecond, 0.01)
+func TailSampler(slowThreshold time.Duration, ratio float64) Sampler {
+	return tailSampler{slowThreshold: slowThreshold, ratio: ratio}
+}
+
+type tailSampler struct {
+	slowThreshold time.Duration
+	ratio         float64
+}
+
+func (s tailSampler) SampleStart(ctx context.Context, input any) SamplingDecision {
+	return SampleDefer
+}
+
+func (s tailSampler) SampleEnd(ctx context.Context, err error, duration time.Duration) bool {
+	if err != nil {
+		return true
+	}
+
+	if s.slowThreshold > 0 && duration >= s.slowThreshold {
+		return true
+	}
+
+	return rand.Float64() < s.ratio
+}
+
+// decide converts a boolean into a SamplingDecision.
+func decide(keep bool) SamplingDecision {
+	if keep {
+		return SampleKeep
+	}
+
+	return SampleDrop
+}
+
+// setSamplingPriority propagates a sampling decision to a span, if supported.
+func setSamplingPriority(span Span, keep bool) {
+	if setter, ok := span.(SamplingPrioritySetter); ok {
+		setter.SetSamplingPriority(keep)
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tenantKey reads the sampling key from the input.
+func tenantKey(ctx context.Context, input any) string {
+	return input.(string)
+}
+
+func TestKeyRateSamplerLimitsEveryKey(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sampler := KeyRateSampler(2, tenantKey).(*keyRateSampler)
+	sampler.now = clock.Now
+
+	var decisions []SamplingDecision
+	for _, tenant := range []string{"a", "a", "a", "b"} {
+		decisions = append(decisions, sampler.SampleStart(context.Background(), tenant))
+	}
+	want := []SamplingDecision{SampleKeep, SampleKeep, SampleDrop, SampleKeep}
+	if !slices.Equal(decisions, want) {
+		t.Errorf("decisions = %v, want %v", decisions, want)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if got := sampler.SampleStart(context.Background(), "a"); got != SampleKeep {
+		t.Errorf("after refill: decision = %v, want keep", got)
+	}
+}
+
+func TestKeyRateSamplerForgetsIdleKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sampler := KeyRateSampler(1, tenantKey).(*keyRateSampler)
+	sampler.now = clock.Now
+
+	for i := range 1000 {
+		sampler.SampleStart(context.Background(), fmt.Sprintf("tenant-%d", i))
+	}
+	clock.Advance(rateBucketIdle)
+	sampler.SampleStart(context.Background(), "tenant-0")
+
+	if got := len(sampler.buckets); got != 1 {
+		t.Errorf("kept %d buckets, want only the active one", got)
+	}
+
+	// A forgotten key starts with a full budget, as if it was never seen
+	if got := sampler.SampleStart(context.Background(), "tenant-1"); got != SampleKeep {
+		t.Errorf("decision for a forgotten key = %v, want keep", got)
+	}
+}
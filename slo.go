@@ -0,0 +1,529 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the metrics published by SLOTracker
+const (
+	// MetricSLOEvents counts executions measured by an SLO, by outcome
+	// ("good" or "bad")
+	MetricSLOEvents = "middleware_slo_events_total"
+
+	// MetricSLOBurnRate is the error budget burn rate of an SLO per window
+	MetricSLOBurnRate = "middleware_slo_burn_rate"
+
+	// MetricSLOErrorBudgetRemaining is the fraction of the error budget of
+	// the current period that is left, negative once it is exhausted
+	MetricSLOErrorBudgetRemaining = "middleware_slo_error_budget_remaining"
+)
+
+// sloBucketWidth is the resolution of the burn rate windows.
+const sloBucketWidth = time.Minute
+
+// sloEvaluationInterval limits how often alerts are evaluated and metrics
+// published, since both scan the windows.
+const sloEvaluationInterval = time.Second
+
+// SLO defines a service level objective for a chain: the fraction of
+// executions that must be good. An execution is good when it succeeds and,
+// if LatencyThreshold is set, completes within it.
+type SLO struct {
+	// Name identifies the SLO, e.g. "orders-latency"
+	Name string
+
+	// Chain is the name of the measured chain. When empty, the name of the
+	// chain the SLO is installed on is used.
+	Chain string
+
+	// Objective is the target fraction of good executions, e.g. 0.999. It
+	// must be greater than 0 and less than 1, since an objective of 1 leaves
+	// no error budget to burn.
+	Objective float64
+
+	// LatencyThreshold is the latency above which executions are bad. When
+	// zero, only failures are bad.
+	LatencyThreshold time.Duration
+
+	// Period is the error budget period. The budget is reset at the start of
+	// every period. When zero, 30 days is used.
+	Period time.Duration
+
+	// Windows are the burn rate alert rules. When nil,
+	// DefaultBurnRateWindows is used.
+	Windows []BurnRateWindow
+
+	// OnAlert is called when a burn rate alert starts firing and when it
+	// resolves. Alerts are evaluated as executions are measured, when the
+	// state is read with Status or Statuses, and by SLOTracker.Run, so that
+	// they also resolve once traffic stops.
+	OnAlert func(ctx context.Context, alert SLOAlert)
+}
+
+// BurnRateWindow is a multi-window burn rate alert rule. It fires when the
+// burn rate exceeds Threshold over both the Long and the Short window, so that
+// alerts are raised fast and resolve fast once the problem stops.
+type BurnRateWindow struct {
+	Long      time.Duration
+	Short     time.Duration
+	Threshold float64
+	Severity  string
+}
+
+// DefaultBurnRateWindows returns the common multi-window alert rules for a 30
+// day budget: paging when 2% of the budget burns in an hour or 5% in six
+// hours, and a ticket when 10% burns in three days.
+func DefaultBurnRateWindows() []BurnRateWindow {
+	return []BurnRateWindow{
+		{Long: time.Hour, Short: 5 * time.Minute, Threshold: 14.4, Severity: "page"},
+		{Long: 6 * time.Hour, Short: 30 * time.Minute, Threshold: 6, Severity: "page"},
+		{Long: 72 * time.Hour, Short: 6 * time.Hour, Threshold: 1, Severity: "ticket"},
+	}
+}
+
+// SLOAlert describes a burn rate alert that started firing or resolved.
+type SLOAlert struct {
+	SLO           string
+	Chain         string
+	Window        BurnRateWindow
+	LongBurnRate  float64
+	ShortBurnRate float64
+	Resolved      bool
+	Time          time.Time
+}
+
+// SLOStatus is a snapshot of the state of an SLO.
+type SLOStatus struct {
+	Name             string
+	Chain            string
+	Objective        float64
+	LatencyThreshold time.Duration
+
+	// PeriodStart is the start of the current budget period; Good and Total
+	// count the executions measured since
+	PeriodStart time.Time
+	Good        uint64
+	Total       uint64
+
+	// Compliance is the fraction of good executions in the current period,
+	// 1 when nothing was measured
+	Compliance float64
+
+	// ErrorBudgetRemaining is the fraction of the period's error budget that
+	// is left, negative once it is exhausted
+	ErrorBudgetRemaining float64
+
+	// BurnRates are the burn rates per window
+	BurnRates []WindowBurnRate
+
+	// Firing are the alert rules currently firing
+	Firing []BurnRateWindow
+}
+
+// WindowBurnRate is the burn rate over a window. A burn rate of 1 consumes
+// the error budget exactly over the budget period.
+type WindowBurnRate struct {
+	Window   time.Duration
+	BurnRate float64
+}
+
+// SLOTracker measures SLOs installed on chains with WithSLO, and publishes
+// their state to a Metrics, if any.
+//
+// Example:
+//
+//	tracker := middleware.NewSLOTracker(middleware.DefaultMetrics)
+//	chain := middleware.NewNamedChain("orders", createOrder).
+//		With(middleware.WithSLO(tracker, middleware.SLO{
+//			Name:             "orders-latency",
+//			Objective:        0.999,
+//			LatencyThreshold: 200 * time.Millisecond,
+//			OnAlert: func(ctx context.Context, alert middleware.SLOAlert) {
+//				pager.Notify(alert)
+//			},
+//		}))
+//
+//	go tracker.Run(ctx, time.Minute)
+//
+//	status, _ := tracker.Status("orders-latency")
+//	fmt.Println(status.ErrorBudgetRemaining)
+type SLOTracker struct {
+	metrics Metrics
+	now     func() time.Time
+
+	mu   sync.RWMutex
+	slos map[string]*sloState
+}
+
+// NewSLOTracker creates an SLOTracker publishing to metrics, which may be nil.
+func NewSLOTracker(metrics Metrics) *SLOTracker {
+	if describer, ok := metrics.(MetricsDescriber); ok {
+		describer.Describe(MetricSLOEvents, KindCounter, "Number of executions measured by an SLO by outcome.")
+		describer.Describe(MetricSLOBurnRate, KindGauge, "Error budget burn rate of an SLO per window.")
+		describer.Describe(MetricSLOErrorBudgetRemaining, KindGauge, "Fraction of the error budget of the current period that is left.")
+	}
+
+	return &SLOTracker{
+		metrics: metrics,
+		now:     time.Now,
+		slos:    map[string]*sloState{},
+	}
+}
+
+// WithSLO returns a ChainOption that measures every execution of the chain
+// against an SLO tracked by tracker. Installing SLOs with the same name on
+// several chains, or on chains derived from each other, measures them
+// together.
+//
+// It panics if the objective is not between 0 and 1, exclusive, or if an SLO
+// with the same name but a different objective, latency threshold, period or
+// windows is already tracked.
+func WithSLO(tracker *SLOTracker, slo SLO) ChainOption {
+	return func(c *Chain) {
+		if slo.Chain == "" {
+			slo.Chain = c.name
+		}
+
+		state := tracker.register(slo)
+		c.hooks = append(c.hooks, Hooks{
+			AfterChain: func(ctx context.Context, info ChainInfo, err error, elapsed time.Duration) {
+				tracker.record(ctx, state, err, elapsed)
+			},
+		})
+	}
+}
+
+// Status returns the current state of an SLO and whether it exists. The alert
+// rules are evaluated first, so alerts that resolved since the last execution
+// are reported to OnAlert.
+func (t *SLOTracker) Status(name string) (SLOStatus, bool) {
+	t.mu.RLock()
+	state, ok := t.slos[name]
+	t.mu.RUnlock()
+
+	if !ok {
+		return SLOStatus{}, false
+	}
+
+	return t.evaluate(context.Background(), state, t.now()), true
+}
+
+// Statuses returns the current state of all SLOs, sorted by name.
+func (t *SLOTracker) Statuses() []SLOStatus {
+	t.mu.RLock()
+	names := make([]string, 0, len(t.slos))
+	for name := range t.slos {
+		names = append(names, name)
+	}
+	t.mu.RUnlock()
+
+	sort.Strings(names)
+
+	statuses := make([]SLOStatus, 0, len(names))
+	for _, name := range names {
+		if status, ok := t.Status(name); ok {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses
+}
+
+// Run evaluates the alert rules of every SLO at the given interval until ctx
+// is done, so that alerts resolve and gauges are updated when no executions
+// are measured. A non-positive interval means one minute.
+func (t *SLOTracker) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.EvaluateAll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// EvaluateAll evaluates the alert rules of every SLO, reporting alerts that
+// started firing or resolved to OnAlert and publishing the gauges.
+func (t *SLOTracker) EvaluateAll(ctx context.Context) {
+	t.mu.RLock()
+	states := make([]*sloState, 0, len(t.slos))
+	for _, state := range t.slos {
+		states = append(states, state)
+	}
+	t.mu.RUnlock()
+
+	now := t.now()
+	for _, state := range states {
+		t.evaluate(ctx, state, now)
+	}
+}
+
+// register returns the state of an SLO, creating it if needed. It panics on an
+// invalid SLO, or one conflicting with the tracked SLO of the same name.
+func (t *SLOTracker) register(slo SLO) *sloState {
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		panic(fmt.Sprintf("middleware: SLO %q has objective %v, want a value between 0 and 1, exclusive", slo.Name, slo.Objective))
+	}
+
+	if slo.Period == 0 {
+		slo.Period = 30 * 24 * time.Hour
+	}
+	if slo.Windows == nil {
+		slo.Windows = DefaultBurnRateWindows()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.slos[slo.Name]; ok {
+		if !state.slo.sameTarget(slo) {
+			panic(fmt.Sprintf("middleware: SLO %q is already tracked with a different configuration", slo.Name))
+		}
+		return state
+	}
+
+	var longest time.Duration
+	for _, window := range slo.Windows {
+		longest = max(longest, window.Long, window.Short)
+	}
+
+	state := &sloState{
+		slo:         slo,
+		buckets:     make([]sloBucket, int(longest/sloBucketWidth)+1),
+		periodStart: t.now(),
+		firing:      make([]bool, len(slo.Windows)),
+	}
+	t.slos[slo.Name] = state
+	return state
+}
+
+// record measures an execution and evaluates the alert rules, at most once
+// per sloEvaluationInterval.
+func (t *SLOTracker) record(ctx context.Context, state *sloState, err error, elapsed time.Duration) {
+	slo := state.slo
+	good := err == nil && (slo.LatencyThreshold == 0 || elapsed <= slo.LatencyThreshold)
+	now := t.now()
+
+	state.mu.Lock()
+	state.add(now, good)
+	evaluate := now.Sub(state.lastEvaluation) >= sloEvaluationInterval
+	state.mu.Unlock()
+
+	if t.metrics != nil {
+		outcome := "good"
+		if !good {
+			outcome = "bad"
+		}
+		t.metrics.AddCounter(ctx, MetricSLOEvents, Labels{"slo": slo.Name, "chain": chainLabel(slo.Chain), "outcome": outcome}, 1)
+	}
+
+	if evaluate {
+		t.evaluate(ctx, state, now)
+	}
+}
+
+// evaluate updates the firing state of the alert rules, reports the alerts
+// that started firing or resolved, publishes the gauges and returns the
+// state of the SLO.
+func (t *SLOTracker) evaluate(ctx context.Context, state *sloState, now time.Time) SLOStatus {
+	slo := state.slo
+
+	state.mu.Lock()
+	state.lastEvaluation = now
+	alerts := state.evaluate(now)
+	status := state.status(now)
+	state.mu.Unlock()
+
+	if t.metrics != nil {
+		t.metrics.SetGauge(ctx, MetricSLOErrorBudgetRemaining, Labels{"slo": slo.Name}, status.ErrorBudgetRemaining)
+		for _, rate := range status.BurnRates {
+			t.metrics.SetGauge(ctx, MetricSLOBurnRate, Labels{"slo": slo.Name, "window": formatWindow(rate.Window)}, rate.BurnRate)
+		}
+	}
+
+	if slo.OnAlert != nil {
+		for _, alert := range alerts {
+			slo.OnAlert(ctx, alert)
+		}
+	}
+
+	return status
+}
+
+// sameTarget reports whether two SLOs measure the same target, ignoring the
+// chain and the alert callback.
+func (s SLO) sameTarget(other SLO) bool {
+	return s.Objective == other.Objective &&
+		s.LatencyThreshold == other.LatencyThreshold &&
+		s.Period == other.Period &&
+		slices.Equal(s.Windows, other.Windows)
+}
+
+// sloState holds the measurements of an SLO.
+type sloState struct {
+	slo SLO
+
+	mu             sync.Mutex
+	buckets        []sloBucket // ring of per-minute counts covering the longest window
+	periodStart    time.Time
+	good, total    uint64
+	firing         []bool
+	lastEvaluation time.Time
+}
+
+// sloBucket counts the executions of one minute.
+type sloBucket struct {
+	minute      int64
+	good, total uint64
+}
+
+// add counts an execution. It must be called with the state's mutex held.
+func (s *sloState) add(now time.Time, good bool) {
+	s.rollPeriod(now)
+	s.total++
+	if good {
+		s.good++
+	}
+
+	minute := now.UnixNano() / int64(sloBucketWidth)
+	bucket := &s.buckets[minute%int64(len(s.buckets))]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if good {
+		bucket.good++
+	}
+}
+
+// rollPeriod starts a new budget period once the current one elapsed.
+func (s *sloState) rollPeriod(now time.Time) {
+	if elapsed := now.Sub(s.periodStart); elapsed >= s.slo.Period {
+		s.periodStart = s.periodStart.Add(elapsed - elapsed%s.slo.Period)
+		s.good, s.total = 0, 0
+	}
+}
+
+// burnRate returns the burn rate over the window ending at now.
+func (s *sloState) burnRate(now time.Time, window time.Duration) float64 {
+	current := now.UnixNano() / int64(sloBucketWidth)
+	count := int64(window / sloBucketWidth)
+	if count < 1 {
+		count = 1
+	}
+
+	var good, total uint64
+	for minute := current - count + 1; minute <= current; minute++ {
+		bucket := s.buckets[minute%int64(len(s.buckets))]
+		if bucket.minute == minute {
+			good += bucket.good
+			total += bucket.total
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	budget := 1 - s.slo.Objective
+
+	return float64(total-good) / float64(total) / budget
+}
+
+// evaluate updates the firing state of the alert rules and returns the
+// alerts that started firing or resolved.
+func (s *sloState) evaluate(now time.Time) []SLOAlert {
+	var alerts []SLOAlert
+	for i, window := range s.slo.Windows {
+		long := s.burnRate(now, window.Long)
+		short := s.burnRate(now, window.Short)
+		firing := long > window.Threshold && short > window.Threshold
+
+		if firing == s.firing[i] {
+			continue
+		}
+		s.firing[i] = firing
+
+		alerts = append(alerts, SLOAlert{
+			SLO:           s.slo.Name,
+			Chain:         s.slo.Chain,
+			Window:        window,
+			LongBurnRate:  long,
+			ShortBurnRate: short,
+			Resolved:      !firing,
+			Time:          now,
+		})
+	}
+
+	return alerts
+}
+
+// status returns a snapshot of the state. It must be called with the state's
+// mutex held.
+func (s *sloState) status(now time.Time) SLOStatus {
+	s.rollPeriod(now)
+
+	status := SLOStatus{
+		Name:                 s.slo.Name,
+		Chain:                s.slo.Chain,
+		Objective:            s.slo.Objective,
+		LatencyThreshold:     s.slo.LatencyThreshold,
+		PeriodStart:          s.periodStart,
+		Good:                 s.good,
+		Total:                s.total,
+		Compliance:           1,
+		ErrorBudgetRemaining: 1,
+	}
+
+	if s.total > 0 {
+		status.Compliance = float64(s.good) / float64(s.total)
+		status.ErrorBudgetRemaining = 1 - (1-status.Compliance)/(1-s.slo.Objective)
+	}
+
+	seen := map[time.Duration]bool{}
+	for i, window := range s.slo.Windows {
+		for _, d := range []time.Duration{window.Short, window.Long} {
+			if !seen[d] {
+				seen[d] = true
+				status.BurnRates = append(status.BurnRates, WindowBurnRate{Window: d, BurnRate: s.burnRate(now, d)})
+			}
+		}
+
+		if s.firing[i] {
+			status.Firing = append(status.Firing, window)
+		}
+	}
+
+	sort.Slice(status.BurnRates, func(i, j int) bool {
+		return status.BurnRates[i].Window < status.BurnRates[j].Window
+	})
+
+	return status
+}
+
+// formatWindow renders a window compactly for metric labels, e.g. "5m" or "6h".
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for SLOTracker.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSLOAlertResolvesWithoutTraffic(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := NewSLOTracker(nil)
+	tracker.now = clock.Now
+
+	var alerts []SLOAlert
+	chain := NewNamedChain("orders", failPermanently).With(WithSLO(tracker, SLO{
+		Name:      "orders",
+		Objective: 0.99,
+		Windows:   []BurnRateWindow{{Long: 10 * time.Minute, Short: 2 * time.Minute, Threshold: 2}},
+		OnAlert: func(ctx context.Context, alert SLOAlert) {
+			alerts = append(alerts, alert)
+		},
+	}))
+
+	chain.Then(context.Background(), nil)
+	if len(alerts) != 1 || alerts[0].Resolved {
+		t.Fatalf("alerts after failures = %+v; want one firing alert", alerts)
+	}
+
+	// No more executions: reading the state evaluates the rules again
+	clock.Advance(15 * time.Minute)
+	status, _ := tracker.Status("orders")
+
+	if len(alerts) != 2 || !alerts[1].Resolved {
+		t.Errorf("alerts after traffic stopped = %+v; want the alert resolved", alerts)
+	}
+	if len(status.Firing) != 0 {
+		t.Errorf("Firing = %v; want none", status.Firing)
+	}
+}
+
+func TestSLOEvaluateAllResolvesAlerts(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := NewSLOTracker(nil)
+	tracker.now = clock.Now
+
+	resolved := false
+	NewNamedChain("orders", failPermanently).With(WithSLO(tracker, SLO{
+		Name:      "orders",
+		Objective: 0.99,
+		Windows:   []BurnRateWindow{{Long: 10 * time.Minute, Short: 2 * time.Minute, Threshold: 2}},
+		OnAlert: func(ctx context.Context, alert SLOAlert) {
+			resolved = alert.Resolved
+		},
+	})).Then(context.Background(), nil)
+
+	clock.Advance(15 * time.Minute)
+	tracker.EvaluateAll(context.Background())
+
+	if !resolved {
+		t.Error("EvaluateAll() did not resolve the alert")
+	}
+}
+
+func TestWithSLORejectsInvalidConfigurations(t *testing.T) {
+	tracker := NewSLOTracker(nil)
+	NewChain().With(WithSLO(tracker, SLO{Name: "orders", Objective: 0.999}))
+
+	tests := map[string]SLO{
+		"objective of 1":        {Name: "strict", Objective: 1},
+		"objective of 0":        {Name: "lax", Objective: 0},
+		"different objective":   {Name: "orders", Objective: 0.99},
+		"different latency":     {Name: "orders", Objective: 0.999, LatencyThreshold: time.Second},
+		"different alert rules": {Name: "orders", Objective: 0.999, Windows: []BurnRateWindow{{Long: time.Hour, Short: time.Minute, Threshold: 1}}},
+	}
+	for name, slo := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: WithSLO() did not panic", name)
+				}
+			}()
+			NewChain().With(WithSLO(tracker, slo))
+		}()
+	}
+
+	// The same SLO on another chain is measured together
+	NewChain(func(ctx context.Context, input any) (context.Context, any, error) {
+		return ctx, nil, errors.New("failed")
+	}).With(WithSLO(tracker, SLO{Name: "orders", Objective: 0.999})).Then(context.Background(), nil)
+
+	if status, _ := tracker.Status("orders"); status.Total != 1 {
+		t.Errorf("Total = %d; want 1", status.Total)
+	}
+}
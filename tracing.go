@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Tracer starts spans on a distributed tracing backend. Adapters for DataDog
+// and OpenTelemetry live in the tracing/ddtracer and tracing/oteltracer
+// packages, so this package does not depend on either SDK.
+//
+// StartSpan must return a context carrying the backend's own span, so that
+// spans started from it, by this package or by other instrumentation, become
+// its children.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single unit of work reported to a tracing backend.
+type Span interface {
+	// SetTag sets a tag on the span
+	SetTag(key string, value any)
+
+	// SetError marks the span as failed with the given error
+	SetError(err error)
+
+	// Finish completes the span. It must be called exactly once.
+	Finish()
+
+	// TraceID returns the ID of the trace the span belongs to
+	TraceID() string
+
+	// SpanID returns the ID of the span
+	SpanID() string
+}
+
+// LogCorrelator is implemented by spans whose backend expects trace
+// correlation fields with specific names in log records. ContextHandler uses
+// it instead of the generic trace_id and span_id attributes.
+type LogCorrelator interface {
+	CorrelationAttrs() []slog.Attr
+}
+
+// spanKey is the context key under which the active Span is stored.
+type spanKey struct{}
+
+// defaultTracer holds the tracer used when a configuration does not set one.
+var defaultTracer atomic.Pointer[tracerHolder]
+
+// tracerHolder wraps a Tracer so that it can be stored atomically.
+type tracerHolder struct {
+	tracer Tracer
+}
+
+// SetDefaultTracer sets the tracer used by the observability middleware when
+// its configuration does not set one. The initial default is NoopTracer, so
+// applications that relied on spans being sent to DataDog APM by default must
+// set ddtracer.New() explicitly.
+//
+// Example:
+//
+//	tracer.Start()
+//	defer tracer.Stop()
+//	middleware.SetDefaultTracer(ddtracer.New())
+func SetDefaultTracer(tracer Tracer) {
+	if tracer == nil {
+		tracer = NoopTracer()
+	}
+	defaultTracer.Store(&tracerHolder{tracer: tracer})
+}
+
+// DefaultTracer returns the tracer set with SetDefaultTracer.
+func DefaultTracer() Tracer {
+	if holder := defaultTracer.Load(); holder != nil {
+		return holder.tracer
+	}
+
+	return NoopTracer()
+}
+
+// StartSpan starts a span with the given tracer and returns a context carrying
+// it. The span can be retrieved with SpanFromContext.
+//
+// Example:
+//
+//	ctx, span := middleware.StartSpan(ctx, tracer, "charge-card")
+//	defer span.Finish()
+func StartSpan(ctx context.Context, tracer Tracer, name string) (context.Context, Span) {
+	if tracer == nil {
+		tracer = DefaultTracer()
+	}
+
+	ctx, span := tracer.StartSpan(ctx, name)
+	return ContextWithSpan(ctx, span), span
+}
+
+// ContextWithSpan returns a context carrying the given span as the active span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext retrieves the active span from the context.
+// It returns the span and a boolean indicating whether a span was found.
+//
+// Example:
+//
+//	if span, ok := middleware.SpanFromContext(ctx); ok {
+//	    span.SetTag("cart.items", len(items))
+//	}
+func SpanFromContext(ctx context.Context) (Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	return span, ok
+}
+
+// NoopTracer returns a tracer whose spans discard everything.
+func NoopTracer() Tracer {
+	return noopTracer{}
+}
+
+// noopTracer is a Tracer that starts noopSpans.
+type noopTracer struct{}
+
+// StartSpan returns the context unchanged and a span that does nothing.
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is a Span that does nothing.
+type noopSpan struct{}
+
+func (noopSpan) SetTag(key string, value any) {}
+func (noopSpan) SetError(err error)           {}
+func (noopSpan) Finish()                      {}
+func (noopSpan) TraceID() string              { return "" }
+func (noopSpan) SpanID() string               { return "" }
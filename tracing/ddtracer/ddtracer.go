@@ -0,0 +1,108 @@
+// Package ddtracer adapts the DataDog tracer (dd-trace-go) to the
+// middleware.Tracer interface.
+//
+// The DataDog tracer itself must be started and stopped by the application:
+//
+//	tracer.Start()
+//	defer tracer.Stop()
+//
+//	middleware.SetDefaultTracer(ddtracer.New())
+package ddtracer
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/raywall/go-middleware"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// Tracer is a middleware.Tracer backed by the global DataDog tracer.
+type Tracer struct {
+	opts []ddtrace.StartSpanOption
+}
+
+// New creates a Tracer. The given options are applied to every span it starts,
+// e.g. tracer.ServiceName or tracer.ResourceName.
+//
+// Example:
+//
+//	t := ddtracer.New(tracer.ServiceName("user-service"))
+func New(opts ...ddtrace.StartSpanOption) *Tracer {
+	return &Tracer{opts: opts}
+}
+
+// StartSpan starts a DataDog span as a child of the active span in the
+// context, if any. The active span set with middleware.ContextWithSpan takes
+// precedence over the DataDog span stored in the context.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, middleware.Span) {
+	opts := t.opts
+	if parent, ok := middleware.SpanFromContext(ctx); ok {
+		if parentSpan, ok := parent.(*Span); ok {
+			opts = append(opts[:len(opts):len(opts)], tracer.ChildOf(parentSpan.span.Context()))
+		}
+	} else if parent, ok := tracer.SpanFromContext(ctx); ok {
+		opts = append(opts[:len(opts):len(opts)], tracer.ChildOf(parent.Context()))
+	}
+
+	span := tracer.StartSpan(name, opts...)
+	return tracer.ContextWithSpan(ctx, span), &Span{span: span}
+}
+
+// Span is a middleware.Span backed by a DataDog span.
+type Span struct {
+	span ddtrace.Span
+}
+
+// SetTag sets a tag on the DataDog span.
+func (s *Span) SetTag(key string, value any) {
+	s.span.SetTag(key, value)
+}
+
+// SetError marks the DataDog span as failed with the given error.
+func (s *Span) SetError(err error) {
+	s.span.SetTag(ext.Error, err)
+}
+
+// Finish finishes the DataDog span.
+func (s *Span) Finish() {
+	s.span.Finish()
+}
+
+// SetSamplingPriority keeps or drops the whole trace through the DataDog
+// manual sampling tags.
+func (s *Span) SetSamplingPriority(keep bool) {
+	if keep {
+		s.span.SetTag(ext.ManualKeep, true)
+	} else {
+		s.span.SetTag(ext.ManualDrop, true)
+	}
+}
+
+// TraceID returns the decimal DataDog trace ID.
+func (s *Span) TraceID() string {
+	return strconv.FormatUint(s.span.Context().TraceID(), 10)
+}
+
+// SpanID returns the decimal DataDog span ID.
+func (s *Span) SpanID() string {
+	return strconv.FormatUint(s.span.Context().SpanID(), 10)
+}
+
+// CorrelationAttrs returns the dd.trace_id and dd.span_id attributes DataDog
+// uses to correlate logs with traces.
+func (s *Span) CorrelationAttrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("dd.trace_id", s.TraceID()),
+		slog.String("dd.span_id", s.SpanID()),
+	}
+}
+
+// Unwrap returns the underlying DataDog span.
+func (s *Span) Unwrap() ddtrace.Span {
+	return s.span
+}
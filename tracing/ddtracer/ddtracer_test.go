@@ -0,0 +1,90 @@
+package ddtracer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/raywall/go-middleware"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// samplingPriorityTag is the metric holding the sampling priority of a
+// DataDog span.
+const samplingPriorityTag = "_sampling_priority_v1"
+
+func TestTracerRecordsSpans(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ctx, parent := middleware.StartSpan(context.Background(), New(tracer.ServiceName("orders")), "request")
+	_, child := New().StartSpan(ctx, "charge")
+	child.SetTag("order.id", 42)
+	child.SetError(errors.New("card declined"))
+	child.Finish()
+	parent.Finish()
+
+	spans := mt.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("finished %d spans; want 2", len(spans))
+	}
+
+	charge, request := spans[0], spans[1]
+	if charge.OperationName() != "charge" || request.OperationName() != "request" {
+		t.Fatalf("spans = %s, %s; want charge, request", charge.OperationName(), request.OperationName())
+	}
+	if charge.ParentID() != request.SpanID() || charge.TraceID() != request.TraceID() {
+		t.Error("charge is not a child of request")
+	}
+	if request.Tag(ext.ServiceName) != "orders" {
+		t.Errorf("service = %v; want orders", request.Tag(ext.ServiceName))
+	}
+	if charge.Tag("order.id") != 42.0 { // numeric tags are stored as metrics
+		t.Errorf("order.id = %v; want 42", charge.Tag("order.id"))
+	}
+	if charge.Tag(ext.ErrorMsg) != "card declined" {
+		t.Errorf("error message = %v; want card declined", charge.Tag(ext.ErrorMsg))
+	}
+}
+
+func TestTracerContinuesDataDogSpanInContext(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+	_, span := New().StartSpan(ctx, "middleware.request")
+	span.Finish()
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	if len(spans) != 2 || spans[0].ParentID() != spans[1].SpanID() {
+		t.Error("span is not a child of the DataDog span in the context")
+	}
+}
+
+func TestSpanIDsAndCorrelation(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	_, span := New().StartSpan(context.Background(), "request")
+	span.(middleware.SamplingPrioritySetter).SetSamplingPriority(true)
+	span.Finish()
+
+	finished := mt.FinishedSpans()[0]
+	if span.TraceID() != strconv.FormatUint(finished.TraceID(), 10) || span.SpanID() != strconv.FormatUint(finished.SpanID(), 10) {
+		t.Errorf("IDs = %s/%s; want %d/%d", span.TraceID(), span.SpanID(), finished.TraceID(), finished.SpanID())
+	}
+	// The manual keep tag is applied as the sampling priority of the trace
+	if priority := finished.Tag(samplingPriorityTag); priority != float64(ext.PriorityUserKeep) {
+		t.Errorf("sampling priority = %v; want %d", priority, ext.PriorityUserKeep)
+	}
+
+	attrs := span.(middleware.LogCorrelator).CorrelationAttrs()
+	if len(attrs) != 2 || attrs[0].Key != "dd.trace_id" || attrs[0].Value.String() != span.TraceID() || attrs[1].Key != "dd.span_id" {
+		t.Errorf("CorrelationAttrs() = %v; want dd.trace_id and dd.span_id", attrs)
+	}
+}
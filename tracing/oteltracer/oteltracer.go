@@ -0,0 +1,124 @@
+// Package oteltracer adapts OpenTelemetry tracing to the middleware.Tracer
+// interface.
+//
+// Example:
+//
+//	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+//	middleware.SetDefaultTracer(oteltracer.NewFromProvider(provider))
+package oteltracer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/raywall/go-middleware"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the instrumentation scope name used by NewFromProvider.
+const InstrumentationName = "github.com/raywall/go-middleware"
+
+// Tracer is a middleware.Tracer backed by an OpenTelemetry tracer.
+type Tracer struct {
+	tracer trace.Tracer
+	opts   []trace.SpanStartOption
+}
+
+// New creates a Tracer from an OpenTelemetry tracer. The given options are
+// applied to every span it starts.
+func New(tracer trace.Tracer, opts ...trace.SpanStartOption) *Tracer {
+	return &Tracer{tracer: tracer, opts: opts}
+}
+
+// NewFromProvider creates a Tracer using the provider's tracer for this package.
+func NewFromProvider(provider trace.TracerProvider, opts ...trace.SpanStartOption) *Tracer {
+	return New(provider.Tracer(InstrumentationName), opts...)
+}
+
+// StartSpan starts an OpenTelemetry span as a child of the active span in the
+// context, if any. The active span set with middleware.ContextWithSpan takes
+// precedence over the OpenTelemetry span stored in the context.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, middleware.Span) {
+	if parent, ok := middleware.SpanFromContext(ctx); ok {
+		if parentSpan, ok := parent.(*Span); ok {
+			ctx = trace.ContextWithSpan(ctx, parentSpan.span)
+		}
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, t.opts...)
+	return ctx, &Span{span: span}
+}
+
+// Span is a middleware.Span backed by an OpenTelemetry span.
+type Span struct {
+	span trace.Span
+}
+
+// SetTag sets an attribute on the OpenTelemetry span.
+func (s *Span) SetTag(key string, value any) {
+	s.span.SetAttributes(toAttribute(key, value))
+}
+
+// SetError records the error on the OpenTelemetry span and sets its status.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Finish ends the OpenTelemetry span.
+func (s *Span) Finish() {
+	s.span.End()
+}
+
+// TraceID returns the hex encoded OpenTelemetry trace ID.
+func (s *Span) TraceID() string {
+	return s.span.SpanContext().TraceID().String()
+}
+
+// SpanID returns the hex encoded OpenTelemetry span ID.
+func (s *Span) SpanID() string {
+	return s.span.SpanContext().SpanID().String()
+}
+
+// Unwrap returns the underlying OpenTelemetry span.
+func (s *Span) Unwrap() trace.Span {
+	return s.span
+}
+
+// toAttribute converts a tag value into an OpenTelemetry attribute.
+func toAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case time.Duration:
+		return attribute.String(key, v.String())
+	case []string:
+		return attribute.StringSlice(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
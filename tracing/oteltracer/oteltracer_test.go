@@ -0,0 +1,126 @@
+package oteltracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/raywall/go-middleware"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracer returns a Tracer recording its spans in an in-memory exporter.
+func newTracer() (*Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return NewFromProvider(provider), exporter
+}
+
+func TestTracerRecordsSpans(t *testing.T) {
+	tracer, exporter := newTracer()
+
+	ctx, parent := middleware.StartSpan(context.Background(), tracer, "request")
+	_, child := tracer.StartSpan(ctx, "charge")
+	child.SetTag("order.id", 42)
+	child.SetError(errors.New("card declined"))
+	child.Finish()
+	parent.Finish()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans; want 2", len(spans))
+	}
+
+	charge, request := spans[0], spans[1]
+	if charge.Name != "charge" || request.Name != "request" {
+		t.Fatalf("spans = %s, %s; want charge, request", charge.Name, request.Name)
+	}
+	if charge.Parent.SpanID() != request.SpanContext.SpanID() || charge.SpanContext.TraceID() != request.SpanContext.TraceID() {
+		t.Error("charge is not a child of request")
+	}
+	if charge.InstrumentationScope.Name != InstrumentationName {
+		t.Errorf("scope = %s; want %s", charge.InstrumentationScope.Name, InstrumentationName)
+	}
+	if len(charge.Attributes) != 1 || charge.Attributes[0] != attribute.Int("order.id", 42) {
+		t.Errorf("attributes = %v; want order.id=42", charge.Attributes)
+	}
+	if charge.Status.Code != codes.Error || charge.Status.Description != "card declined" {
+		t.Errorf("status = %+v; want an error with the message", charge.Status)
+	}
+	if len(charge.Events) != 1 || charge.Events[0].Name != "exception" {
+		t.Errorf("events = %v; want the recorded error", charge.Events)
+	}
+}
+
+func TestTracerContinuesOpenTelemetrySpanInContext(t *testing.T) {
+	tracer, exporter := newTracer()
+
+	ctx, root := tracer.tracer.Start(context.Background(), "http.request")
+	_, span := tracer.StartSpan(ctx, "middleware.request")
+	span.Finish()
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("span is not a child of the OpenTelemetry span in the context")
+	}
+}
+
+func TestSpanIDs(t *testing.T) {
+	tracer, exporter := newTracer()
+
+	_, span := tracer.StartSpan(context.Background(), "request")
+	span.SetError(nil)
+	span.Finish()
+
+	exported := exporter.GetSpans()[0]
+	if span.TraceID() != exported.SpanContext.TraceID().String() || span.SpanID() != exported.SpanContext.SpanID().String() {
+		t.Errorf("IDs = %s/%s; want %s/%s", span.TraceID(), span.SpanID(), exported.SpanContext.TraceID(), exported.SpanContext.SpanID())
+	}
+	if exported.Status.Code != codes.Unset {
+		t.Errorf("status = %v; want unset after SetError(nil)", exported.Status.Code)
+	}
+	if got := span.(*Span).Unwrap().SpanContext(); got.SpanID() != exported.SpanContext.SpanID() {
+		t.Error("Unwrap() returned another span")
+	}
+}
+
+// orderID is a tag value implementing fmt.Stringer.
+type orderID int
+
+func (id orderID) String() string { return fmt.Sprintf("order-%d", int(id)) }
+
+func TestToAttribute(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  attribute.KeyValue
+	}{
+		{"string", "acme", attribute.String("k", "acme")},
+		{"bool", true, attribute.Bool("k", true)},
+		{"int", 3, attribute.Int("k", 3)},
+		{"int32", int32(3), attribute.Int64("k", 3)},
+		{"int64", int64(3), attribute.Int64("k", 3)},
+		{"uint32", uint32(3), attribute.Int64("k", 3)},
+		{"float32", float32(1.5), attribute.Float64("k", 1.5)},
+		{"float64", 1.5, attribute.Float64("k", 1.5)},
+		{"duration", 2 * time.Second, attribute.String("k", "2s")},
+		{"strings", []string{"a", "b"}, attribute.StringSlice("k", []string{"a", "b"})},
+		{"stringer", orderID(7), attribute.String("k", "order-7")},
+		{"other", []int{1, 2}, attribute.String("k", "[1 2]")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toAttribute("k", tt.value); got != tt.want {
+				t.Errorf("toAttribute(%v) = %v; want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecordedSpan is a snapshot of a span recorded by a RecordingTracer.
+type RecordedSpan struct {
+	Name      string
+	TraceID   string
+	SpanID    string
+	ParentID  string // empty for root spans
+	Tags      map[string]any
+	Err       error
+	Sampled   *bool // sampling decision set through SetSamplingPriority, if any
+	StartTime time.Time
+	EndTime   time.Time // zero while the span is not finished
+	Finished  bool
+}
+
+// Duration returns how long the span was open. It is zero for unfinished spans.
+func (s RecordedSpan) Duration() time.Duration {
+	if !s.Finished {
+		return 0
+	}
+
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// RecordingTracer is an in-memory Tracer that records every span it starts.
+// It needs no tracing agent, which makes it suitable for tests and debugging.
+//
+// Example:
+//
+//	rec := middleware.NewRecordingTracer()
+//	config := middleware.DefaultObservabilityConfig()
+//	config.Tracer = rec
+//
+//	chain.Then(ctx, input)
+//	for _, span := range rec.Spans() {
+//		fmt.Println(span.Name, span.Duration(), span.Tags)
+//	}
+type RecordingTracer struct {
+	mu     sync.Mutex
+	spans  []*recordingSpan
+	nextID uint64
+}
+
+// NewRecordingTracer creates an empty RecordingTracer.
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+// StartSpan starts a recorded span. Spans started from a context carrying a
+// span of the same tracer become its children.
+func (t *RecordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	span := &recordingSpan{
+		tracer: t,
+		data: RecordedSpan{
+			Name:      name,
+			SpanID:    strconv.FormatUint(t.nextID, 10),
+			Tags:      map[string]any{},
+			StartTime: time.Now(),
+		},
+	}
+
+	if parent, ok := SpanFromContext(ctx); ok {
+		if parentSpan, ok := parent.(*recordingSpan); ok && parentSpan.tracer == t {
+			span.data.TraceID = parentSpan.data.TraceID
+			span.data.ParentID = parentSpan.data.SpanID
+		}
+	}
+
+	if span.data.TraceID == "" {
+		span.data.TraceID = span.data.SpanID
+	}
+
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+// Spans returns snapshots of all recorded spans in start order.
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := make([]RecordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = span.snapshot()
+	}
+
+	return spans
+}
+
+// FinishedSpans returns snapshots of the finished spans in start order.
+func (t *RecordingTracer) FinishedSpans() []RecordedSpan {
+	var finished []RecordedSpan
+	for _, span := range t.Spans() {
+		if span.Finished {
+			finished = append(finished, span)
+		}
+	}
+
+	return finished
+}
+
+// Reset discards all recorded spans.
+func (t *RecordingTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = nil
+}
+
+// recordingSpan is the Span implementation of RecordingTracer. Its data is
+// guarded by the tracer's mutex.
+type recordingSpan struct {
+	tracer *RecordingTracer
+	data   RecordedSpan
+}
+
+func (s *recordingSpan) SetTag(key string, value any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.data.Tags[key] = value
+}
+
+func (s *recordingSpan) SetError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.data.Err = err
+}
+
+func (s *recordingSpan) SetSamplingPriority(keep bool) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.data.Sampled = &keep
+}
+
+func (s *recordingSpan) Finish() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	if s.data.Finished {
+		return
+	}
+	s.data.EndTime = time.Now()
+	s.data.Finished = true
+}
+
+func (s *recordingSpan) TraceID() string {
+	return s.data.TraceID
+}
+
+func (s *recordingSpan) SpanID() string {
+	return s.data.SpanID
+}
+
+// snapshot copies the span data. It must be called with the tracer's mutex held.
+func (s *recordingSpan) snapshot() RecordedSpan {
+	data := s.data
+	if s.data.Sampled != nil {
+		sampled := *s.data.Sampled
+		data.Sampled = &sampled
+	}
+	data.Tags = make(map[string]any, len(s.data.Tags))
+	for key, value := range s.data.Tags {
+		data.Tags[key] = value
+	}
+
+	return data
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// tracedConfig returns an observability configuration recording its spans
+// in rec and discarding its logs.
+func tracedConfig(rec *RecordingTracer) *ObservabilityConfig {
+	config := DefaultObservabilityConfig()
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	config.Tracer = rec
+	return config
+}
+
+// spansByName indexes recorded spans by name.
+func spansByName(spans []RecordedSpan) map[string]RecordedSpan {
+	byName := make(map[string]RecordedSpan, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func TestDefaultTracerIsNoop(t *testing.T) {
+	if _, ok := DefaultTracer().(noopTracer); !ok {
+		t.Fatalf("default tracer = %T, want the no-op tracer", DefaultTracer())
+	}
+}
+
+func TestObservabilityTracesEveryStep(t *testing.T) {
+	rec := NewRecordingTracer()
+	chain := NewChain(ObservabilityWithConfig(tracedConfig(rec))).
+		AppendNamed("first", passThrough).
+		AppendNamed("second", passThrough)
+
+	if _, _, err := chain.Then(context.Background(), "input"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := rec.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	if len(rec.FinishedSpans()) != 3 {
+		t.Fatalf("finished %d spans, want 3", len(rec.FinishedSpans()))
+	}
+
+	byName := spansByName(spans)
+	root := byName["middleware.request"]
+	if root.ParentID != "" {
+		t.Errorf("root span has parent %q", root.ParentID)
+	}
+
+	for index, name := range []string{"first", "second"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Fatalf("no span for step %q", name)
+		}
+		// Consecutive steps are siblings under the root span
+		if span.ParentID != root.SpanID || span.TraceID != root.TraceID {
+			t.Errorf("span %q: parent %q trace %q, want parent %q trace %q", name, span.ParentID, span.TraceID, root.SpanID, root.TraceID)
+		}
+		if span.Tags["step.index"] != index+1 {
+			t.Errorf("span %q: step.index = %v, want %d", name, span.Tags["step.index"], index+1)
+		}
+		if span.EndTime.After(root.EndTime) {
+			t.Errorf("span %q finished after the root span", name)
+		}
+	}
+}
+
+func TestObservabilityRecordsStepErrors(t *testing.T) {
+	rec := NewRecordingTracer()
+	errFailed := errors.New("failed")
+	chain := NewChain(ObservabilityWithConfig(tracedConfig(rec))).
+		AppendNamed("fail", func(ctx context.Context, input any) (context.Context, any, error) {
+			return ctx, nil, errFailed
+		})
+
+	if _, _, err := chain.Then(context.Background(), "input"); !errors.Is(err, errFailed) {
+		t.Fatalf("err = %v, want %v", err, errFailed)
+	}
+
+	byName := spansByName(rec.FinishedSpans())
+	if step := byName["fail"]; !errors.Is(step.Err, errFailed) {
+		t.Errorf("step span error = %v, want %v", step.Err, errFailed)
+	}
+	if root := byName["middleware.request"]; root.Err == nil {
+		t.Error("root span has no error")
+	}
+}
+
+func TestNestedChainSpansAreChildrenOfEnclosingStep(t *testing.T) {
+	rec := NewRecordingTracer()
+	inner := NewChain().AppendNamed("inner", passThrough)
+	chain := NewChain(ObservabilityWithConfig(tracedConfig(rec))).
+		AppendNamed("outer", func(ctx context.Context, input any) (context.Context, any, error) {
+			return inner.Then(ctx, input)
+		})
+
+	if _, _, err := chain.Then(context.Background(), "input"); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := spansByName(rec.FinishedSpans())
+	outer, innerSpan := byName["outer"], byName["inner"]
+	if innerSpan.ParentID != outer.SpanID {
+		t.Errorf("inner span parent = %q, want outer span %q", innerSpan.ParentID, outer.SpanID)
+	}
+	if innerSpan.TraceID != outer.TraceID {
+		t.Errorf("inner span trace = %q, want %q", innerSpan.TraceID, outer.TraceID)
+	}
+}
+
+func TestStepSpanIsActiveInStep(t *testing.T) {
+	rec := NewRecordingTracer()
+	var active Span
+	chain := NewChain(ObservabilityWithConfig(tracedConfig(rec))).
+		AppendNamed("inspect", func(ctx context.Context, input any) (context.Context, any, error) {
+			active, _ = SpanFromContext(ctx)
+			return ctx, input, nil
+		})
+
+	if _, _, err := chain.Then(context.Background(), "input"); err != nil {
+		t.Fatal(err)
+	}
+
+	step := spansByName(rec.Spans())["inspect"]
+	if active == nil || active.SpanID() != step.SpanID {
+		t.Errorf("active span in step = %v, want the step span %q", active, step.SpanID)
+	}
+}
+
+func TestObservabilityOutsideChainKeepsSpanOpen(t *testing.T) {
+	rec := NewRecordingTracer()
+	config := tracedConfig(rec)
+	observe, complete := ObservabilityWithConfig(config), ObservabilityCompleteWithConfig(config)
+
+	ctx, _, err := observe(context.Background(), "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spans := rec.FinishedSpans(); len(spans) != 0 {
+		t.Fatalf("span finished before the request completed: %+v", spans)
+	}
+
+	if _, _, err := complete(ctx, "output"); err != nil {
+		t.Fatal(err)
+	}
+	spans := rec.FinishedSpans()
+	if len(spans) != 1 || spans[0].Name != "middleware.request" {
+		t.Fatalf("finished spans = %+v, want the request span", spans)
+	}
+	if _, ok := spans[0].Tags["duration.ms"]; !ok {
+		t.Error("request span has no duration tag")
+	}
+}